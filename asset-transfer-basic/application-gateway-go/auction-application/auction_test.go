@@ -0,0 +1,304 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 発電者のオークションのテスト
+// go test auction_test.go producer.go producer_func.go producer_withdraw.go producer_forward.go producer_bulk.go
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"assetTransfer/auction-application/clock"
+	"assetTransfer/auction-application/fabrictest"
+	"assetTransfer/auction-application/txsubmit"
+	"assetTransfer/auction-application/webhook"
+)
+
+var jst = time.FixedZone("JST", 9*60*60)
+
+type auctionTest struct {
+	clock    *clock.Fake
+	ledger   *fabrictest.Ledger
+	producer txsubmit.Contract
+	consumer txsubmit.Contract
+	outbox   string
+}
+
+// newAuctionTest starts a ledger on a fake clock, which is also the clock of the
+// producer, with a producer of Org1 and a consumer of Org2.
+func newAuctionTest(t *testing.T) *auctionTest {
+	t.Helper()
+	test := &auctionTest{
+		clock:  clock.NewFake(time.Date(2022, 11, 6, 16, 0, 0, 0, jst)),
+		outbox: filepath.Join(t.TempDir(), "outbox.json"),
+	}
+	appClock = test.clock
+	t.Cleanup(func() { appClock = clock.System })
+
+	var err error
+	dispatcher, err = webhook.NewDispatcher(test.outbox, webhookEndpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.ledger, err = fabrictest.NewLedger(test.clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.producer, err = test.ledger.Contract("Org1MSP", "User1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.consumer, err = test.ledger.Contract("Org2MSP", "User2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = test.producer.Submit("InitLedger", txsubmit.DefaultOptions); err != nil {
+		t.Fatal(err)
+	}
+	return test
+}
+
+// runAuction runs Auction until it returns, moving the clock to the end of the round
+// whenever the auction waits for it.
+func (test *auctionTest) runAuction(energy Energy, timestamp time.Time, input Input) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Auction(test.producer, energy, timestamp, input)
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(time.Millisecond):
+			if test.clock.Waiting(1) {
+				test.clock.Advance(auctionEndInterval * time.Minute)
+			}
+		}
+	}
+}
+
+func (test *auctionTest) token(t *testing.T, id string) Energy {
+	t.Helper()
+	var energy Energy
+	if err := json.Unmarshal(test.ledger.State(id), &energy); err != nil {
+		t.Fatal(err)
+	}
+	return energy
+}
+
+// notifications returns the payloads queued for the webhook endpoint.
+func (test *auctionTest) notifications(t *testing.T, endpoint string) []json.RawMessage {
+	t.Helper()
+	outboxJSON, err := os.ReadFile(test.outbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deliveries []webhook.Delivery
+	if err = json.Unmarshal(outboxJSON, &deliveries); err != nil {
+		t.Fatal(err)
+	}
+	var payloads []json.RawMessage
+	for _, delivery := range deliveries {
+		if delivery.Endpoint == endpoint {
+			payloads = append(payloads, delivery.Payload)
+		}
+	}
+	return payloads
+}
+
+func TestAuctionSellsToTheHighestBidder(t *testing.T) {
+	test := newAuctionTest(t)
+	input := Input{Latitude: 35.5, Longitude: 139.6, User: "User1", Category: "solar", logger: logger}
+
+	energy, timestamp := Create(test.producer, input)
+	if energy.Error != "" {
+		t.Fatal(energy.Error)
+	}
+	if energy.Status != "generated" || energy.UnitPrice != 0.02 {
+		t.Fatalf("unexpected token %+v", energy)
+	}
+
+	test.clock.Advance(time.Minute)
+	result, err := test.consumer.Submit("BidOnToken", txsubmit.DefaultOptions,
+		energy.ID, "User2", "0.03", test.clock.Now().Format(layout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Payload) != "your bid was successful" {
+		t.Fatalf("unexpected bid result %s", result.Payload)
+	}
+
+	test.runAuction(energy, timestamp, input)
+
+	sold := test.token(t, energy.ID)
+	if sold.Status != "sold" || sold.Owner != "User2" || sold.BidPrice != 0.03 {
+		t.Errorf("unexpected token %+v", sold)
+	}
+	notifications := test.notifications(t, "auction")
+	if len(notifications) != 1 {
+		t.Fatalf("expected 1 auction notification, got %d", len(notifications))
+	}
+	var notification struct{ WinnerCarId, TokenId string }
+	if err = json.Unmarshal(notifications[0], &notification); err != nil {
+		t.Fatal(err)
+	}
+	if notification.WinnerCarId != "User2" || notification.TokenId != energy.ID {
+		t.Errorf("unexpected notification %+v", notification)
+	}
+}
+
+func TestAuctionDiscountsUnsoldTokensBeforeTheLastRound(t *testing.T) {
+	test := newAuctionTest(t)
+	input := Input{Latitude: 35.5, Longitude: 139.6, User: "User1", Category: "solar", logger: logger}
+
+	energy, timestamp := Create(test.producer, input)
+	if energy.Error != "" {
+		t.Fatal(energy.Error)
+	}
+
+	test.runAuction(energy, timestamp, input)
+
+	old := test.token(t, energy.ID)
+	if old.Status != "old" || old.Owner != "User1" {
+		t.Errorf("unexpected token %+v", old)
+	}
+	if old.UnitPrice != 0.02*0.8 {
+		t.Errorf("expected the unit price to be discounted to %v, got %v", 0.02*0.8, old.UnitPrice)
+	}
+	notifications := test.notifications(t, "auction")
+	if len(notifications) != 1 {
+		t.Fatalf("expected 1 auction notification, got %d", len(notifications))
+	}
+	var notification struct{ WinnerCarId, TokenId string }
+	if err := json.Unmarshal(notifications[0], &notification); err != nil {
+		t.Fatal(err)
+	}
+	if notification.WinnerCarId != "-1" {
+		t.Errorf("expected no winner, got %+v", notification)
+	}
+}
+
+func TestForwardIsSoldAndSettledWithAPenaltyForTheShortfall(t *testing.T) {
+	test := newAuctionTest(t)
+	deliveryStart := test.clock.Now().Add(2 * time.Hour)
+	input := ForwardInput{Latitude: 35.5, Longitude: 139.6, User: "User1", Category: "solar",
+		Quantity: 10, Forecast: 12, DeliveryStart: deliveryStart, DeliveryEnd: deliveryStart.Add(time.Hour), logger: logger}
+
+	forward, err := CreateForward(test.producer, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !forward.AuctionEndTime.Equal(deliveryStart.Add(-30 * time.Minute)) {
+		t.Errorf("expected the auction to end 30min before the delivery, got %v", forward.AuctionEndTime)
+	}
+	if _, err = CreateForward(test.producer, ForwardInput{User: "User1", Category: "solar", Quantity: 13, Forecast: 12,
+		DeliveryStart: deliveryStart, DeliveryEnd: deliveryStart.Add(time.Hour), logger: logger}); err == nil {
+		t.Error("a forward exceeding the forecast was created")
+	}
+
+	result, err := test.consumer.Submit("BidOnForward", txsubmit.DefaultOptions, forward.ID, "User2", "0.05",
+		test.clock.Now().Format(layout))
+	if err != nil || string(result.Payload) != "your bid was successful" {
+		t.Fatalf("bid failed: %v %s", err, result.Payload)
+	}
+
+	test.clock.Set(forward.AuctionEndTime)
+	ForwardAuction(test.producer, forward, input)
+	sold, err := readForward(test.producer, forward.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sold.Status != "sold" || sold.Owner != "User2" || sold.Collateral != 10*0.05*0.5 {
+		t.Fatalf("unexpected forward %+v", sold)
+	}
+
+	admin, err := test.ledger.Contract("Org1MSP", "Admin", map[string]string{"market.admin": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = admin.Submit("SetMeterOracle", txsubmit.DefaultOptions, "Org1MSP"); err != nil {
+		t.Fatal(err)
+	}
+	meter, err := test.ledger.Contract("Org1MSP", "Meter1", map[string]string{"meter.oracle": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	settledAt := deliveryStart.Add(time.Hour).Format(layout)
+	if _, err = meter.Submit("SettleForward", txsubmit.DefaultOptions, forward.ID, "meter-1",
+		strconv.FormatFloat(9, 'f', -1, 64), settledAt); err != nil {
+		t.Fatal(err)
+	}
+	settled, err := readForward(test.producer, forward.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1kWh short at 0.05 per kWh
+	if settled.Status != "settled" || settled.Delivered != 9 || settled.Penalty != 0.05 {
+		t.Errorf("unexpected settled forward %+v", settled)
+	}
+}
+
+func TestCreateTokensIssuesTheTokensInOneTransaction(t *testing.T) {
+	test := newAuctionTest(t)
+	input := Input{Latitude: 35.5, Longitude: 139.6, User: "User1", Category: "solar", Tokens: 3, logger: logger}
+
+	energies, timestamp, err := CreateTokens(test.producer, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(energies) != 3 {
+		t.Fatalf("expected 3 tokens, got %+v", energies)
+	}
+	for _, energy := range energies {
+		if energy.Error != "" {
+			t.Fatal(energy.Error)
+		}
+		token := test.token(t, energy.ID)
+		if token.Status != "generated" || token.Owner != "User1" || !token.GeneratedTime.Equal(timestamp) {
+			t.Errorf("unexpected token %+v", token)
+		}
+	}
+}
+
+func TestCreateTokensStopsAtTheQuotaOfTheProducer(t *testing.T) {
+	test := newAuctionTest(t)
+	admin, err := test.ledger.Contract("Org1MSP", "Admin", map[string]string{"market.admin": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = admin.Submit("SetMarketQuota", txsubmit.DefaultOptions, "2", "0"); err != nil {
+		t.Fatal(err)
+	}
+	input := Input{Latitude: 35.5, Longitude: 139.6, User: "User1", Category: "solar", Tokens: 3, logger: logger}
+
+	energies, _, err := CreateTokens(test.producer, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if energies[0].Error != "" || energies[1].Error != "" {
+		t.Fatalf("expected the first 2 tokens to be created, got %+v", energies)
+	}
+	if !strings.Contains(energies[2].Error, "tokens per hour is used up") {
+		t.Errorf("expected the third token to exceed the quota, got %+v", energies[2])
+	}
+	// the quota is per hour
+	test.clock.Advance(time.Hour)
+	input.Tokens = 0
+	if energy, _ := Create(test.producer, input); energy.Error != "" {
+		t.Errorf("expected a token in the next hour, got %v", energy.Error)
+	}
+}
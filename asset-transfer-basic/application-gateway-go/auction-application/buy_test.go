@@ -0,0 +1,480 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 需要家の入札のテスト
+// go test buy_test.go consumer.go consumer_func.go consumer_autobid.go consumer_location.go consumer_resale.go consumer_certificate.go consumer_forward.go consumer_bulk.go consumer_planner.go
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"assetTransfer/auction-application/clock"
+	"assetTransfer/auction-application/fabrictest"
+	"assetTransfer/auction-application/txsubmit"
+	"assetTransfer/auction-application/webhook"
+)
+
+var jst = time.FixedZone("JST", 9*60*60)
+
+type buyTest struct {
+	clock    *clock.Fake
+	ledger   *fabrictest.Ledger
+	producer txsubmit.Contract
+	consumer txsubmit.Contract
+}
+
+// newBuyTest starts a ledger on a fake clock, which is also the clock of the consumer,
+// with a producer of Org1 and a consumer of Org2.
+func newBuyTest(t *testing.T) *buyTest {
+	t.Helper()
+	test := &buyTest{clock: clock.NewFake(time.Date(2022, 11, 6, 16, 0, 0, 0, jst))}
+	appClock = test.clock
+	t.Cleanup(func() { appClock = clock.System })
+
+	var err error
+	dispatcher, err = webhook.NewDispatcher(filepath.Join(t.TempDir(), "outbox.json"), webhookEndpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.ledger, err = fabrictest.NewLedger(test.clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.producer, err = test.ledger.Contract("Org1MSP", "User1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.consumer, err = test.ledger.Contract("Org2MSP", "User2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = test.producer.Submit("InitLedger", txsubmit.DefaultOptions); err != nil {
+		t.Fatal(err)
+	}
+	return test
+}
+
+func (test *buyTest) createToken(t *testing.T, id string, latitude float64, longitude float64, timestamp time.Time) {
+	t.Helper()
+	_, err := test.producer.Submit("CreateToken", txsubmit.DefaultOptions, id,
+		strconv.FormatFloat(latitude, 'f', -1, 64), strconv.FormatFloat(longitude, 'f', -1, 64),
+		"User1", "green", "solar", timestamp.Format(layout))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (test *buyTest) token(t *testing.T, id string) Energy {
+	t.Helper()
+	var energy Energy
+	if err := json.Unmarshal(test.ledger.State(id), &energy); err != nil {
+		t.Fatal(err)
+	}
+	return energy
+}
+
+// waitFor waits for the goroutines started by the code under test to make cond true.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBuyBidsOnTokensInRangeAndInTheirFirstRound(t *testing.T) {
+	test := newBuyTest(t)
+	test.createToken(t, "near", 35.5, 139.6, test.clock.Now().Add(-2*time.Minute))
+	test.createToken(t, "far", 35.6, 139.6, test.clock.Now().Add(-2*time.Minute))
+	test.createToken(t, "started", 35.5, 139.6, test.clock.Now().Add(-10*time.Minute))
+
+	// 2.5km with half of the battery left
+	input := Input{Token: 2, BatteryLife: 50, Latitude: 35.501, Longitude: 139.601, User: "User2", logger: logger}
+	success, err := Buy(test.consumer, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(success) != 1 || success[0].ID != "near" {
+		t.Fatalf("expected a successful bid on the near token, got %+v", success)
+	}
+	near := test.token(t, "near")
+	if near.Owner != "User2" || near.BidPrice <= near.UnitPrice {
+		t.Errorf("unexpected token %+v", near)
+	}
+	for _, id := range []string{"far", "started"} {
+		if token := test.token(t, id); token.Owner != "User1" {
+			t.Errorf("expected no bid on the %s token, got %+v", id, token)
+		}
+	}
+	// the location of the bidder is only kept in the private data of its org
+	if _, err = test.consumer.Evaluate("ReadBidLocation", "near", "User2"); err != nil {
+		t.Errorf("the location of the bid was not stored in the private data of Org2: %v", err)
+	}
+	if _, err = test.producer.Evaluate("ReadBidLocation", "near", "User2"); err == nil {
+		t.Error("the location of the bid was readable by Org1")
+	}
+}
+
+func TestBidResultWaitsForTheEndOfTheAuction(t *testing.T) {
+	test := newBuyTest(t)
+	test.createToken(t, "near", 35.5, 139.6, test.clock.Now().Add(-2*time.Minute))
+	input := Input{Token: 1, BatteryLife: 50, Latitude: 35.501, Longitude: 139.601, User: "User2", logger: logger}
+	success, err := Buy(test.consumer, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		BidResult(test.consumer, success, input)
+	}()
+
+	test.clock.BlockUntil(1)
+	select {
+	case <-done:
+		t.Fatal("BidResult returned before the end of the auction")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	test.clock.Advance(3 * time.Minute)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("BidResult did not return at the end of the auction")
+	}
+}
+
+// closableContract fails the test when it is used after close, as a contract whose
+// Gateway connection was closed.
+type closableContract struct {
+	txsubmit.Contract
+	t      *testing.T
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *closableContract) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+}
+
+func (c *closableContract) check(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		c.t.Errorf("%s was called after the connection was closed", name)
+	}
+}
+
+func (c *closableContract) Evaluate(name string, args ...string) ([]byte, error) {
+	c.check(name)
+	return c.Contract.Evaluate(name, args...)
+}
+
+func (c *closableContract) Submit(name string, options txsubmit.Options, args ...string) (*txsubmit.Result, error) {
+	c.check(name)
+	return c.Contract.Submit(name, options, args...)
+}
+
+func TestFollowBidsOutbidsOthersUpToTheMaxPriceBeforeReturning(t *testing.T) {
+	test := newBuyTest(t)
+	test.createToken(t, "near", 35.5, 139.6, test.clock.Now().Add(-2*time.Minute))
+	input := Input{Token: 1, BatteryLife: 50, Latitude: 35.501, Longitude: 139.601, MaxPrice: 1, User: "User2", logger: logger}
+	success, err := Buy(test.consumer, input)
+	if err != nil || len(success) != 1 {
+		t.Fatalf("bid failed: %v %+v", err, success)
+	}
+	other, err := test.ledger.Contract("Org2MSP", "User3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherInput := Input{Latitude: 35.501, Longitude: 139.601, User: "User3", logger: logger}
+	if message, err := bidOnToken(other, "near", 0.5, otherInput); err != nil || message != "your bid was successful" {
+		t.Fatalf("bid of User3 failed: %v %s", err, message)
+	}
+
+	contract := &closableContract{Contract: test.consumer, t: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		followBids(contract, success, input)
+		contract.close()
+	}()
+
+	// the result of the round and the re-bids wait on the clock
+	test.clock.BlockUntil(2)
+	test.clock.Advance(autoBidInterval * time.Second)
+	waitFor(t, "a counter-bid of User2", func() bool {
+		near := test.token(t, "near")
+		return near.Owner == "User2" && near.BidPrice == 0.5+bidIncrement
+	})
+
+	// above the max price the other bidder keeps the token, and the re-bids stop
+	if message, err := bidOnToken(other, "near", 2, otherInput); err != nil || message != "your bid was successful" {
+		t.Fatalf("bid of User3 failed: %v %s", err, message)
+	}
+	test.clock.BlockUntil(2)
+	test.clock.Advance(autoBidInterval * time.Second)
+	waitFor(t, "the re-bids to stop", func() bool { return !test.clock.Waiting(2) })
+	if near := test.token(t, "near"); near.Owner != "User3" {
+		t.Errorf("expected no counter-bid above the max price, got %+v", near)
+	}
+
+	test.clock.Advance(3 * time.Minute)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("followBids did not return at the end of the auction")
+	}
+}
+
+func TestBuyBidsOnSeveralTokensInOneTransaction(t *testing.T) {
+	test := newBuyTest(t)
+	for _, id := range []string{"near1", "near2", "near3"} {
+		test.createToken(t, id, 35.5, 139.6, test.clock.Now().Add(-2*time.Minute))
+	}
+
+	input := Input{Token: 2, BatteryLife: 50, Latitude: 35.501, Longitude: 139.601, User: "User2", logger: logger}
+	success, err := Buy(test.consumer, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(success) != 2 {
+		t.Fatalf("expected 2 successful bids, got %+v", success)
+	}
+	for _, energy := range success {
+		if token := test.token(t, energy.ID); token.Owner != "User2" {
+			t.Errorf("unexpected token %+v", token)
+		}
+		if _, err = test.consumer.Evaluate("ReadBidLocation", energy.ID, "User2"); err != nil {
+			t.Errorf("the location of the bid on %s was not stored: %v", energy.ID, err)
+		}
+	}
+}
+
+func TestBidOnTokensReportsTheResultOfEachBid(t *testing.T) {
+	test := newBuyTest(t)
+	test.createToken(t, "near", 35.5, 139.6, test.clock.Now().Add(-2*time.Minute))
+	test.createToken(t, "started", 35.5, 139.6, test.clock.Now().Add(-10*time.Minute))
+
+	input := Input{Latitude: 35.501, Longitude: 139.601, User: "User2", logger: logger}
+	energies := []Energy{{ID: "near", BidPrice: 1}, {ID: "started", BidPrice: 1}, {ID: "missing", BidPrice: 1}, {ID: "near", BidPrice: 2}}
+	results, err := bidOnTokens(test.consumer, energies, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %+v", results)
+	}
+	if results[0].Message != "your bid was successful" || results[0].Error != "" {
+		t.Errorf("unexpected result of the bid on the near token %+v", results[0])
+	}
+	if results[1].Message != "the auction of energy started was started more than 5min ago" {
+		t.Errorf("unexpected result of the bid on the started token %+v", results[1])
+	}
+	for _, result := range results[2:] {
+		if result.Error == "" {
+			t.Errorf("expected an error, got %+v", result)
+		}
+	}
+	if near := test.token(t, "near"); near.BidPrice != 1 {
+		t.Errorf("expected the first bid on the near token only, got %+v", near)
+	}
+}
+
+func TestBidsStopAtTheOpenBidsQuotaOfTheConsumer(t *testing.T) {
+	test := newBuyTest(t)
+	for _, id := range []string{"near1", "near2", "near3"} {
+		test.createToken(t, id, 35.5, 139.6, test.clock.Now().Add(-2*time.Minute))
+	}
+	admin, err := test.ledger.Contract("Org1MSP", "Admin", map[string]string{"market.admin": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = admin.Submit("SetMarketQuota", txsubmit.DefaultOptions, "0", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	input := Input{Latitude: 35.501, Longitude: 139.601, User: "User2", logger: logger}
+	energies := []Energy{{ID: "near1", BidPrice: 1}, {ID: "near2", BidPrice: 1}, {ID: "near1", BidPrice: 2}}
+	for _, energy := range energies {
+		if message, err := bidOnToken(test.consumer, energy.ID, energy.BidPrice, input); err != nil || message != "your bid was successful" {
+			t.Fatalf("bid on %s failed: %v %s", energy.ID, err, message)
+		}
+	}
+	if _, err = bidOnToken(test.consumer, "near3", 1, input); err == nil || !strings.Contains(err.Error(), "open bids is used up") {
+		t.Fatalf("expected the third open bid to exceed the quota, got %v", err)
+	}
+
+	// an outbid token frees an open bid
+	other, err := test.ledger.Contract("Org2MSP", "User3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bidOnToken(other, "near2", 3, Input{Latitude: 35.501, Longitude: 139.601, User: "User3", logger: logger}); err != nil {
+		t.Fatal(err)
+	}
+	if message, err := bidOnToken(test.consumer, "near3", 1, input); err != nil || message != "your bid was successful" {
+		t.Errorf("expected a bid after being outbid, got %v %s", err, message)
+	}
+}
+
+func TestPlanChargingBidsOnTheCheapestTokensWithinReachOfTheRoute(t *testing.T) {
+	test := newBuyTest(t)
+	generated := test.clock.Now().Add(-2 * time.Minute)
+	test.createToken(t, "start", 35.5, 139.6, generated)
+	test.createToken(t, "waypoint", 35.6, 139.6, generated)
+	test.createToken(t, "detour", 35.55, 139.65, generated)
+	test.createToken(t, "beyond", 35.7, 139.6, generated)
+
+	// 2kWh to charge with 12km left, along a route of 11km
+	input := PlanInput{Capacity: 40, StateOfCharge: 5, TargetStateOfCharge: 10,
+		Departure: test.clock.Now().Add(time.Hour),
+		Route:     []Waypoint{{Latitude: 35.5, Longitude: 139.6}, {Latitude: 35.6, Longitude: 139.6}},
+		User:      "User2", logger: logger}
+	plan, err := PlanCharging(test.consumer, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plan.NeededEnergy != 2 || plan.PlannedEnergy != 2 || len(plan.Slots) != 2 || len(plan.Bids) != 2 {
+		t.Fatalf("expected 2 tokens in the plan, got %+v", plan)
+	}
+	for _, slot := range plan.Slots {
+		if slot.ID != "start" && slot.ID != "waypoint" {
+			t.Errorf("unexpected slot %+v", slot)
+		}
+		if slot.Result != "your bid was successful" || test.token(t, slot.ID).Owner != "User2" {
+			t.Errorf("expected a successful bid on %s, got %+v", slot.ID, slot)
+		}
+	}
+	// charged one after the other once the auctions end, 10min per kWh at 6kW
+	auctionEnd := generated.Add(5 * time.Minute)
+	if !plan.Slots[0].Start.Equal(auctionEnd) || !plan.Slots[1].Start.Equal(auctionEnd.Add(10*time.Minute)) {
+		t.Errorf("unexpected schedule %+v", plan.Slots)
+	}
+	for _, id := range []string{"detour", "beyond"} {
+		if token := test.token(t, id); token.Owner != "User1" {
+			t.Errorf("expected no bid on the %s token, got %+v", id, token)
+		}
+	}
+}
+
+func TestPlanSlotsKeepsTheChargingBeforeTheDeadlines(t *testing.T) {
+	start := time.Date(2022, 11, 6, 16, 0, 0, 0, jst)
+	departure := start.Add(30 * time.Minute)
+	candidates := []ChargingSlot{
+		// the cheapest, but its delivery window is too short for 3kWh at 6kW
+		{Kind: "forward", ID: "short", Energy: 3, BidPrice: 0.01, from: start, until: start.Add(20 * time.Minute)},
+		{Kind: "token", ID: "late", Energy: 1, BidPrice: 0.02, from: departure.Add(-5 * time.Minute), until: departure},
+		{Kind: "token", ID: "early1", Energy: 1, BidPrice: 0.03, from: start, until: departure},
+		{Kind: "token", ID: "early2", Energy: 1, BidPrice: 0.04, from: start, until: departure},
+		{Kind: "token", ID: "early3", Energy: 1, BidPrice: 0.05, from: start, until: departure},
+	}
+
+	slots := planSlots(candidates, 3, 10, 6)
+
+	ids := []string{}
+	for _, slot := range slots {
+		ids = append(ids, slot.ID)
+	}
+	// late is charged from 16:25 to 16:35, after the departure
+	if strings.Join(ids, ",") != "early1,early2,early3" {
+		t.Errorf("unexpected slots %v", ids)
+	}
+	if !slots[2].End.Equal(start.Add(30 * time.Minute)) {
+		t.Errorf("expected the last slot to end at the departure, got %+v", slots[2])
+	}
+}
+
+func TestTokensAreEndorsedByTheOrgsOfTheProducerAndTheBidder(t *testing.T) {
+	test := newBuyTest(t)
+	test.createToken(t, "near", 35.5, 139.6, test.clock.Now().Add(-2*time.Minute))
+	endorsers := func() string {
+		t.Helper()
+		result, err := test.consumer.Evaluate("GetEndorsementPolicy", "near")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var orgs []string
+		if err = json.Unmarshal(result, &orgs); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(orgs)
+		return strings.Join(orgs, ",")
+	}
+	if orgs := endorsers(); orgs != "Org1MSP" {
+		t.Errorf("expected the new token to be endorsed by the producer's org, got %s", orgs)
+	}
+
+	input := Input{Token: 1, BatteryLife: 50, Latitude: 35.501, Longitude: 139.601, User: "User2", logger: logger}
+	if success, err := Buy(test.consumer, input); err != nil || len(success) != 1 {
+		t.Fatalf("bid failed: %v %+v", err, success)
+	}
+	if orgs := endorsers(); orgs != "Org1MSP,Org2MSP" {
+		t.Errorf("expected the token bid on to be endorsed by both orgs, got %s", orgs)
+	}
+
+	test.clock.Advance(5 * time.Minute)
+	result, err := test.producer.Submit("AuctionEnd", txsubmit.DefaultOptions, "near", "User1", test.clock.Now().Format(layout))
+	if err != nil || string(result.Payload) != "the energy near was sold" {
+		t.Fatalf("auction end failed: %v %+v", err, result)
+	}
+	if orgs := endorsers(); orgs != "Org1MSP,Org2MSP" {
+		t.Errorf("expected the sold token to be endorsed by both orgs, got %s", orgs)
+	}
+}
+
+func TestTheBidWebhookCarriesOnlyTheGeohashOfTheConsumer(t *testing.T) {
+	outboxPath := filepath.Join(t.TempDir(), "outbox.json")
+	var err error
+	dispatcher, err = webhook.NewDispatcher(outboxPath, webhookEndpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := Input{BatteryLife: 50, Latitude: 35.501, Longitude: 139.601, User: "User2", logger: logger}
+
+	httpPost(Energy{ID: "energy1", BidPrice: 0.0312345}, input)
+
+	outbox, err := os.ReadFile(outboxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deliveries []webhook.Delivery
+	if err = json.Unmarshal(outbox, &deliveries); err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Endpoint != "bid" {
+		t.Fatalf("unexpected deliveries %+v", deliveries)
+	}
+	var payload map[string]interface{}
+	if err = json.Unmarshal(deliveries[0].Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["CarGeohash"] != "xn73b" || payload["TokenId"] != "energy1" || payload["Price"] != 0.03123 {
+		t.Errorf("unexpected payload %v", payload)
+	}
+	if strings.Contains(string(deliveries[0].Payload), "35.501") {
+		t.Errorf("the payload carries the coordinates of the consumer: %s", deliveries[0].Payload)
+	}
+}
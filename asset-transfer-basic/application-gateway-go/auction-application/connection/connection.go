@@ -0,0 +1,120 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package connection connects the auction applications to a Gateway peer of the test
+// network, signing with an identity from the wallet.
+package connection
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"assetTransfer/auction-application/wallet"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	ChannelName   = "mychannel"
+	ChaincodeName = "basic"
+)
+
+// Peer is a Gateway peer and the TLS CA certificate it is verified with.
+type Peer struct {
+	Endpoint    string
+	Name        string // TLS server name
+	TLSCertPath string
+}
+
+var (
+	Org1Peer = Peer{
+		Endpoint:    "localhost:7051",
+		Name:        "peer0.org1.example.com",
+		TLSCertPath: "../../../test-network/organizations/peerOrganizations/org1.example.com/peers/peer0.org1.example.com/tls/ca.crt",
+	}
+	Org2Peer = Peer{
+		Endpoint:    "localhost:9051",
+		Name:        "peer0.org2.example.com",
+		TLSCertPath: "../../../test-network/organizations/peerOrganizations/org2.example.com/peers/peer0.org2.example.com/tls/ca.crt",
+	}
+)
+
+// Dial creates a gRPC connection to the peer. It should be shared by all Gateway
+// connections to this peer.
+func (p Peer) Dial() (*grpc.ClientConn, error) {
+	certificatePEM, err := ioutil.ReadFile(p.TLSCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file: %w", err)
+	}
+	certificate, err := identity.CertificateFromPEM(certificatePEM)
+	if err != nil {
+		return nil, err
+	}
+
+	certPool := x509.NewCertPool()
+	certPool.AddCert(certificate)
+	transportCredentials := credentials.NewClientTLSFromCert(certPool, p.Name)
+
+	connection, err := grpc.Dial(p.Endpoint, grpc.WithTransportCredentials(transportCredentials))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC connection: %w", err)
+	}
+	return connection, nil
+}
+
+// DefaultWalletPath is the wallet directory when WALLET_PATH is not set. It is kept apart
+// from the source of the wallet package, as it holds the private keys of the users.
+const DefaultWalletPath = "identities"
+
+// OpenWallet opens the wallet in WALLET_PATH, or DefaultWalletPath if it is not set.
+func OpenWallet() (*wallet.Wallet, error) {
+	walletPath := os.Getenv("WALLET_PATH")
+	if walletPath == "" {
+		walletPath = DefaultWalletPath
+	}
+	return wallet.New(walletPath)
+}
+
+// Connect creates a Gateway connection for the identity of user.
+func Connect(clientConnection *grpc.ClientConn, user *wallet.Identity) (*client.Gateway, error) {
+	id, err := user.X509Identity()
+	if err != nil {
+		return nil, err
+	}
+	sign, err := user.Sign()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Connect(
+		id,
+		client.WithSign(sign),
+		client.WithClientConnection(clientConnection),
+		// Default timeouts for different gRPC calls
+		client.WithEvaluateTimeout(5*time.Second),
+		client.WithEndorseTimeout(15*time.Second),
+		client.WithSubmitTimeout(5*time.Second),
+		client.WithCommitStatusTimeout(1*time.Minute),
+	)
+}
+
+// ConnectLabel creates a Gateway connection for the wallet identity with label.
+func ConnectLabel(clientConnection *grpc.ClientConn, label string) (*client.Gateway, error) {
+	userWallet, err := OpenWallet()
+	if err != nil {
+		return nil, err
+	}
+	user, err := userWallet.Get(label)
+	if err != nil {
+		return nil, err
+	}
+	return Connect(clientConnection, user)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package connection
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"assetTransfer/auction-application/wallet"
+)
+
+// newIdentity returns a self-signed certificate and its private key, in PEM.
+func newIdentity(t *testing.T, commonName string) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificateDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestDialReadsTheTLSCertificateOfThePeer(t *testing.T) {
+	certificatePEM, _ := newIdentity(t, "peer0.org1.example.com")
+	certPath := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(certPath, certificatePEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the gRPC connection is established lazily, so no peer needs to listen
+	peer := Peer{Endpoint: "localhost:7051", Name: "peer0.org1.example.com", TLSCertPath: certPath}
+	clientConnection, err := peer.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConnection.Close()
+
+	peer.TLSCertPath = filepath.Join(t.TempDir(), "missing.crt")
+	if _, err = peer.Dial(); err == nil || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing certificate file, got %v", err)
+	}
+}
+
+func TestConnectLabelSignsWithTheIdentityOfTheWallet(t *testing.T) {
+	wallet.ConfigureHSM(nil)
+	walletPath := t.TempDir()
+	t.Setenv("WALLET_PATH", walletPath)
+	userWallet, err := wallet.New(walletPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificatePEM, keyPEM := newIdentity(t, "Operator")
+	err = userWallet.Put(&wallet.Identity{Label: "Operator", MspID: "Org1MSP", Certificate: string(certificatePEM), PrivateKey: string(keyPEM)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caPath := filepath.Join(t.TempDir(), "ca.crt")
+	if err = os.WriteFile(caPath, certificatePEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	clientConnection, err := Peer{Endpoint: "localhost:7051", Name: "Operator", TLSCertPath: caPath}.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConnection.Close()
+
+	gateway, err := ConnectLabel(clientConnection, "Operator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mspID := gateway.Identity().MspID(); mspID != "Org1MSP" {
+		t.Errorf("expected the identity of Org1MSP, got %s", mspID)
+	}
+	gateway.Close()
+
+	if _, err = ConnectLabel(clientConnection, "Nobody"); !errors.Is(err, wallet.ErrNotFound) {
+		t.Errorf("expected an unknown identity, got %v", err)
+	}
+}
@@ -9,32 +9,21 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
-	"crypto/x509"
-	"fmt"
 	"io/ioutil"
-	"log"
-	"path"
+	"os"
 	"time"
 	"net/http"
 	"encoding/json"
 	"bytes"
 
-	"github.com/hyperledger/fabric-gateway/pkg/client"
-	"github.com/hyperledger/fabric-gateway/pkg/identity"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-)
-
-const (
-	mspID         = "Org2MSP"
-	cryptoPath    = "../../../test-network/organizations/peerOrganizations/org2.example.com"
-	certPath      = cryptoPath + "/users/User1@org2.example.com/msp/signcerts/cert.pem"
-	keyPath       = cryptoPath + "/users/User1@org2.example.com/msp/keystore/"
-	tlsCertPath   = cryptoPath + "/peers/peer0.org2.example.com/tls/ca.crt"
-	peerEndpoint  = "localhost:9051"
-	gatewayPeer   = "peer0.org2.example.com"
-	channelName   = "mychannel"
-	chaincodeName = "basic"
+	"assetTransfer/auction-application/clock"
+	"assetTransfer/auction-application/connection"
+	"assetTransfer/auction-application/logging"
+	"assetTransfer/auction-application/metrics"
+	"assetTransfer/auction-application/ratelimit"
+	"assetTransfer/auction-application/txsubmit"
+	"assetTransfer/auction-application/wallet"
+	"assetTransfer/auction-application/webhook"
 )
 
 type Input struct {
@@ -43,6 +32,9 @@ type Input struct {
 	Latitude         float64   `json:"latitude"`
 	Longitude        float64   `json:"longitude"`
 	User            string    `json:"user"`
+	MaxPrice         float64   `json:"maxPrice"` // optional: enables automatic re-bidding up to this unit price
+	// logs of the request, tagged with its request ID
+	logger           *logging.Logger
 }
 
 type Return struct {
@@ -65,6 +57,28 @@ type Return struct {
 
 var now = time.Now()
 
+// clock of the auctions, replaced by a fake clock in tests
+var appClock = clock.System
+
+// simulator notifications; endpoints can be overridden with a JSON file in WEBHOOK_CONFIG
+var webhookEndpoints = []webhook.Endpoint{
+	{Name: "bid", URL: "http://localhost:8090/bid"},
+	{Name: "bidList", URL: "https://webhook.site/ba5e750f-7ffd-437b-962b-02ea67be8ca6"},
+}
+
+var dispatcher *webhook.Dispatcher
+
+// log level from LOG_LEVEL
+var logger = logging.New("consumer")
+
+// identities of the users; requests are signed by the identity of the authenticated user
+var userWallet *wallet.Wallet
+
+// requests per minute of a client IP and of an authenticated user, overridden with
+// RATE_LIMIT_IP and RATE_LIMIT_USER; 0 disables the limit
+var ipLimiter = ratelimit.FromEnv("ip", 120)
+var userLimiter = ratelimit.FromEnv("user", 60)
+
 func main() {
 	/*var input Input
 	input.Token = 10
@@ -75,19 +89,51 @@ func main() {
 
 	bidContract(input)*/
 
-	log.Println("============ application-golang starts ============")
-	http.HandleFunc("/bidOnToken", handler)
-	http.ListenAndServe(":9080", nil)
-	log.Println("============ application-golang ends ============")
+	logging.SetDefault(logger)
+	logger.Info("application-golang starts")
+	endpoints, err := webhook.LoadEndpoints(os.Getenv("WEBHOOK_CONFIG"), webhookEndpoints)
+	if err != nil {
+		panic(err)
+	}
+	dispatcher, err = webhook.NewDispatcher("consumer-webhook-outbox.json", endpoints)
+	if err != nil {
+		panic(err)
+	}
+	dispatcher.Start()
+
+	userWallet, err = connection.OpenWallet()
+	if err != nil {
+		panic(err)
+	}
+	// the HSM sessions of SIGNER=pkcs11
+	defer wallet.Close()
+
+	http.Handle("/bidOnToken", logger.Middleware(ipLimiter.Middleware(userLimiter.UserMiddleware(userWallet.Authenticate, http.HandlerFunc(handler)))))
+	http.Handle("/listForResale", logger.Middleware(ipLimiter.Middleware(userLimiter.UserMiddleware(userWallet.Authenticate, resaleHandler(listForResale)))))
+	http.Handle("/bidOnResale", logger.Middleware(ipLimiter.Middleware(userLimiter.UserMiddleware(userWallet.Authenticate, resaleHandler(bidOnResale)))))
+	http.Handle("/transferToken", logger.Middleware(ipLimiter.Middleware(userLimiter.UserMiddleware(userWallet.Authenticate, resaleHandler(transferToken)))))
+	http.Handle("/consume", logger.Middleware(ipLimiter.Middleware(userLimiter.UserMiddleware(userWallet.Authenticate, resaleHandler(consume)))))
+	http.Handle("/transferCertificate", logger.Middleware(ipLimiter.Middleware(userLimiter.UserMiddleware(userWallet.Authenticate, resaleHandler(transferCertificate)))))
+	http.Handle("/retireCertificate", logger.Middleware(ipLimiter.Middleware(userLimiter.UserMiddleware(userWallet.Authenticate, resaleHandler(retireCertificate)))))
+	http.Handle("/planCharging", logger.Middleware(ipLimiter.Middleware(userLimiter.UserMiddleware(userWallet.Authenticate, http.HandlerFunc(planHandler)))))
+	http.Handle("/bidOnForward", logger.Middleware(ipLimiter.Middleware(userLimiter.UserMiddleware(userWallet.Authenticate, resaleHandler(bidOnForward)))))
+	http.Handle("/metrics", metrics.Handler())
+	// the dead letters are listed, replayed and purged from this host only
+	go func() {
+		err := http.ListenAndServe("127.0.0.1:9081", logger.Middleware(dispatcher.AdminHandler()))
+		logger.Error("webhook admin ends", "error", err)
+	}()
+	err = http.ListenAndServe(":9080", nil)
+	logger.Error("application-golang ends", "error", err)
 }
 
 func handler(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("hadler")
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed) //405
 		w.Write([]byte("Only POST"))
 		return
 	}
+	user := wallet.FromContext(r.Context())
 	if r.Header.Get("Content-Type") != "application/json; charset=utf-8" {
 		w.WriteHeader(http.StatusBadRequest) //400
 		w.Write([]byte("Only json"))
@@ -103,15 +149,19 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	err = json.Unmarshal(body, &requestInput)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError) //500
-		fmt.Println("Unmarshal error")
-		fmt.Println(err.Error())
 		w.Write([]byte(err.Error()))
 		return
 	}
-	successList, err := bidContract(requestInput)
+	if requestInput.User != "" && requestInput.User != user.Label {
+		w.WriteHeader(http.StatusForbidden) //403
+		w.Write([]byte("user does not match the authenticated identity"))
+		return
+	}
+	requestInput.User = user.Label
+	requestInput.logger = logging.FromContext(r.Context()).With("user", user.Label)
+	successList, err := bidContract(requestInput, user)
 	if err != nil {
-		fmt.Println("bidContract")
-		fmt.Println(err.Error())
+		requestInput.logger.Error("bid request failed", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
@@ -120,8 +170,6 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	var buf bytes.Buffer
 	enc := json.NewEncoder(&buf)
 	if err = enc.Encode(&successList); err != nil {
-		fmt.Println("Encode")
-		fmt.Println(err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
@@ -130,144 +178,59 @@ func handler(w http.ResponseWriter, r *http.Request) {
 
 	if len(successList) > 0 {
 		// go HttpPostBidToken(successList)
-		go bidResultContract(successList, requestInput)
+		go bidResultContract(successList, requestInput, user)
 	}
 }
 
-func bidContract(input Input) ([]Energy, error) {
+func bidContract(input Input, user *wallet.Identity) ([]Energy, error) {
 	var energies []Energy
 	// The gRPC client connection should be shared by all Gateway connections to this endpoint
-	clientConnection := newGrpcConnection()
+	clientConnection, err := connection.Org2Peer.Dial()
+	if err != nil {
+		return energies, err
+	}
 	defer clientConnection.Close()
 
-	id := newIdentity()
-	sign := newSign()
-
 	// Create a Gateway connection for a specific client identity
-	gateway, err := client.Connect(
-		id,
-		client.WithSign(sign),
-		client.WithClientConnection(clientConnection),
-		// Default timeouts for different gRPC calls
-		client.WithEvaluateTimeout(5*time.Second),
-		client.WithEndorseTimeout(15*time.Second),
-		client.WithSubmitTimeout(5*time.Second),
-		client.WithCommitStatusTimeout(1*time.Minute),
-	)
+	gateway, err := connection.Connect(clientConnection, user)
 	if err != nil {
-		fmt.Println("gatewayerror")
 		return energies, err
 	}
 	defer gateway.Close()
 
-	network := gateway.GetNetwork(channelName)
-	contract := network.GetContract(chaincodeName)
+	network := gateway.GetNetwork(connection.ChannelName)
+	contract := txsubmit.NewContract(network.GetContract(connection.ChaincodeName))
 
 	//fmt.Println("initLedger:")
 	//InitLedger(contract)
 
 	successList, err := Buy(contract, input)
 	if (err != nil) {
-		fmt.Println("buy error")
 		return energies, err
 	}
 	return successList, nil
 }
 
-func bidResultContract(successList []Energy, input Input) {
+func bidResultContract(successList []Energy, input Input, user *wallet.Identity) {
 	// The gRPC client connection should be shared by all Gateway connections to this endpoint
-	clientConnection := newGrpcConnection()
+	clientConnection, err := connection.Org2Peer.Dial()
+	if err != nil {
+		input.logger.Error("failed to connect to the gateway", "error", err)
+		return
+	}
 	defer clientConnection.Close()
 
-	id := newIdentity()
-	sign := newSign()
-
 	// Create a Gateway connection for a specific client identity
-	gateway, err := client.Connect(
-		id,
-		client.WithSign(sign),
-		client.WithClientConnection(clientConnection),
-		// Default timeouts for different gRPC calls
-		client.WithEvaluateTimeout(5*time.Second),
-		client.WithEndorseTimeout(15*time.Second),
-		client.WithSubmitTimeout(5*time.Second),
-		client.WithCommitStatusTimeout(1*time.Minute),
-	)
+	gateway, err := connection.Connect(clientConnection, user)
 	if err != nil {
 		// httpで通知？
-		fmt.Println(err)
+		input.logger.Error("failed to connect to the gateway", "error", err)
+		return
 	}
 	defer gateway.Close()
 
-	network := gateway.GetNetwork(channelName)
-	contract := network.GetContract(chaincodeName)
-
-	BidResult(contract, successList, input)
-}
-
-// newGrpcConnection creates a gRPC connection to the Gateway server.
-func newGrpcConnection() *grpc.ClientConn {
-	certificate, err := loadCertificate(tlsCertPath)
-	if err != nil {
-		panic(err)
-	}
-
-	certPool := x509.NewCertPool()
-	certPool.AddCert(certificate)
-	transportCredentials := credentials.NewClientTLSFromCert(certPool, gatewayPeer)
-
-	connection, err := grpc.Dial(peerEndpoint, grpc.WithTransportCredentials(transportCredentials))
-	if err != nil {
-		panic(fmt.Errorf("failed to create gRPC connection: %w", err))
-	}
-
-	return connection
-}
-
-// newIdentity creates a client identity for this Gateway connection using an X.509 certificate.
-func newIdentity() *identity.X509Identity {
-	certificate, err := loadCertificate(certPath)
-	if err != nil {
-		panic(err)
-	}
-
-	id, err := identity.NewX509Identity(mspID, certificate)
-	if err != nil {
-		panic(err)
-	}
-
-	return id
-}
-
-func loadCertificate(filename string) (*x509.Certificate, error) {
-	certificatePEM, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read certificate file: %w", err)
-	}
-	return identity.CertificateFromPEM(certificatePEM)
-}
-
-// newSign creates a function that generates a digital signature from a message digest using a private key.
-func newSign() identity.Sign {
-	files, err := ioutil.ReadDir(keyPath)
-	if err != nil {
-		panic(fmt.Errorf("failed to read private key directory: %w", err))
-	}
-	privateKeyPEM, err := ioutil.ReadFile(path.Join(keyPath, files[0].Name()))
-
-	if err != nil {
-		panic(fmt.Errorf("failed to read private key file: %w", err))
-	}
-
-	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
-	if err != nil {
-		panic(err)
-	}
-
-	sign, err := identity.NewPrivateKeySign(privateKey)
-	if err != nil {
-		panic(err)
-	}
+	network := gateway.GetNetwork(connection.ChannelName)
+	contract := txsubmit.NewContract(network.GetContract(connection.ChaincodeName))
 
-	return sign
+	followBids(contract, successList, input)
 }
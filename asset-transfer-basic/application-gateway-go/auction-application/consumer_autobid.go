@@ -0,0 +1,92 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 需要家
+// 自動再入札 (proxy bidding)
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"assetTransfer/auction-application/txsubmit"
+)
+
+const (
+	autoBidInterval = 10     // seconds between checks of the current highest bid
+	bidIncrement    = 0.0001 // amount added to the current highest bid when outbid
+)
+
+// followBids re-bids on the tokens up to input.MaxPrice, if it is set, and reports the
+// results at the end of their rounds. It returns once AutoBid has stopped too, so that
+// the caller can close the connection of contract.
+func followBids(contract txsubmit.Contract, successEnergy []Energy, input Input) {
+	var wg sync.WaitGroup
+	if input.MaxPrice > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			AutoBid(contract, successEnergy, input)
+		}()
+	}
+	BidResult(contract, successEnergy, input)
+	wg.Wait()
+}
+
+// AutoBid watches the tokens the consumer is currently winning and submits
+// counter-bids whenever another user outbids it, up to input.MaxPrice per token,
+// until the auction round of each token closes.
+func AutoBid(contract txsubmit.Contract, successEnergy []Energy, input Input) {
+	var wg sync.WaitGroup
+	wg.Add(len(successEnergy))
+	for i := 0; i < len(successEnergy); i++ {
+		go func(energy Energy) {
+			defer wg.Done()
+			autoBidToken(contract, energy, input)
+		}(successEnergy[i])
+	}
+	wg.Wait()
+}
+
+func autoBidToken(contract txsubmit.Contract, energy Energy, input Input) {
+	auctionEndTime := energy.AuctionStartTime.Add(time.Minute * 5)
+	ticker := appClock.NewTicker(time.Second * autoBidInterval)
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		// leave a margin so the counter-bid is not rejected for being after the round
+		if appClock.Now().Add(time.Second * autoBidInterval).After(auctionEndTime) {
+			input.logger.Debug("autoBid stopped, the auction round is closing", "id", energy.ID)
+			return
+		}
+
+		current, err := readToken(contract, energy.ID)
+		if err != nil {
+			input.logger.Warn("autoBid failed to read the token", "id", energy.ID, "error", err)
+			continue
+		}
+		if current.Status != "generated" {
+			input.logger.Debug("autoBid stopped", "id", energy.ID, "status", current.Status)
+			return
+		}
+		if current.Owner == input.User {
+			continue
+		}
+
+		nextBidPrice := current.BidPrice + bidIncrement
+		if nextBidPrice > input.MaxPrice {
+			input.logger.Info("autoBid outbid over max price", "id", energy.ID, "bid_price", current.BidPrice, "max_price", input.MaxPrice)
+			return
+		}
+
+		message, err := bidOnToken(contract, energy.ID, nextBidPrice, input)
+		if err != nil {
+			input.logger.Warn("autoBid failed", "id", energy.ID, "error", err)
+			continue
+		}
+		input.logger.Debug("autoBid", "id", energy.ID, "bid_price", nextBidPrice, "result", message)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 需要家
+// 複数トークンへの一括入札 (BidOnTokens)
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"assetTransfer/auction-application/txsubmit"
+)
+
+// maxBidsPerTransaction is the limit of the chaincode on the items of a bulk transaction
+const maxBidsPerTransaction = 50
+
+type BidSpec struct {
+	ID       string  `json:"ID"`
+	BidPrice float64 `json:"Bid Price"`
+}
+
+// BulkResult is the result of one item of BidOnTokens
+type BulkResult struct {
+	ID      string `json:"ID"`
+	Message string `json:"Message"`
+	Error   string `json:"Error"`
+}
+
+// bidBulk bids on the energies in as few transactions as possible and returns the
+// tokens we are the highest bidder of, as bid does.
+func bidBulk(contract txsubmit.Contract, energies []Energy, input Input) []Energy {
+	successEnergy := []Energy{}
+	for start := 0; start < len(energies); start += maxBidsPerTransaction {
+		end := start + maxBidsPerTransaction
+		if end > len(energies) {
+			end = len(energies)
+		}
+		batch := energies[start:end]
+		results, err := bidOnTokens(contract, batch, input)
+		if err != nil {
+			continue
+		}
+
+		for i, result := range results {
+			if result.Message != "your bid was successful" {
+				continue
+			}
+			go httpPost(batch[i], input)
+			bidResult, err := readToken(contract, result.ID)
+			if err != nil {
+				input.logger.Error("failed to read the token", "id", result.ID, "error", err)
+				continue
+			}
+			if bidResult.Owner == input.User {
+				successEnergy = append(successEnergy, bidResult)
+			}
+		}
+	}
+	return successEnergy
+}
+
+// bidOnTokens submits the bids on energies at their BidPrice in one transaction; the
+// results are in the order of energies.
+func bidOnTokens(contract txsubmit.Contract, energies []Energy, input Input) ([]BulkResult, error) {
+	var timestamp = appClock.Now()
+	bids := make([]BidSpec, len(energies))
+	for i, energy := range energies {
+		bids[i] = BidSpec{ID: energy.ID, BidPrice: energy.BidPrice}
+	}
+	bidsJSON, err := json.Marshal(bids)
+	if err != nil {
+		return nil, err
+	}
+	// the location is only kept as private data of our org, as for a single bid
+	location, err := locationTransient(input)
+	if err != nil {
+		return nil, err
+	}
+	options := txsubmit.DefaultOptions
+	options.Transient = location
+	options.Logger = input.logger
+	result, err := contract.Submit("BidOnTokens", options, input.User, string(bidsJSON), timestamp.Format(layout))
+	if err != nil {
+		bidsTotal.Add(float64(len(bids)), "error")
+		for _, detail := range txsubmit.Details(err) {
+			input.logger.Error("bids failed", "count", len(bids), "detail", detail)
+		}
+		return nil, err
+	}
+
+	var results []BulkResult
+	err = json.Unmarshal(result.Payload, &results)
+	if err != nil {
+		bidsTotal.Add(float64(len(bids)), "error")
+		input.logger.Error("failed to parse the results of BidOnTokens", "tx_id", result.TransactionID, "error", err)
+		return nil, fmt.Errorf("failed to parse the results of BidOnTokens: %w", err)
+	}
+	for i, item := range results {
+		switch {
+		case item.Error != "":
+			bidsTotal.Inc("error")
+			input.logger.Error("bid failed", "id", item.ID, "tx_id", result.TransactionID, "error", item.Error)
+		case item.Message == "your bid was successful":
+			bidsTotal.Inc("successful")
+		default:
+			bidsTotal.Inc("rejected")
+		}
+		input.logger.Info("bid submitted", "id", item.ID, "tx_id", result.TransactionID,
+			"bid_price", bids[i].BidPrice, "attempts", result.Attempts, "result", item.Message)
+	}
+	return results, nil
+}
@@ -0,0 +1,36 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 需要家
+// グリーン電力証書の譲渡と償却
+// 証書のIDは "<トークンのID>-certificate"
+
+package main
+
+import (
+	"fmt"
+
+	"assetTransfer/auction-application/txsubmit"
+)
+
+func transferCertificate(contract txsubmit.Contract, input ResaleInput, username string) (string, error) {
+	if input.To == "" {
+		return "", fmt.Errorf("to is required")
+	}
+	_, err := contract.Submit("TransferCertificate", txsubmit.DefaultOptions, input.ID, username, input.To)
+	if err != nil {
+		return "", err
+	}
+	return "the certificate " + input.ID + " was transferred to " + input.To, nil
+}
+
+// retireCertificate claims a certificate for input.To, or for the user if it is empty.
+func retireCertificate(contract txsubmit.Contract, input ResaleInput, username string) (string, error) {
+	_, err := contract.Submit("RetireCertificate", txsubmit.DefaultOptions, input.ID, username, input.To, appClock.Now().Format(layout))
+	if err != nil {
+		return "", err
+	}
+	return "the certificate " + input.ID + " was retired", nil
+}
@@ -0,0 +1,26 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 需要家
+// 先渡しトークンへの入札 (価格はkWhあたり)
+
+package main
+
+import (
+	"strconv"
+
+	"assetTransfer/auction-application/txsubmit"
+)
+
+// bidOnForward bids input.Price per kWh on a forward; its auction closes 30min before
+// the delivery window.
+func bidOnForward(contract txsubmit.Contract, input ResaleInput, username string) (string, error) {
+	var stringPrice = strconv.FormatFloat(input.Price, 'f', -1, 64)
+	result, err := contract.Submit("BidOnForward", txsubmit.DefaultOptions, input.ID, username, stringPrice, appClock.Now().Format(layout))
+	if err != nil {
+		return "", err
+	}
+	return string(result.Payload), nil
+}
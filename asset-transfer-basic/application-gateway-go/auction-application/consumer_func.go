@@ -10,19 +10,18 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
-	"bytes"
 	// "context"
 	"encoding/json"
 	// "errors"
-	"fmt"
 	"time"
 	"strconv"
 	"math"
 	"sort"
 	"sync"
-	"net/http"
 	
-	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"assetTransfer/auction-application/geohash"
+	"assetTransfer/auction-application/metrics"
+	"assetTransfer/auction-application/txsubmit"
 	// "github.com/hyperledger/fabric-protos-go-apiv2/gateway"
 	// "google.golang.org/grpc/status"
 )
@@ -76,20 +75,26 @@ const (
 	// myLongitude = 139.65527497388206
 	// username = "user2"
 	layout = "2006-01-02T15:04:05+09:00"
+	searchGeohashPrecision = 4 // about 39km x 20km, larger than the search range
+	webhookGeohashPrecision = 5 // about 5km, as the geohash of the bidder on the ledger
 )
 
-func Buy(contract *client.Contract, input Input) ([]Energy, error) {
+var bidsTotal = metrics.NewCounter("auction_bids_total",
+	"Bids submitted by the consumer, by outcome (successful, rejected by the chaincode or error).", "outcome")
+
+func Buy(contract txsubmit.Contract, input Input) ([]Energy, error) {
 	// batteryLifeから検索範囲決定
 	searchRange := (100 - float64(input.BatteryLife)) * kmPerBattery * 1000 // 1000m->500mに変更
-	fmt.Printf("searchRange:%g\n", searchRange)
+	input.logger.Debug("searching tokens", "search_range_m", searchRange)
 
 	var tokenNum int = input.Token
 	// var errEnergies []Energy
 
 	lowerLat, upperLat, lowerLng, upperLng := determineRange(searchRange, input.Latitude, input.Longitude)
-	energies, err := queryByLocationRange(contract, lowerLat, upperLat, lowerLng, upperLng)
+	// the peers only see the coarse cells of the search range; the range is filtered below
+	energies, err := queryByGeohash(contract, geohash.Cover(lowerLat, upperLat, lowerLng, upperLng, searchGeohashPrecision))
 	if err != nil {
-		fmt.Println("query error")
+		input.logger.Error("failed to query tokens", "error", err)
 		return energies, err
 	}
 	if(len(energies) == 0){
@@ -97,9 +102,9 @@ func Buy(contract *client.Contract, input Input) ([]Energy, error) {
 	}
 	
 	// fmt.Println(energies)
-	fmt.Printf("length of energies: %d\n", len(energies))
+	input.logger.Debug("tokens found", "count", len(energies))
 
-	timestamp := time.Now()
+	timestamp := appClock.Now()
 	auctionStartTimeCompare := timestamp.Add(time.Minute * -5)
 
 	validEnergies := []Energy{}
@@ -109,13 +114,11 @@ func Buy(contract *client.Contract, input Input) ([]Energy, error) {
 		if energy.Owner != input.User && distance <= searchRange && auctionStartTimeCompare.After(energy.AuctionStartTime) == false {
 			energy.BidPrice = energy.UnitPrice + distance * pricePerMater
 			validEnergies = append(validEnergies, energy)
-			fmt.Println("it's valid")
-			fmt.Printf("id:%s, latitude:%g, longitude:%g, unitPrice:%g, distance:%g, bidPrice:%g\n", 
-			energy.ID, energy.Latitude, energy.Longitude, energy.UnitPrice, distance, energy.BidPrice)
+			input.logger.Debug("valid token", "id", energy.ID, "unit_price", energy.UnitPrice,
+				"distance_m", distance, "bid_price", energy.BidPrice)
 		}else {
-			fmt.Println("it's invalid")
-			fmt.Printf("id:%s, latitude: %g, longitude:%g, unitPrice:%g, distance:%g, auctionStartTime:%s\n",
-		energy.ID, energy.Latitude, energy.Longitude, energy.UnitPrice, distance, energy.AuctionStartTime.Format(layout))
+			input.logger.Debug("invalid token", "id", energy.ID, "unit_price", energy.UnitPrice,
+				"distance_m", distance, "auction_start_time", energy.AuctionStartTime.Format(layout))
 		}
 		
 	}
@@ -134,14 +137,12 @@ func Buy(contract *client.Contract, input Input) ([]Energy, error) {
 		if(tokenNum == 0 || len(validEnergies) == 0) {
 			break
 		}
-		fmt.Printf("requested token:%d\n", tokenNum)
-		fmt.Printf("valid energy token:%d\n", len(validEnergies))
 		if(tokenNum > len(validEnergies)){
 			bidNum = len(validEnergies)
 		}else {
 			bidNum = tokenNum
 		}
-		fmt.Printf("max:%d\n", bidNum)
+		input.logger.Debug("bidding", "requested", tokenNum, "valid", len(validEnergies), "bids", bidNum)
 
 		tempSuccess := bid(contract, validEnergies, bidNum, input)
 
@@ -154,7 +155,7 @@ func Buy(contract *client.Contract, input Input) ([]Energy, error) {
 	
 }
 
-func BidResult(contract *client.Contract, successEnergy []Energy, input Input) { 
+func BidResult(contract txsubmit.Contract, successEnergy []Energy, input Input) { 
 
 	// input:success List, input
 	// const length = len(success)
@@ -177,63 +178,39 @@ func BidResult(contract *client.Contract, successEnergy []Energy, input Input) {
 			defer wg.Done()
 			auctionStartTime := success[i].AuctionStartTime
 			auctionEndTime := auctionStartTime.Add(time.Minute * 5)
-			fmt.Printf("id:%s, auctionEndTime:%s\n", success[i].ID, auctionEndTime.Format(layout))
-			nowTime := time.Now()
-			fmt.Println(nowTime)
-			fmt.Println(auctionEndTime.Sub(nowTime))
-			timer := time.NewTimer(auctionEndTime.Sub(nowTime))
-			<-timer.C
+			<-appClock.After(auctionEndTime.Sub(appClock.Now()))
 			auctionEndToken, err := readToken(contract, success[i].ID)
 			if err != nil {
 				// できたらHTTP
+				input.logger.Error("failed to read the bid result", "id", success[i].ID, "error", err)
 			} else {
 				auctionEndToken.Error = "OK"
 			}
 			if (auctionEndToken.Owner == input.User) {
-				fmt.Println("you are a winner.")
 				success[i].MyBidStatus = "win"
 			} else {
-				fmt.Println("you are a loser.")
 				success[i].MyBidStatus = "lose"
 			}
+			input.logger.Info("bid result", "id", success[i].ID, "result", success[i].MyBidStatus)
 		}(i)
 	}
-	fmt.Println("BidResult:")
-	fmt.Println(success)
 	wg.Wait()
 }
 
 // 現在不使用
 func HttpPostBidToken(energies []Energy) {
-	const URL = "https://webhook.site/ba5e750f-7ffd-437b-962b-02ea67be8ca6"
-
-	/*for _, energy := range energies{
-		httpPost(energy);
-	}*/
-
-	energiesJson, err := json.Marshal(energies)
+	err := dispatcher.Enqueue("bidList", energies)
 	if err != nil {
-		fmt.Println(err)
-	}
-	res, err2 := http.Post(URL, "application/json", bytes.NewBuffer(energiesJson))
-	defer res.Body.Close()
-
-	if err2 != nil {
-		fmt.Println(err2)
-	} else {
-		fmt.Println(res.Status)
+		logger.Error("failed to enqueue webhook", "endpoint", "bidList", "error", err)
 	}
 }
 
 func httpPost(energy Energy, input Input) {
-	const URL = "http://localhost:8090/bid"
-
 	type BidToken struct {
 		CarId string `json:"CarId"`
 		CarEnergy int `json:"CarEnergy"`
 		CarRadius float64 `json:"CarRadius"`
-		CarLat float64 `json:"CarLat"`
-		CarLon float64 `json:"CarLon"`
+		CarGeohash string `json:"CarGeohash"`
 		Price float64 `json:"Price"`
 		TokenId string `json:"TokenId"`
 	}
@@ -242,34 +219,22 @@ func httpPost(energy Energy, input Input) {
 	token.CarId = input.User
 	token.CarEnergy = input.BatteryLife
 	token.CarRadius = (100 - float64(input.BatteryLife)) * kmPerBattery
-	token.CarLat = input.Latitude
-	token.CarLon = input.Longitude
+	// the receiver gets the location no more precisely than the peers do
+	token.CarGeohash = geohash.Encode(input.Latitude, input.Longitude, webhookGeohashPrecision)
 	price := energy.BidPrice
 	token.Price = (math.Round(price * 100000)) / 100000
-	fmt.Println(token.Price);
 	// token.Price = energy.BidPrice
 	token.TokenId = energy.ID
 
-	fmt.Println(token)
-
-	tokenJson, err := json.Marshal(token)
+	err := dispatcher.Enqueue("bid", token)
 	if err != nil {
-		fmt.Println(err)
-	} else {
-		res, err2 := http.Post(URL, "application/json", bytes.NewBuffer(tokenJson))
-		if err2 != nil {
-			fmt.Println(err2)
-		} else {
-			defer res.Body.Close()
-			fmt.Println(res.Status)
-		}
+		input.logger.Error("failed to enqueue webhook", "endpoint", "bid", "id", energy.ID, "error", err)
 	}
 }
 
-func readToken(contract *client.Contract, energyId string) (Energy, error) {
-	fmt.Printf("Async Submit Transaction: ReadToken'\n")
+func readToken(contract txsubmit.Contract, energyId string) (Energy, error) {
 	var result Energy
-	evaluateResult, err := contract.EvaluateTransaction("ReadToken", energyId)
+	evaluateResult, err := contract.Evaluate("ReadToken", energyId)
 	if err != nil {
 		return result, err
 		// panic(fmt.Errorf("failed to evaluate transaction: %w", err))
@@ -289,7 +254,11 @@ func readToken(contract *client.Contract, energyId string) (Energy, error) {
 	//fmt.Printf("*** Result:%s\n", result)
 }
 
-func bid(contract *client.Contract, energies []Energy, bidNum int, input Input) []Energy {
+func bid(contract txsubmit.Contract, energies []Energy, bidNum int, input Input) []Energy {
+	// several bids are placed in one transaction
+	if bidNum > 1 {
+		return bidBulk(contract, energies[:bidNum], input)
+	}
 	successEnergy := []Energy{}
 	//leftEnergy := energies
 	
@@ -298,13 +267,12 @@ func bid(contract *client.Contract, energies []Energy, bidNum int, input Input)
 	for i := 0; i < bidNum; i++ {
 
 		go func(i int, c chan Energy){
-			fmt.Printf("id:%s, auctionStartTime:%s\n", energies[i].ID, energies[i].AuctionStartTime.Format(layout))
-			message, err := bidOnToken(contract, energies[i].ID, energies[i].BidPrice, input.User)
+			message, err := bidOnToken(contract, energies[i].ID, energies[i].BidPrice, input)
 			if err != nil {
 				energies[i].Error = "bidOnTokenError: " + err.Error()
 				c <- energies[i]
+				return
 			}
-			fmt.Println(message)
 			if (message == "your bid was successful") {
 				go httpPost(energies[i], input)
 				bidResult, err := readToken(contract, energies[i].ID)
@@ -312,6 +280,7 @@ func bid(contract *client.Contract, energies []Energy, bidNum int, input Input)
 					energies[i].Error = "readTokenError: " + err.Error()
 					// energies[i].MyBidStatus = err.Error()
 					c <- energies[i]
+					return
 				} else{
 					bidResult.Error = "OK"
 				}
@@ -336,19 +305,41 @@ func bid(contract *client.Contract, energies []Energy, bidNum int, input Input)
 	return successEnergy
 }
 
-func bidOnToken(contract *client.Contract, energyId string, bidPrice float64, username string) (string, error) {
+func bidOnToken(contract txsubmit.Contract, energyId string, bidPrice float64, input Input) (string, error) {
 	//fmt.Printf("Evaluate Transaction: BidOnToken, function returns asset attributes\n")
-	var timestamp = time.Now()
+	var timestamp = appClock.Now()
 	var stringTimestamp = timestamp.Format(layout)
 	var stringBidPrice = strconv.FormatFloat(bidPrice, 'f', -1, 64)
 	//fmt.Printf("id:%s, timestamp:%s, price:%s\n", energyId, stringTimestamp, stringBidPrice)
-	evaluateResult, err := contract.SubmitTransaction("BidOnToken", energyId, username, stringBidPrice, stringTimestamp)
+	// concurrent bids on the same token conflict at commit (MVCC_READ_CONFLICT), so the
+	// bid is endorsed again against the new highest bid before giving up
+	// the location is passed in the transient map, so that it is only kept as private data of our org;
+	// the chaincode uses it for the grid capacity between zones and the distance-based price
+	location, err := locationTransient(input)
+	if err != nil {
+		return "", err
+	}
+	options := txsubmit.DefaultOptions
+	options.Transient = location
+	options.Logger = input.logger
+	result, err := contract.Submit("BidOnTokenPrivate", options, energyId, input.User, stringBidPrice, stringTimestamp)
 	if err != nil {
+		bidsTotal.Inc("error")
+		for _, detail := range txsubmit.Details(err) {
+			input.logger.Error("bid failed", "id", energyId, "detail", detail)
+		}
 		return "", err
 		// panic(fmt.Errorf("failed to evaluate transaction: %w", err))
 	}
 	//result := formatJSON(evaluateResult)
-	message := string(evaluateResult)
+	message := string(result.Payload)
+	if message == "your bid was successful" {
+		bidsTotal.Inc("successful")
+	} else {
+		bidsTotal.Inc("rejected")
+	}
+	input.logger.Info("bid submitted", "id", energyId, "tx_id", result.TransactionID,
+		"bid_price", bidPrice, "attempts", result.Attempts, "result", message)
 	/* "your bid was successful" */
 	return message, nil
 }
@@ -379,32 +370,48 @@ func determineRange(length float64, myLatitude float64, myLongitude float64) (lo
 	returnUpperLat := 2 * myLatitude - math.Abs(lowerLat) //緯度が0のとき、lowerLatがマイナスなため。日本は関係ないが。
 
 
-	fmt.Printf("lowerLng:%g\n", returnLowerLat)
-	fmt.Printf("uperLng:%g\n", returnUpperLat)
-	fmt.Printf("lowerLng:%g\n", returnLowerLng)
-	fmt.Printf("uperLng:%g\n", returnUpperLng)
+	logger.Debug("search range", "lower_lat", returnLowerLat, "upper_lat", returnUpperLat,
+		"lower_lng", returnLowerLng, "upper_lng", returnUpperLng)
 
 	return returnLowerLat, returnUpperLat, returnLowerLng, returnUpperLng
 
 }
 
-func queryByLocationRange(contract *client.Contract, lowerLat float64, upperLat float64, lowerLng float64, upperLng float64) ([]Energy, error) {
+// queryByGeohash returns the generated tokens in the geohash cells.
+func queryByGeohash(contract txsubmit.Contract, cells []string) ([]Energy, error) {
+	result := []Energy{}
+	for _, cell := range cells {
+		evaluateResult, err := contract.Evaluate("QueryByGeohash", "generated", cell)
+		if err != nil {
+			return result, err
+		}
+		if len(evaluateResult) == 0 {
+			continue
+		}
+
+		var energies []Energy
+		err = json.Unmarshal(evaluateResult, &energies)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, energies...)
+	}
+	return result, nil
+}
+
+func queryByLocationRange(contract txsubmit.Contract, lowerLat float64, upperLat float64, lowerLng float64, upperLng float64) ([]Energy, error) {
 	strLowerLat := strconv.FormatFloat(lowerLat, 'f', -1, 64)
 	strUpperLat := strconv.FormatFloat(upperLat, 'f', -1, 64)
 	strLowerLng := strconv.FormatFloat(lowerLng, 'f', -1, 64)
 	strUpperLng := strconv.FormatFloat(upperLng, 'f', -1, 64)
 
-	fmt.Printf("Async Submit Transaction: QueryByLocationRange'\n")
-
 	result := []Energy{}
-	evaluateResult, err := contract.EvaluateTransaction("QueryByLocationRange", "generated", strLowerLat, strUpperLat, strLowerLng, strUpperLng)
+	evaluateResult, err := contract.Evaluate("QueryByLocationRange", "generated", strLowerLat, strUpperLat, strLowerLng, strUpperLng)
 	if err != nil {
 		return result, err
 		// panic(fmt.Errorf("failed to evaluate transaction: %w", err))
 	}
 
-	fmt.Println(len(evaluateResult))
-
 	err = json.Unmarshal(evaluateResult, &result)
 	if(err != nil && len(evaluateResult) > 0) {
 		return result, err
@@ -431,7 +438,8 @@ func distance(lat1 float64, lng1 float64, lat2 float64, lng2 float64) float64 {
 		math.Cos(rlat1) * math.Cos(rlat2) *
 		math.Cos(rlng1 - rlng2)
 
-	r := math.Acos(angle)
+	// rounding can put the cosine of the same point above 1
+	r := math.Acos(math.Min(angle, 1))
 	distance := earthRadius * r
 	
 	return distance
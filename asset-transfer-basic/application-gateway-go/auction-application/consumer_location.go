@@ -0,0 +1,40 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 需要家
+// 位置情報はtransient mapで渡し、自組織のプライベートデータとしてのみ保存する
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// BidLocation is passed to BidOnTokenPrivate in the transient map.
+type BidLocation struct {
+	Latitude  float64 `json:"Latitude"`
+	Longitude float64 `json:"Longitude"`
+	Salt      string  `json:"Salt"`
+}
+
+// locationTransient returns the transient data carrying the location of input.
+// Each bid gets a new salt, so that bids from the same place have different hashes.
+func locationTransient(input Input) (map[string][]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	locationJSON, err := json.Marshal(BidLocation{
+		Latitude:  input.Latitude,
+		Longitude: input.Longitude,
+		Salt:      hex.EncodeToString(salt),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{"location": locationJSON}, nil
+}
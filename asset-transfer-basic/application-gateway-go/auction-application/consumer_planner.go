@@ -0,0 +1,485 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 需要家
+// EVの充電計画: 容量、目標SoC、出発時刻、経路から入札するトークンと充電時間帯を決める
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"assetTransfer/auction-application/geohash"
+	"assetTransfer/auction-application/connection"
+	"assetTransfer/auction-application/logging"
+	"assetTransfer/auction-application/txsubmit"
+	"assetTransfer/auction-application/wallet"
+)
+
+const (
+	tokenEnergy          = 1.0 // kWh per token, as in the chaincode
+	defaultEfficiency    = 6.0 // km per kWh
+	defaultChargingPower = 6.0 // kW
+	spotAuctionLength    = 5   // minutes
+)
+
+// Waypoint is a point of the route of the vehicle.
+type Waypoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// PlanInput is a charging request: the battery must reach TargetStateOfCharge before
+// Departure, charging at stations within the range of the remaining charge along Route,
+// whose first point is the current position.
+type PlanInput struct {
+	Capacity            float64    `json:"capacity"`            // kWh
+	StateOfCharge       float64    `json:"stateOfCharge"`       // %
+	TargetStateOfCharge float64    `json:"targetStateOfCharge"` // %
+	Departure           time.Time  `json:"departure"`
+	Route               []Waypoint `json:"route"`
+	Efficiency          float64    `json:"efficiency"`    // optional: km per kWh, 6 by default
+	ChargingPower       float64    `json:"chargingPower"` // optional: kW, 6 by default
+	MaxPrice            float64    `json:"maxPrice"`      // optional: highest price per kWh, also the limit of the re-bids
+	User                string     `json:"user"`
+	// logs of the request, tagged with its request ID
+	logger *logging.Logger
+}
+
+// ChargingSlot is the energy of a token or a forward and when the vehicle charges it.
+type ChargingSlot struct {
+	Kind      string    `json:"Kind"` // "token" or "forward"
+	ID        string    `json:"ID"`
+	Energy    float64   `json:"Energy"`    // kWh
+	BidPrice  float64   `json:"Bid Price"` // per kWh
+	Cost      float64   `json:"Cost"`
+	Latitude  float64   `json:"Latitude"`
+	Longitude float64   `json:"Longitude"`
+	Distance  float64   `json:"Distance"` // m driven along the route to the station
+	Start     time.Time `json:"Start"`
+	End       time.Time `json:"End"`
+	Result    string    `json:"Result"` // result of the bid
+	// the energy can be charged between from and until
+	from  time.Time
+	until time.Time
+	// the waypoint the station is reached from, which is the location of the bid
+	waypoint int
+}
+
+// ChargingPlan is the plan returned to the vehicle, with the bids placed for it.
+type ChargingPlan struct {
+	NeededEnergy  float64        `json:"Needed Energy"`  // kWh
+	PlannedEnergy float64        `json:"Planned Energy"` // kWh
+	Cost          float64        `json:"Cost"`
+	Slots         []ChargingSlot `json:"Slots"`
+	Bids          []Energy       `json:"Bids"` // tokens we are the highest bidder of
+}
+
+// ConsumerForward is the part of a forward the planner needs.
+type ConsumerForward struct {
+	ID             string    `json:"ID"`
+	Producer       string    `json:"Producer"`
+	Latitude       float64   `json:"Latitude"`
+	Longitude      float64   `json:"Longitude"`
+	Quantity       float64   `json:"Quantity"`
+	DeliveryStart  time.Time `json:"Delivery Start"`
+	DeliveryEnd    time.Time `json:"Delivery End"`
+	UnitPrice      float64   `json:"Unit Price"`
+	BidPrice       float64   `json:"Bid Price"`
+	AuctionEndTime time.Time `json:"Auction End Time"`
+}
+
+func planHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed) //405
+		w.Write([]byte("Only POST"))
+		return
+	}
+	user := wallet.FromContext(r.Context())
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest) //400
+		w.Write([]byte(err.Error()))
+		return
+	}
+	var requestInput PlanInput
+	if err = json.Unmarshal(body, &requestInput); err != nil {
+		w.WriteHeader(http.StatusBadRequest) //400
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if requestInput.User != "" && requestInput.User != user.Label {
+		w.WriteHeader(http.StatusForbidden) //403
+		w.Write([]byte("user does not match the authenticated identity"))
+		return
+	}
+	requestInput.User = user.Label
+	requestInput.logger = logging.FromContext(r.Context()).With("user", user.Label)
+	if err = requestInput.validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest) //400
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	plan, err := planContract(requestInput, user)
+	if err != nil {
+		requestInput.logger.Error("charging plan failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var buf bytes.Buffer
+	if err = json.NewEncoder(&buf).Encode(&plan); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Write(buf.Bytes())
+
+	// the results are followed, and the bids raised up to maxPrice, from the location of
+	// each bid
+	waypoints := map[string]int{}
+	for _, slot := range plan.Slots {
+		waypoints[slot.ID] = slot.waypoint
+	}
+	bids := map[int][]Energy{}
+	for _, energy := range plan.Bids {
+		bids[waypoints[energy.ID]] = append(bids[waypoints[energy.ID]], energy)
+	}
+	for waypoint, energies := range bids {
+		go bidResultContract(energies, requestInput.bidInput(waypoint), user)
+	}
+}
+
+func planContract(input PlanInput, user *wallet.Identity) (ChargingPlan, error) {
+	// The gRPC client connection should be shared by all Gateway connections to this endpoint
+	clientConnection, err := connection.Org2Peer.Dial()
+	if err != nil {
+		return ChargingPlan{}, err
+	}
+	defer clientConnection.Close()
+
+	// Create a Gateway connection for a specific client identity
+	gateway, err := connection.Connect(clientConnection, user)
+	if err != nil {
+		return ChargingPlan{}, err
+	}
+	defer gateway.Close()
+
+	network := gateway.GetNetwork(connection.ChannelName)
+	contract := txsubmit.NewContract(network.GetContract(connection.ChaincodeName))
+
+	return PlanCharging(contract, input)
+}
+
+func (input *PlanInput) validate() error {
+	if input.Capacity <= 0 {
+		return fmt.Errorf("capacity must be positive")
+	}
+	if input.StateOfCharge < 0 || input.TargetStateOfCharge > 100 || input.StateOfCharge >= input.TargetStateOfCharge {
+		return fmt.Errorf("stateOfCharge must be below targetStateOfCharge, between 0 and 100")
+	}
+	if len(input.Route) == 0 {
+		return fmt.Errorf("route needs at least the current position")
+	}
+	if !input.Departure.After(appClock.Now()) {
+		return fmt.Errorf("departure must be in the future")
+	}
+	if input.Efficiency <= 0 {
+		input.Efficiency = defaultEfficiency
+	}
+	if input.ChargingPower <= 0 {
+		input.ChargingPower = defaultChargingPower
+	}
+	return nil
+}
+
+// bidInput is the bid request of the tokens of the plan reached from a waypoint.
+func (input PlanInput) bidInput(waypoint int) Input {
+	return Input{
+		BatteryLife: int(input.StateOfCharge),
+		Latitude:    input.Route[waypoint].Latitude,
+		Longitude:   input.Route[waypoint].Longitude,
+		User:        input.User,
+		MaxPrice:    input.MaxPrice,
+		logger:      input.logger,
+	}
+}
+
+// PlanCharging selects the cheapest tokens and forwards that the vehicle can reach with
+// its remaining charge and charge before its departure, bids on them and returns the
+// plan. A station is reachable if the distance driven along the route to the nearest
+// waypoint, plus the distance from the waypoint, is within the range of the battery.
+func PlanCharging(contract txsubmit.Contract, input PlanInput) (ChargingPlan, error) {
+	if err := input.validate(); err != nil {
+		return ChargingPlan{}, err
+	}
+	plan := ChargingPlan{
+		NeededEnergy: input.Capacity * (input.TargetStateOfCharge - input.StateOfCharge) / 100,
+		Slots:        []ChargingSlot{},
+		Bids:         []Energy{},
+	}
+	headroom := input.Capacity * (100 - input.StateOfCharge) / 100
+	reach := input.Capacity * input.StateOfCharge / 100 * input.Efficiency * 1000 // m
+	driven := routeDistances(input.Route)
+
+	energies, err := queryAlongRoute(contract, input.Route, driven, reach)
+	if err != nil {
+		return plan, err
+	}
+	forwards, err := queryOpenForwards(contract)
+	if err != nil {
+		return plan, err
+	}
+
+	now := appClock.Now()
+	candidates := []ChargingSlot{}
+	tokens := map[string]Energy{}
+	for _, energy := range energies {
+		// the first round of the auction is still open
+		if energy.Owner == input.User || now.Sub(energy.AuctionStartTime) > spotAuctionLength*time.Minute {
+			continue
+		}
+		slot, ok := input.slot(driven, reach, energy.Latitude, energy.Longitude, energy.UnitPrice, energy.BidPrice)
+		if !ok {
+			continue
+		}
+		slot.Kind, slot.ID, slot.Energy = "token", energy.ID, tokenEnergy
+		slot.from = energy.AuctionStartTime.Add(spotAuctionLength * time.Minute)
+		slot.until = input.Departure
+		candidates = append(candidates, slot)
+		tokens[energy.ID] = energy
+	}
+	for _, forward := range forwards {
+		if forward.Producer == input.User || !now.Before(forward.AuctionEndTime) || !forward.DeliveryStart.Before(input.Departure) {
+			continue
+		}
+		slot, ok := input.slot(driven, reach, forward.Latitude, forward.Longitude, forward.UnitPrice, forward.BidPrice)
+		if !ok {
+			continue
+		}
+		slot.Kind, slot.ID, slot.Energy = "forward", forward.ID, forward.Quantity
+		slot.from = forward.DeliveryStart
+		slot.until = forward.DeliveryEnd
+		if input.Departure.Before(slot.until) {
+			slot.until = input.Departure
+		}
+		candidates = append(candidates, slot)
+	}
+	input.logger.Debug("charging candidates", "count", len(candidates), "needed_kwh", plan.NeededEnergy, "reach_m", reach)
+
+	plan.Slots = planSlots(candidates, plan.NeededEnergy, headroom, input.ChargingPower)
+
+	// the tokens reached from the same waypoint are bid on together from there, as
+	// Buy does from the current position
+	bidEnergies := map[int][]Energy{}
+	waypoints := []int{}
+	for _, slot := range plan.Slots {
+		if slot.Kind != "token" {
+			continue
+		}
+		if _, ok := bidEnergies[slot.waypoint]; !ok {
+			waypoints = append(waypoints, slot.waypoint)
+		}
+		energy := tokens[slot.ID]
+		energy.BidPrice = slot.BidPrice
+		bidEnergies[slot.waypoint] = append(bidEnergies[slot.waypoint], energy)
+	}
+	leading := map[string]bool{}
+	for _, waypoint := range waypoints {
+		energies := bidEnergies[waypoint]
+		success := bid(contract, energies, len(energies), input.bidInput(waypoint))
+		plan.Bids = append(plan.Bids, success...)
+		for _, energy := range success {
+			leading[energy.ID] = true
+		}
+	}
+
+	for i := range plan.Slots {
+		slot := &plan.Slots[i]
+		switch slot.Kind {
+		case "token":
+			slot.Result = "your bid was not successful"
+			if leading[slot.ID] {
+				slot.Result = "your bid was successful"
+			}
+		case "forward":
+			message, err := bidOnForward(contract, ResaleInput{ID: slot.ID, Price: slot.BidPrice}, input.User)
+			if err != nil {
+				message = err.Error()
+			}
+			slot.Result = message
+		}
+		if slot.Result == "your bid was successful" {
+			plan.PlannedEnergy += slot.Energy
+			plan.Cost += slot.Cost
+		}
+		input.logger.Info("charging slot", "kind", slot.Kind, "id", slot.ID, "bid_price", slot.BidPrice,
+			"start", slot.Start.Format(layout), "result", slot.Result)
+	}
+	return plan, nil
+}
+
+// slot prices the energy of a station for the vehicle: the unit price plus the distance
+// price of Buy from the nearest waypoint it can be reached from, above the current
+// highest bid. The chaincode checks the distance price against the location of the bid,
+// so the bid is placed from that waypoint.
+func (input PlanInput) slot(driven []float64, reach float64, latitude float64, longitude float64,
+	unitPrice float64, highestBid float64) (ChargingSlot, bool) {
+	best, detour, from := 0.0, math.Inf(1), 0
+	for i, waypoint := range input.Route {
+		if driven[i] > reach {
+			break
+		}
+		d := distance(waypoint.Latitude, waypoint.Longitude, latitude, longitude)
+		if driven[i]+d <= reach && d < detour {
+			best, detour, from = driven[i]+d, d, i
+		}
+	}
+	if math.IsInf(detour, 1) {
+		return ChargingSlot{}, false
+	}
+	price := unitPrice + detour*pricePerMater
+	if price <= highestBid {
+		price = highestBid + bidIncrement
+	}
+	if input.MaxPrice > 0 && price > input.MaxPrice {
+		return ChargingSlot{}, false
+	}
+	return ChargingSlot{BidPrice: price, Latitude: latitude, Longitude: longitude, Distance: best, waypoint: from}, true
+}
+
+// planSlots takes the cheapest candidates per kWh until the needed energy is reached,
+// skipping those that would overfill the battery or cannot be charged in time with the
+// ones already taken, and schedules their charging.
+func planSlots(candidates []ChargingSlot, needed float64, headroom float64, power float64) []ChargingSlot {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].BidPrice != candidates[j].BidPrice {
+			return candidates[i].BidPrice < candidates[j].BidPrice
+		}
+		return candidates[i].from.Before(candidates[j].from)
+	})
+
+	chosen := []ChargingSlot{}
+	planned := 0.0
+	for _, candidate := range candidates {
+		if planned >= needed {
+			break
+		}
+		if planned+candidate.Energy > headroom {
+			continue
+		}
+		trial, ok := schedule(append(append([]ChargingSlot{}, chosen...), candidate), power)
+		if !ok {
+			continue
+		}
+		chosen = trial
+		planned += candidate.Energy
+	}
+	return chosen
+}
+
+// schedule charges the slots one after another in the order they become available, and
+// reports whether each one ends before its deadline.
+func schedule(slots []ChargingSlot, power float64) ([]ChargingSlot, bool) {
+	sort.SliceStable(slots, func(i, j int) bool {
+		return slots[i].from.Before(slots[j].from)
+	})
+	var cursor time.Time
+	for i := range slots {
+		start := slots[i].from
+		if start.Before(cursor) {
+			start = cursor
+		}
+		end := start.Add(time.Duration(slots[i].Energy / power * float64(time.Hour)))
+		if end.After(slots[i].until) {
+			return nil, false
+		}
+		slots[i].Start, slots[i].End = start, end
+		slots[i].Cost = slots[i].Energy * slots[i].BidPrice
+		cursor = end
+	}
+	return slots, true
+}
+
+// routeDistances returns the distance driven from the first waypoint to each one.
+func routeDistances(route []Waypoint) []float64 {
+	driven := make([]float64, len(route))
+	for i := 1; i < len(route); i++ {
+		driven[i] = driven[i-1] + distance(route[i-1].Latitude, route[i-1].Longitude, route[i].Latitude, route[i].Longitude)
+	}
+	return driven
+}
+
+// queryAlongRoute returns the generated tokens around the waypoints within reach. The
+// range of a battery can be larger than the cells of Buy, so the cells are made coarser
+// until the box around each waypoint fits in one.
+func queryAlongRoute(contract txsubmit.Contract, route []Waypoint, driven []float64, reach float64) ([]Energy, error) {
+	cells := []string{}
+	seenCells := map[string]bool{}
+	for i, waypoint := range route {
+		if driven[i] >= reach {
+			break
+		}
+		latDifference := (reach - driven[i]) / earthRadius * 180 / math.Pi
+		lngDifference := latDifference / math.Cos(waypoint.Latitude*math.Pi/180)
+		precision := searchGeohashPrecision
+		for precision > 1 && !fitsInCell(2*latDifference, 2*lngDifference, precision) {
+			precision--
+		}
+		for _, cell := range geohash.Cover(waypoint.Latitude-latDifference, waypoint.Latitude+latDifference,
+			waypoint.Longitude-lngDifference, waypoint.Longitude+lngDifference, precision) {
+			if !seenCells[cell] {
+				seenCells[cell] = true
+				cells = append(cells, cell)
+			}
+		}
+	}
+	energies, err := queryByGeohash(contract, cells)
+	if err != nil {
+		return energies, err
+	}
+
+	// the cells of different precisions overlap
+	result := []Energy{}
+	seen := map[string]bool{}
+	for _, energy := range energies {
+		if !seen[energy.ID] {
+			seen[energy.ID] = true
+			result = append(result, energy)
+		}
+	}
+	return result, nil
+}
+
+// fitsInCell reports whether a box of the sizes in degrees fits in a geohash cell of
+// the precision, whose bits alternate between longitude and latitude.
+func fitsInCell(latSize float64, lngSize float64, precision int) bool {
+	bits := 5 * precision
+	cellLat := 180 / math.Pow(2, float64(bits/2))
+	cellLng := 360 / math.Pow(2, float64(bits-bits/2))
+	return latSize <= cellLat && lngSize <= cellLng
+}
+
+func queryOpenForwards(contract txsubmit.Contract) ([]ConsumerForward, error) {
+	var forwards []ConsumerForward
+	evaluateResult, err := contract.Evaluate("QueryForwardsByStatus", "open")
+	if err != nil {
+		return forwards, err
+	}
+	if len(evaluateResult) == 0 {
+		return forwards, nil
+	}
+	err = json.Unmarshal(evaluateResult, &forwards)
+	return forwards, err
+}
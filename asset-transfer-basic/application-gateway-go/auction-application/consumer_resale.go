@@ -0,0 +1,132 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 需要家
+// 購入したトークンの転売、譲渡、消費
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"assetTransfer/auction-application/connection"
+	"assetTransfer/auction-application/logging"
+	"assetTransfer/auction-application/txsubmit"
+	"assetTransfer/auction-application/wallet"
+)
+
+type ResaleInput struct {
+	ID    string  `json:"id"`
+	Price float64 `json:"price"` // listForResale: minimum price, bidOnResale, bidOnForward: bid price
+	To    string  `json:"to"`    // transferToken, transferCertificate: new owner, retireCertificate: beneficiary
+}
+
+// resaleTransaction submits one transaction on a purchased token for the authenticated user.
+type resaleTransaction func(contract txsubmit.Contract, input ResaleInput, username string) (string, error)
+
+// resaleHandler returns the HTTP handler of a resale transaction.
+func resaleHandler(transaction resaleTransaction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed) //405
+			w.Write([]byte("Only POST"))
+			return
+		}
+		user := wallet.FromContext(r.Context())
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest) //400
+			w.Write([]byte(err.Error()))
+			return
+		}
+		var requestInput ResaleInput
+		err = json.Unmarshal(body, &requestInput)
+		if err != nil || requestInput.ID == "" {
+			w.WriteHeader(http.StatusBadRequest) //400
+			w.Write([]byte("id is required"))
+			return
+		}
+
+		logger := logging.FromContext(r.Context()).With("path", r.URL.Path, "id", requestInput.ID, "user", user.Label)
+		message, err := resaleContract(transaction, requestInput, user)
+		if err != nil {
+			logger.Warn("transaction rejected", "error", err)
+			w.WriteHeader(http.StatusConflict) //409
+			w.Write([]byte(err.Error()))
+			return
+		}
+		logger.Info("transaction submitted", "result", message)
+		w.Write([]byte(message))
+	}
+}
+
+func resaleContract(transaction resaleTransaction, input ResaleInput, user *wallet.Identity) (string, error) {
+	// The gRPC client connection should be shared by all Gateway connections to this endpoint
+	clientConnection, err := connection.Org2Peer.Dial()
+	if err != nil {
+		return "", err
+	}
+	defer clientConnection.Close()
+
+	// Create a Gateway connection for a specific client identity
+	gateway, err := connection.Connect(clientConnection, user)
+	if err != nil {
+		return "", err
+	}
+	defer gateway.Close()
+
+	network := gateway.GetNetwork(connection.ChannelName)
+	contract := txsubmit.NewContract(network.GetContract(connection.ChaincodeName))
+
+	return transaction(contract, input, user.Label)
+}
+
+// listForResale starts a 5min resale auction; the operator sweep closes it.
+func listForResale(contract txsubmit.Contract, input ResaleInput, username string) (string, error) {
+	var stringPrice = strconv.FormatFloat(input.Price, 'f', -1, 64)
+	_, err := contract.Submit("ListForResale", txsubmit.DefaultOptions, input.ID, username, stringPrice, appClock.Now().Format(layout))
+	if err != nil {
+		return "", err
+	}
+	return "the energy " + input.ID + " was listed for resale", nil
+}
+
+func bidOnResale(contract txsubmit.Contract, input ResaleInput, username string) (string, error) {
+	var stringPrice = strconv.FormatFloat(input.Price, 'f', -1, 64)
+	result, err := contract.Submit("BidOnResale", txsubmit.DefaultOptions, input.ID, username, stringPrice, appClock.Now().Format(layout))
+	if err != nil {
+		return "", err
+	}
+	return string(result.Payload), nil
+}
+
+// transferToken gives the token to another user of the wallet, whose org then endorses
+// the changes to the token.
+func transferToken(contract txsubmit.Contract, input ResaleInput, username string) (string, error) {
+	if input.To == "" {
+		return "", fmt.Errorf("to is required")
+	}
+	recipient, err := userWallet.Get(input.To)
+	if err != nil {
+		return "", err
+	}
+	_, err = contract.Submit("TransferToken", txsubmit.DefaultOptions, input.ID, username, input.To, recipient.MspID, appClock.Now().Format(layout))
+	if err != nil {
+		return "", err
+	}
+	return "the energy " + input.ID + " was transferred to " + input.To, nil
+}
+
+func consume(contract txsubmit.Contract, input ResaleInput, username string) (string, error) {
+	_, err := contract.Submit("Consume", txsubmit.DefaultOptions, input.ID, username, appClock.Now().Format(layout))
+	if err != nil {
+		return "", err
+	}
+	return "the energy " + input.ID + " was consumed", nil
+}
@@ -0,0 +1,331 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 管理用CLI
+// go run energyctl.go [-identity User1] [-org org1|org2] [-o table|json] <command>
+//
+//   tokens list [status]
+//   tokens get <id>
+//   tokens create <id> <latitude> <longitude> <producer> <largeCategory> <smallCategory> [timestamp]
+//   tokens bid <id> <owner> <price> [timestamp]
+//   tokens end <id> <producer> [timestamp]
+//   forwards list <status>
+//   forwards get <id>
+//   forwards bid <id> <owner> <price per kWh> [timestamp]
+//   forwards end <id> <producer> [timestamp]
+//   prices set <smallCategory> <unitPrice> [timestamp]
+//   prices history <smallCategory>
+//   prices multipliers
+//   quota get
+//   quota set <tokens per hour> <open bids>
+//   quota usage
+//   policy get <key>
+//   policy set <token> [org...]  (属性 market.admin=true のIDで実行)
+//   ledger init
+//
+// 終了コード: 0 成功, 1 トランザクションの失敗, 2 使い方の誤り, 3 接続の失敗
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"assetTransfer/auction-application/connection"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const layout = "2006-01-02T15:04:05+09:00"
+
+const (
+	exitOK = iota
+	exitTransaction
+	exitUsage
+	exitConnection
+)
+
+// command is one energyctl subcommand
+type command struct {
+	usage   string
+	minArgs int
+	maxArgs int // -1: any number
+	submit  bool
+	columns []string // table columns of the result
+	// transaction returns the chaincode function and its arguments
+	transaction func(args []string) (string, []string)
+}
+
+var tokenColumns = []string{"ID", "Status", "Owner", "Producer", "SmallCategory", "Unit Price", "Bid Price", "Generated Time"}
+
+var forwardColumns = []string{"ID", "Status", "Owner", "Producer", "Quantity", "Bid Price", "Delivery Start", "Delivery End", "Collateral", "Penalty"}
+
+var commands = map[string]map[string]command{
+	"tokens": {
+		"list": {
+			usage: "tokens list [status]", maxArgs: 1, columns: tokenColumns,
+			transaction: func(args []string) (string, []string) {
+				if len(args) == 0 {
+					return "GetAllTokens", nil
+				}
+				return "QueryByStatus", args
+			},
+		},
+		"get": {
+			usage: "tokens get <id>", minArgs: 1, maxArgs: 1, columns: tokenColumns,
+			transaction: func(args []string) (string, []string) { return "ReadToken", args },
+		},
+		"create": {
+			usage:   "tokens create <id> <latitude> <longitude> <producer> <largeCategory> <smallCategory> [timestamp]",
+			minArgs: 6, maxArgs: 7, submit: true,
+			transaction: func(args []string) (string, []string) { return "CreateToken", withTimestamp(args, 7) },
+		},
+		"bid": {
+			usage: "tokens bid <id> <owner> <price> [timestamp]", minArgs: 3, maxArgs: 4, submit: true,
+			transaction: func(args []string) (string, []string) { return "BidOnToken", withTimestamp(args, 4) },
+		},
+		"end": {
+			usage: "tokens end <id> <producer> [timestamp]", minArgs: 2, maxArgs: 3, submit: true,
+			transaction: func(args []string) (string, []string) { return "AuctionEnd", withTimestamp(args, 3) },
+		},
+	},
+	"forwards": {
+		"list": {
+			usage: "forwards list <status>", minArgs: 1, maxArgs: 1, columns: forwardColumns,
+			transaction: func(args []string) (string, []string) { return "QueryForwardsByStatus", args },
+		},
+		"get": {
+			usage: "forwards get <id>", minArgs: 1, maxArgs: 1, columns: forwardColumns,
+			transaction: func(args []string) (string, []string) { return "ReadForward", args },
+		},
+		"bid": {
+			usage: "forwards bid <id> <owner> <price per kWh> [timestamp]", minArgs: 3, maxArgs: 4, submit: true,
+			transaction: func(args []string) (string, []string) { return "BidOnForward", withTimestamp(args, 4) },
+		},
+		"end": {
+			usage: "forwards end <id> <producer> [timestamp]", minArgs: 2, maxArgs: 3, submit: true,
+			transaction: func(args []string) (string, []string) { return "ForwardAuctionEnd", withTimestamp(args, 3) },
+		},
+	},
+	"prices": {
+		"set": {
+			usage: "prices set <smallCategory> <unitPrice> [timestamp]", minArgs: 2, maxArgs: 3, submit: true,
+			transaction: func(args []string) (string, []string) { return "UpdateUnitPrice", withTimestamp(args, 3) },
+		},
+		"history": {
+			usage: "prices history <smallCategory>", minArgs: 1, maxArgs: 1,
+			columns:     []string{"Timestamp", "Unit Price", "TxID", "IsDelete"},
+			transaction: func(args []string) (string, []string) { return "GetPriceHistory", args },
+		},
+		"multipliers": {
+			usage: "prices multipliers", minArgs: 0, maxArgs: 0,
+			columns:     []string{"ID", "Zone", "SmallCategory", "Multiplier", "Start", "End", "Reason"},
+			transaction: func(args []string) (string, []string) { return "GetPriceMultipliers", args },
+		},
+	},
+	"quota": {
+		"get": {
+			usage: "quota get", minArgs: 0, maxArgs: 0,
+			transaction: func(args []string) (string, []string) { return "GetMarketQuota", args },
+		},
+		"set": {
+			usage: "quota set <tokens per hour> <open bids>", minArgs: 2, maxArgs: 2, submit: true,
+			transaction: func(args []string) (string, []string) { return "SetMarketQuota", args },
+		},
+		"usage": {
+			usage: "quota usage", minArgs: 0, maxArgs: 0,
+			transaction: func(args []string) (string, []string) { return "GetQuotaUsage", args },
+		},
+	},
+	"policy": {
+		"get": {
+			usage: "policy get <key>", minArgs: 1, maxArgs: 1,
+			transaction: func(args []string) (string, []string) { return "GetEndorsementPolicy", args },
+		},
+		"set": {
+			usage: "policy set <token> [org...]", minArgs: 1, maxArgs: -1, submit: true,
+			transaction: func(args []string) (string, []string) {
+				orgsJSON, _ := json.Marshal(append([]string{}, args[1:]...))
+				return "SetEndorsementPolicy", []string{args[0], string(orgsJSON)}
+			},
+		},
+	},
+	"ledger": {
+		"init": {
+			usage: "ledger init", submit: true,
+			transaction: func(args []string) (string, []string) { return "InitLedger", nil },
+		},
+	},
+}
+
+func main() {
+	label := flag.String("identity", "User1", "wallet identity")
+	org := flag.String("org", "org1", "gateway peer: org1 or org2")
+	output := flag.String("o", "table", "output format: table or json")
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[args[0]][args[1]]
+	if !ok {
+		usage()
+	}
+	cmdArgs := args[2:]
+	if len(cmdArgs) < cmd.minArgs || (cmd.maxArgs >= 0 && len(cmdArgs) > cmd.maxArgs) {
+		fmt.Fprintln(os.Stderr, "usage: energyctl "+cmd.usage)
+		os.Exit(exitUsage)
+	}
+	if *output != "table" && *output != "json" {
+		usage()
+	}
+
+	peer := connection.Org1Peer
+	if *org == "org2" {
+		peer = connection.Org2Peer
+	} else if *org != "org1" {
+		usage()
+	}
+
+	clientConnection, err := peer.Dial()
+	if err != nil {
+		fail(exitConnection, err)
+	}
+	defer clientConnection.Close()
+
+	gateway, err := connection.ConnectLabel(clientConnection, *label)
+	if err != nil {
+		fail(exitConnection, err)
+	}
+	defer gateway.Close()
+
+	contract := gateway.GetNetwork(connection.ChannelName).GetContract(connection.ChaincodeName)
+
+	name, transactionArgs := cmd.transaction(cmdArgs)
+	var result []byte
+	if cmd.submit {
+		result, err = contract.SubmitTransaction(name, transactionArgs...)
+	} else {
+		result, err = contract.EvaluateTransaction(name, transactionArgs...)
+	}
+	if err != nil {
+		fail(exitTransaction, transactionError(err))
+	}
+
+	if err = printResult(result, *output, cmd.columns); err != nil {
+		fail(exitTransaction, err)
+	}
+}
+
+// withTimestamp appends the current time when the optional timestamp at position n is missing.
+func withTimestamp(args []string, n int) []string {
+	if len(args) == n {
+		return args
+	}
+	return append(args, time.Now().Format(layout))
+}
+
+func printResult(result []byte, output string, columns []string) error {
+	if len(result) == 0 {
+		return nil
+	}
+	if !json.Valid(result) {
+		// messages such as "your bid was successful"
+		fmt.Println(string(result))
+		return nil
+	}
+	if output == "json" {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, result, "", "  "); err != nil {
+			return err
+		}
+		fmt.Println(indented.String())
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(result, &value); err != nil {
+		return err
+	}
+	var rows []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		rows = v
+	case map[string]interface{}:
+		rows = []interface{}{v}
+	default:
+		fmt.Println(string(result))
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if len(columns) > 0 {
+		for i, column := range columns {
+			if i > 0 {
+				fmt.Fprint(writer, "\t")
+			}
+			fmt.Fprint(writer, column)
+		}
+		fmt.Fprintln(writer)
+	}
+	for _, row := range rows {
+		fields, ok := row.(map[string]interface{})
+		if !ok || len(columns) == 0 {
+			fmt.Fprintln(writer, row)
+			continue
+		}
+		for i, column := range columns {
+			if i > 0 {
+				fmt.Fprint(writer, "\t")
+			}
+			fmt.Fprint(writer, fields[column])
+		}
+		fmt.Fprintln(writer)
+	}
+	return writer.Flush()
+}
+
+// transactionError adds the endorsement details of a gateway error.
+func transactionError(err error) error {
+	var endorseErr *client.EndorseError
+	var submitErr *client.SubmitError
+	var commitStatusErr *client.CommitStatusError
+	var commitErr *client.CommitError
+	switch {
+	case errors.As(err, &endorseErr):
+		return fmt.Errorf("endorse failed for transaction %s: %w", endorseErr.TransactionID, err)
+	case errors.As(err, &submitErr):
+		return fmt.Errorf("submit failed for transaction %s: %w", submitErr.TransactionID, err)
+	case errors.As(err, &commitStatusErr):
+		return fmt.Errorf("failed to get commit status of transaction %s: %w", commitStatusErr.TransactionID, err)
+	case errors.As(err, &commitErr):
+		return fmt.Errorf("transaction %s failed to commit with status %d: %w", commitErr.TransactionID, int32(commitErr.Code), err)
+	}
+	return err
+}
+
+func fail(code int, err error) {
+	fmt.Fprintln(os.Stderr, "energyctl:", err)
+	os.Exit(code)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: energyctl [-identity label] [-org org1|org2] [-o table|json] <command>")
+	for _, group := range []string{"tokens", "forwards", "prices", "quota", "policy", "ledger"} {
+		for _, name := range []string{"list", "get", "create", "bid", "end", "set", "history", "multipliers", "usage", "init"} {
+			if cmd, ok := commands[group][name]; ok {
+				fmt.Fprintln(os.Stderr, "  "+cmd.usage)
+			}
+		}
+	}
+	os.Exit(exitUsage)
+}
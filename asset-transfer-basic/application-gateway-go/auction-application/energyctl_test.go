@@ -0,0 +1,148 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 管理用CLIのテスト
+// go test energyctl_test.go energyctl.go
+
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"assetTransfer/auction-application/clock"
+	"assetTransfer/auction-application/fabrictest"
+	"assetTransfer/auction-application/txsubmit"
+)
+
+// run runs an energyctl command on contract as main does, and returns its result.
+func run(t *testing.T, contract txsubmit.Contract, args ...string) []byte {
+	t.Helper()
+	cmd, ok := commands[args[0]][args[1]]
+	if !ok {
+		t.Fatalf("unknown command %v", args)
+	}
+	cmdArgs := args[2:]
+	if len(cmdArgs) < cmd.minArgs || (cmd.maxArgs >= 0 && len(cmdArgs) > cmd.maxArgs) {
+		t.Fatalf("wrong number of arguments for %s", cmd.usage)
+	}
+
+	name, transactionArgs := cmd.transaction(cmdArgs)
+	if !cmd.submit {
+		result, err := contract.Evaluate(name, transactionArgs...)
+		if err != nil {
+			t.Fatalf("%s: %v", cmd.usage, err)
+		}
+		return result
+	}
+	result, err := contract.Submit(name, txsubmit.DefaultOptions, transactionArgs...)
+	if err != nil {
+		t.Fatalf("%s: %v", cmd.usage, err)
+	}
+	return result.Payload
+}
+
+// captureStdout returns what f prints.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	f()
+	writer.Close()
+	out, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestCommandsCallTheChaincodeFunctions(t *testing.T) {
+	now := time.Date(2022, 11, 6, 16, 0, 0, 0, time.FixedZone("JST", 9*60*60))
+	ledger, err := fabrictest.NewLedger(clock.NewFake(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := ledger.Contract("Org1MSP", "Admin", map[string]string{"market.admin": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer, err := ledger.Contract("Org1MSP", "User1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := ledger.Contract("Org2MSP", "User2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := now.Format(layout)
+
+	run(t, admin, "ledger", "init")
+	run(t, admin, "quota", "set", "10", "5")
+	run(t, producer, "tokens", "create", "energy1", "35.5", "139.6", "User1", "green", "solar", timestamp)
+	if result := run(t, consumer, "tokens", "bid", "energy1", "User2", "0.03", timestamp); string(result) != "your bid was successful" {
+		t.Errorf("unexpected bid result %s", result)
+	}
+	run(t, admin, "prices", "set", "solar", "0.021", timestamp)
+
+	for _, args := range [][]string{
+		{"tokens", "list"},
+		{"tokens", "list", "generated"},
+		{"tokens", "get", "energy1"},
+		{"forwards", "list", "generated"},
+		{"prices", "history", "solar"},
+		{"prices", "multipliers"},
+		{"quota", "get"},
+		{"quota", "usage"},
+		{"policy", "get", "energy1"},
+	} {
+		run(t, consumer, args...)
+	}
+
+	out := captureStdout(t, func() {
+		if err := printResult(run(t, consumer, "tokens", "list"), "table", tokenColumns); err != nil {
+			t.Fatal(err)
+		}
+	})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID") || !strings.HasPrefix(lines[1], "energy1") ||
+		!strings.Contains(lines[1], "User2") {
+		t.Errorf("unexpected table\n%s", out)
+	}
+}
+
+func TestCommandArguments(t *testing.T) {
+	name, args := commands["tokens"]["list"].transaction(nil)
+	if name != "GetAllTokens" || args != nil {
+		t.Errorf("unexpected transaction %s %v", name, args)
+	}
+	name, args = commands["policy"]["set"].transaction([]string{"energy1", "Org1MSP", "Org2MSP"})
+	if name != "SetEndorsementPolicy" || len(args) != 2 || args[1] != `["Org1MSP","Org2MSP"]` {
+		t.Errorf("unexpected transaction %s %v", name, args)
+	}
+	if _, args = commands["tokens"]["end"].transaction([]string{"energy1", "User1"}); len(args) != 3 {
+		t.Errorf("expected the current time to be added, got %v", args)
+	}
+
+	out := captureStdout(t, func() {
+		if err := printResult([]byte("your bid was successful"), "table", nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := printResult([]byte(`{"TokensPerHour":10}`), "json", nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if out != "your bid was successful\n{\n  \"TokensPerHour\": 10\n}\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
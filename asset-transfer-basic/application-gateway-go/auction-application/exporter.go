@@ -0,0 +1,125 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 市場の履歴のエクスポート
+// go run exporter.go [-db market.db] [-start 0] [-follow] [-csv export/] [-identity User1]
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"assetTransfer/auction-application/connection"
+	"assetTransfer/auction-application/ledgerexport"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"google.golang.org/protobuf/proto"
+)
+
+func main() {
+	dbPath := flag.String("db", "market.db", "SQLite database of the exported history")
+	start := flag.Int64("start", -1, "first block to export (default the block after the checkpoint of the database)")
+	follow := flag.Bool("follow", false, "keep exporting the new blocks until interrupted")
+	csvDir := flag.String("csv", "", "directory to write tokens.csv, bids.csv and prices.csv to after the export")
+	label := flag.String("identity", "User1", "wallet identity")
+	flag.Parse()
+
+	store, err := ledgerexport.Open(*dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	startBlock, err := store.Checkpoint()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *start >= 0 {
+		startBlock = uint64(*start)
+	}
+
+	clientConnection, err := connection.Org1Peer.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer clientConnection.Close()
+
+	gateway, err := connection.ConnectLabel(clientConnection, *label)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer gateway.Close()
+
+	network := gateway.GetNetwork(connection.ChannelName)
+
+	// without -follow the export stops at the height of the channel when it starts
+	var height uint64
+	if !*follow {
+		height, err = chainHeight(network)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if startBlock >= height {
+			fmt.Fprintf(os.Stderr, "Up to date at block %d\n", height-1)
+			exportCSV(store, *csvDir)
+			return
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	fmt.Fprintf(os.Stderr, "Export blocks from %d to %s\n", startBlock, *dbPath)
+	blocks, err := network.BlockEvents(ctx, client.WithStartBlock(startBlock))
+	if err != nil {
+		log.Fatalf("failed to start block event listening: %v", err)
+	}
+
+	for block := range blocks {
+		blockNumber := block.GetHeader().GetNumber()
+		writes, err := ledgerexport.Writes(block, connection.ChaincodeName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := store.Apply(blockNumber, writes); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "Block %d: %d writes\n", blockNumber, len(writes))
+
+		if !*follow && blockNumber+1 >= height {
+			break
+		}
+	}
+	cancel()
+	exportCSV(store, *csvDir)
+}
+
+// chainHeight returns the number of blocks of the channel, from the system chaincode qscc.
+func chainHeight(network *client.Network) (uint64, error) {
+	result, err := network.GetContract("qscc").EvaluateTransaction("GetChainInfo", connection.ChannelName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the chain info: %w", err)
+	}
+	info := &common.BlockchainInfo{}
+	if err := proto.Unmarshal(result, info); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal the chain info: %w", err)
+	}
+	return info.GetHeight(), nil
+}
+
+func exportCSV(store *ledgerexport.Store, dir string) {
+	if dir == "" {
+		return
+	}
+	if err := store.ExportCSV(dir); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote the CSV files to %s\n", dir)
+}
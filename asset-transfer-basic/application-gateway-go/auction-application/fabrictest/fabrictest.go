@@ -0,0 +1,254 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package fabrictest runs the auction chaincode in memory so that the auction
+// applications can be tested without a Fabric network. A Ledger executes the
+// SmartContract of auction-chaincode-go against a map-backed stub, and the Contract of
+// each user implements txsubmit.Contract.
+//
+// Transactions run one at a time and commit immediately, so they never conflict.
+// Endorsement policies, including state-based ones, are stored but not enforced, and
+// rich queries support the equality and comparison operators used by the chaincode.
+package fabrictest
+
+import (
+	// must be initialized before the protos of the chaincode and the gateway
+	_ "assetTransfer/auction-application/fabrictest/internal/protoconflict"
+
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"assetTransfer/auction-application/clock"
+	"assetTransfer/auction-application/txsubmit"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+)
+
+// ChannelName is the channel reported to the chaincode.
+const ChannelName = "mychannel"
+
+// attributeOID is the certificate extension of the attributes issued by Fabric CA.
+var attributeOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// Ledger is the world state, private data and history of one channel.
+type Ledger struct {
+	mu        sync.Mutex
+	clock     clock.Clock
+	chaincode *contractapi.ContractChaincode
+
+	state                map[string][]byte
+	private              map[string]map[string][]byte // by collection
+	validationParameters map[string][]byte
+	history              map[string][]*queryresult.KeyModification // newest first
+	events               []Event
+	blockNumber          uint64
+	txNumber             int
+}
+
+// Event is a chaincode event of a committed transaction.
+type Event struct {
+	TransactionID string
+	Name          string
+	Payload       []byte
+}
+
+// NewLedger returns an empty ledger whose transactions are timestamped by clk.
+func NewLedger(clk clock.Clock) (*Ledger, error) {
+	cc, err := contractapi.NewChaincode(&chaincode.SmartContract{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the auction chaincode: %w", err)
+	}
+	return &Ledger{
+		clock:                clk,
+		chaincode:            cc,
+		state:                map[string][]byte{},
+		private:              map[string]map[string][]byte{},
+		validationParameters: map[string][]byte{},
+		history:              map[string][]*queryresult.KeyModification{},
+	}, nil
+}
+
+// Contract returns the contract as seen by a user of the MSP. The attributes are put in
+// the user's certificate like Fabric CA does, e.g. "meter.oracle": "true".
+func (l *Ledger) Contract(mspID string, user string, attributes map[string]string) (*Contract, error) {
+	certificatePEM, err := newCertificate(user, attributes)
+	if err != nil {
+		return nil, err
+	}
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: mspID, IdBytes: certificatePEM})
+	if err != nil {
+		return nil, err
+	}
+	return &Contract{ledger: l, creator: creator}, nil
+}
+
+// State returns the committed value of a key, or nil.
+func (l *Ledger) State(key string) []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state[key]
+}
+
+// PrivateData returns the committed value of a key in a collection, or nil.
+func (l *Ledger) PrivateData(collection string, key string) []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.private[collection][key]
+}
+
+// Events returns the events of the committed transactions, oldest first.
+func (l *Ledger) Events() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Event{}, l.events...)
+}
+
+// Contract runs the transactions of one user. It implements txsubmit.Contract.
+type Contract struct {
+	ledger  *Ledger
+	creator []byte
+}
+
+var _ txsubmit.Contract = (*Contract)(nil)
+
+// Evaluate runs a transaction function without committing its writes.
+func (c *Contract) Evaluate(name string, args ...string) ([]byte, error) {
+	c.ledger.mu.Lock()
+	defer c.ledger.mu.Unlock()
+
+	s := c.ledger.newStub(c.creator, nil, name, args)
+	response := c.ledger.chaincode.Invoke(s)
+	if response.Status != shim.OK {
+		return nil, errors.New(response.Message)
+	}
+	return response.Payload, nil
+}
+
+// Submit runs a transaction function and commits its writes in a new block. A failure
+// of the chaincode is reported as an endorsement error, as the Gateway does.
+func (c *Contract) Submit(name string, options txsubmit.Options, args ...string) (*txsubmit.Result, error) {
+	c.ledger.mu.Lock()
+	defer c.ledger.mu.Unlock()
+
+	s := c.ledger.newStub(c.creator, options.Transient, name, args)
+	result := &txsubmit.Result{TransactionID: s.txID, Attempts: 1}
+	response := c.ledger.chaincode.Invoke(s)
+	if response.Status != shim.OK {
+		return result, &txsubmit.Error{
+			Stage:         txsubmit.StageEndorse,
+			TransactionID: s.txID,
+			Attempts:      1,
+			Err:           errors.New(response.Message),
+		}
+	}
+
+	c.ledger.commit(s)
+	result.Payload = response.Payload
+	result.Code = peer.TxValidationCode_VALID
+	result.BlockNumber = c.ledger.blockNumber
+	return result, nil
+}
+
+// newStub starts a transaction; the caller must hold l.mu.
+func (l *Ledger) newStub(creator []byte, transient map[string][]byte, name string, args []string) *stub {
+	l.txNumber++
+	stubArgs := [][]byte{[]byte(name)}
+	for _, arg := range args {
+		stubArgs = append(stubArgs, []byte(arg))
+	}
+	return &stub{
+		ledger:               l,
+		txID:                 fmt.Sprintf("%064x", l.txNumber),
+		timestamp:            l.clock.Now(),
+		creator:              creator,
+		transient:            transient,
+		args:                 stubArgs,
+		writes:               map[string]*write{},
+		privateWrites:        map[string]map[string]*write{},
+		validationParameters: map[string][]byte{},
+	}
+}
+
+// commit applies the writes of a transaction; the caller must hold l.mu.
+func (l *Ledger) commit(s *stub) {
+	l.blockNumber++
+	timestamp := timestampProto(s.timestamp)
+	for key, w := range s.writes {
+		if w.deleted {
+			delete(l.state, key)
+		} else {
+			l.state[key] = w.value
+		}
+		modification := &queryresult.KeyModification{TxId: s.txID, Value: w.value, Timestamp: timestamp, IsDelete: w.deleted}
+		l.history[key] = append([]*queryresult.KeyModification{modification}, l.history[key]...)
+	}
+	for collection, writes := range s.privateWrites {
+		if l.private[collection] == nil {
+			l.private[collection] = map[string][]byte{}
+		}
+		for key, w := range writes {
+			if w.deleted {
+				delete(l.private[collection], key)
+			} else {
+				l.private[collection][key] = w.value
+			}
+		}
+	}
+	for key, ep := range s.validationParameters {
+		if ep == nil {
+			delete(l.validationParameters, key)
+		} else {
+			l.validationParameters[key] = ep
+		}
+	}
+	if s.event != nil {
+		l.events = append(l.events, *s.event)
+	}
+}
+
+// newCertificate returns a self-signed PEM certificate with the Fabric CA attributes.
+func newCertificate(user string, attributes map[string]string) ([]byte, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: user, OrganizationalUnit: []string{"client"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	if len(attributes) > 0 {
+		attributesJSON, err := json.Marshal(map[string]map[string]string{"attrs": attributes})
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: attributeOID, Value: attributesJSON}}
+	}
+
+	certificateDER, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateDER}), nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package fabrictest_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"assetTransfer/auction-application/clock"
+	"assetTransfer/auction-application/fabrictest"
+	"assetTransfer/auction-application/txsubmit"
+)
+
+const layout = "2006-01-02T15:04:05+09:00"
+
+var jst = time.FixedZone("JST", 9*60*60)
+
+type energy struct {
+	ID        string  `json:"ID"`
+	Owner     string  `json:"Owner"`
+	Status    string  `json:"Status"`
+	UnitPrice float64 `json:"Unit Price"`
+	BidPrice  float64 `json:"Bid Price"`
+}
+
+func newContract(t *testing.T, clk clock.Clock) (*fabrictest.Ledger, *fabrictest.Contract) {
+	t.Helper()
+	ledger, err := fabrictest.NewLedger(clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract, err := ledger.Contract("Org1MSP", "User1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = contract.Submit("InitLedger", txsubmit.DefaultOptions); err != nil {
+		t.Fatal(err)
+	}
+	return ledger, contract
+}
+
+func readToken(t *testing.T, contract txsubmit.Contract, id string) energy {
+	t.Helper()
+	result, err := contract.Evaluate("ReadToken", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var token energy
+	if err = json.Unmarshal(result, &token); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestSubmitCommitsTheChaincodeWrites(t *testing.T) {
+	clk := clock.NewFake(time.Date(2022, 11, 6, 16, 0, 0, 0, jst))
+	ledger, contract := newContract(t, clk)
+
+	result, err := contract.Submit("CreateToken", txsubmit.DefaultOptions,
+		"energy1", "35.5", "139.6", "User1", "green", "solar", clk.Now().Format(layout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.BlockNumber != 2 || result.TransactionID == "" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	token := readToken(t, contract, "energy1")
+	if token.Status != "generated" || token.Owner != "User1" || token.UnitPrice != 0.02 {
+		t.Errorf("unexpected token %+v", token)
+	}
+	if ledger.State("energy1") == nil {
+		t.Error("the token was not committed")
+	}
+}
+
+func TestEvaluateDoesNotCommit(t *testing.T) {
+	clk := clock.NewFake(time.Date(2022, 11, 6, 16, 0, 0, 0, jst))
+	ledger, contract := newContract(t, clk)
+
+	_, err := contract.Evaluate("CreateToken",
+		"energy1", "35.5", "139.6", "User1", "green", "solar", clk.Now().Format(layout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ledger.State("energy1") != nil {
+		t.Error("an evaluated transaction was committed")
+	}
+}
+
+func TestSubmitReportsChaincodeErrorsAsEndorsementErrors(t *testing.T) {
+	_, contract := newContract(t, clock.NewFake(time.Date(2022, 11, 6, 16, 0, 0, 0, jst)))
+
+	_, err := contract.Submit("UpdateUnitPrice", txsubmit.DefaultOptions, "nuclear", "0.01", "2022-11-06T16:00:00+09:00")
+	var submitErr *txsubmit.Error
+	if !errors.As(err, &submitErr) || submitErr.Stage != txsubmit.StageEndorse {
+		t.Fatalf("expected an endorsement error, got %v", err)
+	}
+}
+
+func TestBidsAreTimestampedByTheClock(t *testing.T) {
+	clk := clock.NewFake(time.Date(2022, 11, 6, 16, 0, 0, 0, jst))
+	_, producer := newContract(t, clk)
+	_, err := producer.Submit("CreateToken", txsubmit.DefaultOptions,
+		"energy1", "35.5", "139.6", "User1", "green", "solar", clk.Now().Format(layout))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Advance(time.Minute)
+	result, err := producer.Submit("BidOnToken", txsubmit.DefaultOptions, "energy1", "User2", "0.03", clk.Now().Format(layout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Payload) != "your bid was successful" {
+		t.Fatalf("unexpected bid result %s", result.Payload)
+	}
+
+	clk.Advance(5 * time.Minute)
+	result, err = producer.Submit("AuctionEnd", txsubmit.DefaultOptions, "energy1", "User1", clk.Now().Format(layout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Payload) != "the energy energy1 was sold" {
+		t.Fatalf("unexpected auction end result %s", result.Payload)
+	}
+	token := readToken(t, producer, "energy1")
+	if token.Status != "sold" || token.Owner != "User2" || token.BidPrice != 0.03 {
+		t.Errorf("unexpected token %+v", token)
+	}
+}
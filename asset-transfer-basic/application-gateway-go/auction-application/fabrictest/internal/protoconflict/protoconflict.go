@@ -0,0 +1,21 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package protoconflict lets the chaincode protos (fabric-protos-go) be linked with the
+// gateway protos (fabric-protos-go-apiv2), which register the same message names.
+// Packages are initialized in import path order once their dependencies are, so this
+// package, which only depends on os, runs before either set of protos registers.
+package protoconflict
+
+import "os"
+
+const conflictEnv = "GOLANG_PROTOBUF_REGISTRATION_CONFLICT"
+
+func init() {
+	if os.Getenv(conflictEnv) == "" {
+		os.Setenv(conflictEnv, "ignore")
+	}
+}
@@ -0,0 +1,349 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package fabrictest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// compositeKeyNamespace starts the composite keys, as in the shim
+const compositeKeyNamespace = "\x00"
+
+type write struct {
+	value   []byte
+	deleted bool
+}
+
+// stub is the ChaincodeStubInterface of one transaction. Like on a peer, reads see the
+// committed state and not the writes of the transaction itself. The methods the auction
+// chaincode does not use are left to the embedded nil interface and panic.
+type stub struct {
+	shim.ChaincodeStubInterface
+
+	ledger    *Ledger
+	txID      string
+	timestamp time.Time
+	creator   []byte
+	transient map[string][]byte
+	args      [][]byte
+
+	writes               map[string]*write
+	privateWrites        map[string]map[string]*write
+	validationParameters map[string][]byte // nil value: policy removed
+	event                *Event
+}
+
+func (s *stub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *stub) GetStringArgs() []string {
+	args := make([]string, len(s.args))
+	for i, arg := range s.args {
+		args[i] = string(arg)
+	}
+	return args
+}
+
+func (s *stub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", nil
+	}
+	return args[0], args[1:]
+}
+
+func (s *stub) GetTxID() string {
+	return s.txID
+}
+
+func (s *stub) GetChannelID() string {
+	return ChannelName
+}
+
+func (s *stub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+func (s *stub) GetTransient() (map[string][]byte, error) {
+	if s.transient == nil {
+		return map[string][]byte{}, nil
+	}
+	return s.transient, nil
+}
+
+func (s *stub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return timestampProto(s.timestamp), nil
+}
+
+func (s *stub) GetState(key string) ([]byte, error) {
+	return s.ledger.state[key], nil
+}
+
+func (s *stub) PutState(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("empty key not allowed")
+	}
+	s.writes[key] = &write{value: value}
+	return nil
+}
+
+func (s *stub) DelState(key string) error {
+	s.writes[key] = &write{deleted: true}
+	return nil
+}
+
+func (s *stub) SetStateValidationParameter(key string, ep []byte) error {
+	s.validationParameters[key] = ep
+	return nil
+}
+
+func (s *stub) GetStateValidationParameter(key string) ([]byte, error) {
+	return s.ledger.validationParameters[key], nil
+}
+
+func (s *stub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return fmt.Errorf("event name can not be empty string")
+	}
+	s.event = &Event{TransactionID: s.txID, Name: name, Payload: payload}
+	return nil
+}
+
+func (s *stub) GetPrivateData(collection string, key string) ([]byte, error) {
+	return s.ledger.private[collection][key], nil
+}
+
+func (s *stub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	value, ok := s.ledger.private[collection][key]
+	if !ok {
+		return nil, nil
+	}
+	hash := sha256.Sum256(value)
+	return hash[:], nil
+}
+
+func (s *stub) PutPrivateData(collection string, key string, value []byte) error {
+	if s.privateWrites[collection] == nil {
+		s.privateWrites[collection] = map[string]*write{}
+	}
+	s.privateWrites[collection][key] = &write{value: value}
+	return nil
+}
+
+func (s *stub) DelPrivateData(collection string, key string) error {
+	if s.privateWrites[collection] == nil {
+		s.privateWrites[collection] = map[string]*write{}
+	}
+	s.privateWrites[collection][key] = &write{deleted: true}
+	return nil
+}
+
+// GetStateByRange returns the keys in [startKey, endKey); an empty key leaves the range open.
+// As on a peer, the composite keys are not in the range.
+func (s *stub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	var kvs []*queryresult.KV
+	for _, key := range s.sortedKeys() {
+		if strings.HasPrefix(key, compositeKeyNamespace) {
+			continue
+		}
+		if (startKey == "" || key >= startKey) && (endKey == "" || key < endKey) {
+			kvs = append(kvs, &queryresult.KV{Namespace: "basic", Key: key, Value: s.ledger.state[key]})
+		}
+	}
+	return &stateIterator{kvs: kvs}, nil
+}
+
+func (s *stub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return shim.CreateCompositeKey(objectType, attributes)
+}
+
+// GetStateByPartialCompositeKey returns the composite keys of objectType that start with
+// attributes, in key order.
+func (s *stub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := shim.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	var kvs []*queryresult.KV
+	for _, key := range s.sortedKeys() {
+		if strings.HasPrefix(key, prefix) {
+			kvs = append(kvs, &queryresult.KV{Namespace: "basic", Key: key, Value: s.ledger.state[key]})
+		}
+	}
+	return &stateIterator{kvs: kvs}, nil
+}
+
+// GetQueryResult evaluates the selector of a CouchDB query on the JSON values of the
+// state, in key order. Other query fields such as use_index are ignored.
+func (s *stub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	var parsed struct {
+		Selector map[string]interface{} `json:"selector"`
+	}
+	if err := json.Unmarshal([]byte(query), &parsed); err != nil {
+		return nil, fmt.Errorf("invalid query %s: %v", query, err)
+	}
+
+	var kvs []*queryresult.KV
+	for _, key := range s.sortedKeys() {
+		var document map[string]interface{}
+		if json.Unmarshal(s.ledger.state[key], &document) != nil {
+			continue
+		}
+		ok, err := matches(document, parsed.Selector)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			kvs = append(kvs, &queryresult.KV{Namespace: "basic", Key: key, Value: s.ledger.state[key]})
+		}
+	}
+	return &stateIterator{kvs: kvs}, nil
+}
+
+func (s *stub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &historyIterator{modifications: s.ledger.history[key]}, nil
+}
+
+func (s *stub) sortedKeys() []string {
+	keys := make([]string, 0, len(s.ledger.state))
+	for key := range s.ledger.state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// matches reports whether a document matches a selector of fields compared by equality
+// or by the operators $eq, $ne, $gt, $gte, $lt and $lte.
+func matches(document map[string]interface{}, selector map[string]interface{}) (bool, error) {
+	for field, condition := range selector {
+		value, ok := document[field]
+		operators, isOperators := condition.(map[string]interface{})
+		if !isOperators {
+			if !ok || !reflect.DeepEqual(value, condition) {
+				return false, nil
+			}
+			continue
+		}
+		for operator, operand := range operators {
+			if !ok {
+				return false, nil
+			}
+			matched, err := compare(operator, value, operand)
+			if err != nil || !matched {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+func compare(operator string, value interface{}, operand interface{}) (bool, error) {
+	switch operator {
+	case "$eq":
+		return reflect.DeepEqual(value, operand), nil
+	case "$ne":
+		return !reflect.DeepEqual(value, operand), nil
+	}
+
+	var order int
+	switch v := value.(type) {
+	case float64:
+		o, ok := operand.(float64)
+		if !ok {
+			return false, nil
+		}
+		order = compareOrdered(v < o, v > o)
+	case string:
+		o, ok := operand.(string)
+		if !ok {
+			return false, nil
+		}
+		order = compareOrdered(v < o, v > o)
+	default:
+		return false, nil
+	}
+
+	switch operator {
+	case "$gt":
+		return order > 0, nil
+	case "$gte":
+		return order >= 0, nil
+	case "$lt":
+		return order < 0, nil
+	case "$lte":
+		return order <= 0, nil
+	}
+	return false, fmt.Errorf("unsupported query operator %s", operator)
+}
+
+func compareOrdered(less bool, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+func timestampProto(t time.Time) *timestamp.Timestamp {
+	return &timestamp.Timestamp{Seconds: t.Unix(), Nanos: int32(t.Nanosecond())}
+}
+
+type stateIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (it *stateIterator) HasNext() bool {
+	return len(it.kvs) > 0
+}
+
+func (it *stateIterator) Next() (*queryresult.KV, error) {
+	if len(it.kvs) == 0 {
+		return nil, fmt.Errorf("no more results")
+	}
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+	return kv, nil
+}
+
+func (it *stateIterator) Close() error {
+	return nil
+}
+
+type historyIterator struct {
+	modifications []*queryresult.KeyModification
+}
+
+func (it *historyIterator) HasNext() bool {
+	return len(it.modifications) > 0
+}
+
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if len(it.modifications) == 0 {
+		return nil, fmt.Errorf("no more results")
+	}
+	modification := it.modifications[0]
+	it.modifications = it.modifications[1:]
+	return modification, nil
+}
+
+func (it *historyIterator) Close() error {
+	return nil
+}
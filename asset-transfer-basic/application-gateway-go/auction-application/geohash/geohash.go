@@ -0,0 +1,61 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package geohash encodes coordinates as geohashes, the same way the auction
+// chaincode tags tokens, so that consumers can search by a coarse cell instead of
+// sending their exact location.
+package geohash
+
+const base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Encode returns the geohash of the coordinates with precision characters.
+func Encode(latitude float64, longitude float64, precision int) string {
+	latitudeRange := [2]float64{-90, 90}
+	longitudeRange := [2]float64{-180, 180}
+	geohash := make([]byte, 0, precision)
+	even := true
+	bit, ch := 0, 0
+	for len(geohash) < precision {
+		// even bits split the longitude, odd bits the latitude
+		value, valueRange := latitude, &latitudeRange
+		if even {
+			value, valueRange = longitude, &longitudeRange
+		}
+		mid := (valueRange[0] + valueRange[1]) / 2
+		ch <<= 1
+		if value >= mid {
+			ch |= 1
+			valueRange[0] = mid
+		} else {
+			valueRange[1] = mid
+		}
+		even = !even
+
+		bit++
+		if bit == 5 {
+			geohash = append(geohash, base32[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return string(geohash)
+}
+
+// Cover returns the geohashes with precision characters of the cells that the corners
+// of a bounding box fall in. They cover the box when it is smaller than one cell.
+func Cover(lowerLat float64, upperLat float64, lowerLng float64, upperLng float64, precision int) []string {
+	var cells []string
+	seen := map[string]bool{}
+	for _, lat := range []float64{lowerLat, upperLat} {
+		for _, lng := range []float64{lowerLng, upperLng} {
+			cell := Encode(lat, lng, precision)
+			if !seen[cell] {
+				seen[cell] = true
+				cells = append(cells, cell)
+			}
+		}
+	}
+	return cells
+}
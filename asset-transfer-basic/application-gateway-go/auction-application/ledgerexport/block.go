@@ -0,0 +1,121 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package ledgerexport keeps an off-chain SQLite copy of the energy market history,
+// built from the world state writes of the chaincode in the blocks of the channel.
+package ledgerexport
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// Write is a world state write of a valid transaction.
+type Write struct {
+	Block     uint64
+	TxIndex   int
+	TxID      string
+	Timestamp time.Time
+	Key       string
+	Value     []byte // nil for a delete
+}
+
+// Writes returns the writes of the chaincode namespace in the valid endorser
+// transactions of a block, in the order of the block.
+func Writes(block *common.Block, namespace string) ([]Write, error) {
+	blockNumber := block.GetHeader().GetNumber()
+	metadata := block.GetMetadata().GetMetadata()
+	if len(metadata) <= int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		return nil, fmt.Errorf("block %d has no transaction filter", blockNumber)
+	}
+	validationCodes := metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
+
+	writes := []Write{}
+	for txIndex, envelopeBytes := range block.GetData().GetData() {
+		if txIndex >= len(validationCodes) || peer.TxValidationCode(validationCodes[txIndex]) != peer.TxValidationCode_VALID {
+			continue
+		}
+		txWrites, err := transactionWrites(envelopeBytes, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("block %d transaction %d: %w", blockNumber, txIndex, err)
+		}
+		for i := range txWrites {
+			txWrites[i].Block = blockNumber
+			txWrites[i].TxIndex = txIndex
+		}
+		writes = append(writes, txWrites...)
+	}
+	return writes, nil
+}
+
+func transactionWrites(envelopeBytes []byte, namespace string) ([]Write, error) {
+	envelope := &common.Envelope{}
+	if err := proto.Unmarshal(envelopeBytes, envelope); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal envelope: %w", err)
+	}
+	payload := &common.Payload{}
+	if err := proto.Unmarshal(envelope.GetPayload(), payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+	channelHeader := &common.ChannelHeader{}
+	if err := proto.Unmarshal(payload.GetHeader().GetChannelHeader(), channelHeader); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal channel header: %w", err)
+	}
+	// config transactions have no chaincode writes
+	if common.HeaderType(channelHeader.GetType()) != common.HeaderType_ENDORSER_TRANSACTION {
+		return nil, nil
+	}
+	timestamp := channelHeader.GetTimestamp().AsTime()
+
+	transaction := &peer.Transaction{}
+	if err := proto.Unmarshal(payload.GetData(), transaction); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal transaction: %w", err)
+	}
+
+	writes := []Write{}
+	for _, action := range transaction.GetActions() {
+		actionPayload := &peer.ChaincodeActionPayload{}
+		if err := proto.Unmarshal(action.GetPayload(), actionPayload); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal chaincode action payload: %w", err)
+		}
+		responsePayload := &peer.ProposalResponsePayload{}
+		if err := proto.Unmarshal(actionPayload.GetAction().GetProposalResponsePayload(), responsePayload); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal proposal response payload: %w", err)
+		}
+		chaincodeAction := &peer.ChaincodeAction{}
+		if err := proto.Unmarshal(responsePayload.GetExtension(), chaincodeAction); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal chaincode action: %w", err)
+		}
+		txReadWriteSet := &rwset.TxReadWriteSet{}
+		if err := proto.Unmarshal(chaincodeAction.GetResults(), txReadWriteSet); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal read-write set: %w", err)
+		}
+
+		for _, nsReadWriteSet := range txReadWriteSet.GetNsRwset() {
+			if nsReadWriteSet.GetNamespace() != namespace {
+				continue
+			}
+			kvReadWriteSet := &kvrwset.KVRWSet{}
+			if err := proto.Unmarshal(nsReadWriteSet.GetRwset(), kvReadWriteSet); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal key-value read-write set: %w", err)
+			}
+			for _, kvWrite := range kvReadWriteSet.GetWrites() {
+				write := Write{TxID: channelHeader.GetTxId(), Timestamp: timestamp, Key: kvWrite.GetKey()}
+				if !kvWrite.GetIsDelete() {
+					write.Value = kvWrite.GetValue()
+				}
+				writes = append(writes, write)
+			}
+		}
+	}
+	return writes, nil
+}
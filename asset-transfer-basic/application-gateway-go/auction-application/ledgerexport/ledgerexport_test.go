@@ -0,0 +1,193 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ledgerexport
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var generated = time.Date(2022, 8, 1, 6, 0, 0, 0, time.UTC)
+
+type testTx struct {
+	id        string
+	valid     bool
+	namespace string
+	writes    []*kvrwset.KVWrite
+}
+
+func marshal(t *testing.T, m proto.Message) []byte {
+	t.Helper()
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// newBlock builds a block of endorser transactions with the writes of txs, as the
+// peer delivers it.
+func newBlock(t *testing.T, number uint64, txs ...testTx) *common.Block {
+	t.Helper()
+	block := &common.Block{
+		Header:   &common.BlockHeader{Number: number},
+		Data:     &common.BlockData{},
+		Metadata: &common.BlockMetadata{Metadata: make([][]byte, len(common.BlockMetadataIndex_name))},
+	}
+	filter := make([]byte, len(txs))
+	for i, tx := range txs {
+		filter[i] = byte(peer.TxValidationCode_VALID)
+		if !tx.valid {
+			filter[i] = byte(peer.TxValidationCode_MVCC_READ_CONFLICT)
+		}
+
+		kvRWSet := marshal(t, &kvrwset.KVRWSet{Writes: tx.writes})
+		results := marshal(t, &rwset.TxReadWriteSet{
+			DataModel: rwset.TxReadWriteSet_KV,
+			NsRwset:   []*rwset.NsReadWriteSet{{Namespace: tx.namespace, Rwset: kvRWSet}},
+		})
+		responsePayload := marshal(t, &peer.ProposalResponsePayload{
+			Extension: marshal(t, &peer.ChaincodeAction{Results: results}),
+		})
+		actionPayload := marshal(t, &peer.ChaincodeActionPayload{
+			Action: &peer.ChaincodeEndorsedAction{ProposalResponsePayload: responsePayload},
+		})
+		transaction := marshal(t, &peer.Transaction{Actions: []*peer.TransactionAction{{Payload: actionPayload}}})
+		channelHeader := marshal(t, &common.ChannelHeader{
+			Type:      int32(common.HeaderType_ENDORSER_TRANSACTION),
+			TxId:      tx.id,
+			Timestamp: timestamppb.New(generated),
+		})
+		payload := marshal(t, &common.Payload{
+			Header: &common.Header{ChannelHeader: channelHeader},
+			Data:   transaction,
+		})
+		block.Data.Data = append(block.Data.Data, marshal(t, &common.Envelope{Payload: payload}))
+	}
+	block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] = filter
+	return block
+}
+
+func tokenWrite(t *testing.T, id string, owner string, status string, bidPrice float64) *kvrwset.KVWrite {
+	value, err := json.Marshal(map[string]interface{}{
+		"DocType": "token", "ID": id, "Producer": "User1", "Owner": owner, "Status": status,
+		"LargeCategory": "green", "SmallCategory": "solar", "Unit Price": 0.02, "Bid Price": bidPrice,
+		"Latitude": 35.6, "Longitude": 139.7, "Generated Time": generated, "Bid Time": generated.Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &kvrwset.KVWrite{Key: id, Value: value}
+}
+
+func readCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestWritesSkipsInvalidTransactionsAndOtherChaincodes(t *testing.T) {
+	block := newBlock(t, 5,
+		testTx{id: "tx1", valid: true, namespace: "basic", writes: []*kvrwset.KVWrite{tokenWrite(t, "energy1", "User1", "generated", 0)}},
+		testTx{id: "tx2", valid: false, namespace: "basic", writes: []*kvrwset.KVWrite{tokenWrite(t, "energy2", "User1", "generated", 0)}},
+		testTx{id: "tx3", valid: true, namespace: "other", writes: []*kvrwset.KVWrite{tokenWrite(t, "energy3", "User1", "generated", 0)}},
+		testTx{id: "tx4", valid: true, namespace: "basic", writes: []*kvrwset.KVWrite{{Key: "energy1", IsDelete: true}}},
+	)
+
+	writes, err := Writes(block, "basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(writes) != 2 {
+		t.Fatalf("got %d writes, want 2: %+v", len(writes), writes)
+	}
+	if w := writes[0]; w.Block != 5 || w.TxIndex != 0 || w.TxID != "tx1" || w.Key != "energy1" || w.Value == nil || !w.Timestamp.Equal(generated) {
+		t.Errorf("first write = %+v", w)
+	}
+	if w := writes[1]; w.TxIndex != 3 || w.Key != "energy1" || w.Value != nil {
+		t.Errorf("second write = %+v, want the delete of energy1", w)
+	}
+}
+
+func TestStoreRecordsTokensBidsAndPricesAndResumesAfterTheCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(filepath.Join(dir, "market.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	cost, _ := json.Marshal(map[string]interface{}{"DocType": "cost", "ID": "solar-power-cost", "Unit Price": 0.025})
+	blocks := []*common.Block{
+		newBlock(t, 0, testTx{id: "tx1", valid: true, namespace: "basic", writes: []*kvrwset.KVWrite{
+			tokenWrite(t, "energy1", "User1", "generated", 0),
+			{Key: "solar-power-cost", Value: cost},
+		}}),
+		newBlock(t, 1,
+			testTx{id: "tx2", valid: true, namespace: "basic", writes: []*kvrwset.KVWrite{tokenWrite(t, "energy1", "User2", "generated", 0.03)}},
+			testTx{id: "tx3", valid: true, namespace: "basic", writes: []*kvrwset.KVWrite{tokenWrite(t, "energy1", "User3", "generated", 0.04)}},
+		),
+		newBlock(t, 2, testTx{id: "tx4", valid: true, namespace: "basic", writes: []*kvrwset.KVWrite{tokenWrite(t, "energy1", "User3", "sold", 0.04)}}),
+	}
+
+	apply := func(block *common.Block) {
+		writes, err := Writes(block, "basic")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Apply(block.GetHeader().GetNumber(), writes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	apply(blocks[0])
+	apply(blocks[1])
+	next, err := store.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != 2 {
+		t.Fatalf("checkpoint = %d, want 2", next)
+	}
+	// a replay from an earlier block does not record the bids twice
+	apply(blocks[1])
+	apply(blocks[2])
+
+	if err := store.ExportCSV(dir); err != nil {
+		t.Fatal(err)
+	}
+	tokens := readCSV(t, filepath.Join(dir, "tokens.csv"))
+	if len(tokens) != 2 || tokens[1][0] != "energy1" || tokens[1][2] != "User3" || tokens[1][3] != "sold" || tokens[1][13] != "2" {
+		t.Errorf("tokens.csv = %v", tokens)
+	}
+	bids := readCSV(t, filepath.Join(dir, "bids.csv"))
+	if len(bids) != 3 || bids[1][1] != "User2" || bids[1][2] != "0.03" || bids[2][1] != "User3" || bids[2][5] != "tx3" {
+		t.Errorf("bids.csv = %v", bids)
+	}
+	prices := readCSV(t, filepath.Join(dir, "prices.csv"))
+	if len(prices) != 2 || prices[1][0] != "solar" || prices[1][1] != "0.025" {
+		t.Errorf("prices.csv = %v", prices)
+	}
+}
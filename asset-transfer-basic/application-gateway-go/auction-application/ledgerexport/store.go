@@ -0,0 +1,291 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ledgerexport
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// costSuffix ends the keys of the unit prices of the small categories
+const costSuffix = "-power-cost"
+
+const schema = `
+CREATE TABLE IF NOT EXISTS tokens (
+	id             TEXT PRIMARY KEY,
+	producer       TEXT NOT NULL,
+	owner          TEXT NOT NULL,
+	status         TEXT NOT NULL,
+	large_category TEXT NOT NULL,
+	small_category TEXT NOT NULL,
+	unit_price     REAL NOT NULL,
+	bid_price      REAL NOT NULL,
+	latitude       REAL NOT NULL,
+	longitude      REAL NOT NULL,
+	generated_time TEXT NOT NULL,
+	bid_time       TEXT NOT NULL,
+	deleted        INTEGER NOT NULL DEFAULT 0,
+	block          INTEGER NOT NULL,
+	tx_id          TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS bids (
+	token_id  TEXT NOT NULL,
+	bidder    TEXT NOT NULL,
+	bid_price REAL NOT NULL,
+	bid_time  TEXT NOT NULL,
+	block     INTEGER NOT NULL,
+	tx_id     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS bids_token ON bids (token_id);
+CREATE TABLE IF NOT EXISTS prices (
+	small_category TEXT NOT NULL,
+	unit_price     REAL NOT NULL,
+	time           TEXT NOT NULL,
+	block          INTEGER NOT NULL,
+	tx_id          TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS checkpoint (
+	id    INTEGER PRIMARY KEY CHECK (id = 1),
+	block INTEGER NOT NULL
+);
+`
+
+// record is the part of the energy JSON of the chaincode that is exported; the cost
+// records of the small categories have the same shape as the tokens.
+type record struct {
+	DocType       string    `json:"DocType"`
+	ID            string    `json:"ID"`
+	Producer      string    `json:"Producer"`
+	Owner         string    `json:"Owner"`
+	Status        string    `json:"Status"`
+	LargeCategory string    `json:"LargeCategory"`
+	SmallCategory string    `json:"SmallCategory"`
+	UnitPrice     float64   `json:"Unit Price"`
+	BidPrice      float64   `json:"Bid Price"`
+	Latitude      float64   `json:"Latitude"`
+	Longitude     float64   `json:"Longitude"`
+	GeneratedTime time.Time `json:"Generated Time"`
+	BidTime       time.Time `json:"Bid Time"`
+}
+
+// Store is the SQLite database of the exported history.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens or creates the database at path.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// one connection, so that the transactions of Apply are not interleaved
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create the tables of %s: %w", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Checkpoint returns the next block to export, 0 for a new database.
+func (s *Store) Checkpoint() (uint64, error) {
+	var block uint64
+	err := s.db.QueryRow("SELECT block FROM checkpoint WHERE id = 1").Scan(&block)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return block + 1, nil
+}
+
+// Apply exports the writes of a block and moves the checkpoint past it in one
+// database transaction, so that an interrupted export resumes at the block. Blocks
+// before the checkpoint are ignored.
+func (s *Store) Apply(blockNumber uint64, writes []Write) error {
+	next, err := s.Checkpoint()
+	if err != nil {
+		return err
+	}
+	if blockNumber < next {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, write := range writes {
+		if err := applyWrite(tx, write); err != nil {
+			return fmt.Errorf("block %d key %s: %w", write.Block, write.Key, err)
+		}
+	}
+	_, err = tx.Exec("INSERT INTO checkpoint (id, block) VALUES (1, ?) ON CONFLICT (id) DO UPDATE SET block = excluded.block",
+		blockNumber)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func applyWrite(tx *sql.Tx, write Write) error {
+	if write.Value == nil {
+		_, err := tx.Exec("UPDATE tokens SET deleted = 1, block = ?, tx_id = ? WHERE id = ?",
+			write.Block, write.TxID, write.Key)
+		return err
+	}
+
+	// the other documents of the chaincode (quotas, zones, meter readings...) are not JSON
+	// energies, or have another DocType
+	var r record
+	if err := json.Unmarshal(write.Value, &r); err != nil {
+		return nil
+	}
+	switch {
+	case r.DocType == "cost" && strings.HasSuffix(write.Key, costSuffix):
+		_, err := tx.Exec("INSERT INTO prices (small_category, unit_price, time, block, tx_id) VALUES (?, ?, ?, ?, ?)",
+			strings.TrimSuffix(write.Key, costSuffix), r.UnitPrice, formatTime(write.Timestamp), write.Block, write.TxID)
+		return err
+	case r.DocType == "token":
+		return applyToken(tx, write, r)
+	}
+	return nil
+}
+
+func applyToken(tx *sql.Tx, write Write, r record) error {
+	var previousOwner string
+	var previousBidPrice float64
+	err := tx.QueryRow("SELECT owner, bid_price FROM tokens WHERE id = ?", write.Key).Scan(&previousOwner, &previousBidPrice)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	found := err == nil
+
+	// a bid makes the bidder the owner at the bid price while the auction is open
+	if r.Status == "generated" && r.Owner != r.Producer &&
+		(!found || r.Owner != previousOwner || r.BidPrice != previousBidPrice) {
+		_, err := tx.Exec("INSERT INTO bids (token_id, bidder, bid_price, bid_time, block, tx_id) VALUES (?, ?, ?, ?, ?, ?)",
+			write.Key, r.Owner, r.BidPrice, formatTime(r.BidTime), write.Block, write.TxID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO tokens (id, producer, owner, status, large_category, small_category, unit_price,
+		bid_price, latitude, longitude, generated_time, bid_time, deleted, block, tx_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET producer = excluded.producer, owner = excluded.owner,
+		status = excluded.status, large_category = excluded.large_category, small_category = excluded.small_category,
+		unit_price = excluded.unit_price, bid_price = excluded.bid_price, latitude = excluded.latitude,
+		longitude = excluded.longitude, generated_time = excluded.generated_time, bid_time = excluded.bid_time,
+		deleted = 0, block = excluded.block, tx_id = excluded.tx_id`,
+		write.Key, r.Producer, r.Owner, r.Status, r.LargeCategory, r.SmallCategory, r.UnitPrice,
+		r.BidPrice, r.Latitude, r.Longitude, formatTime(r.GeneratedTime), formatTime(r.BidTime),
+		write.Block, write.TxID)
+	return err
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// ExportCSV writes the tables tokens, bids and prices to CSV files of the same names
+// in dir.
+func (s *Store) ExportCSV(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	queries := map[string]string{
+		"tokens": `SELECT id, producer, owner, status, large_category, small_category, unit_price, bid_price,
+			latitude, longitude, generated_time, bid_time, deleted, block, tx_id FROM tokens ORDER BY block, id`,
+		"bids":   "SELECT token_id, bidder, bid_price, bid_time, block, tx_id FROM bids ORDER BY rowid",
+		"prices": "SELECT small_category, unit_price, time, block, tx_id FROM prices ORDER BY rowid",
+	}
+	for _, table := range []string{"tokens", "bids", "prices"} {
+		if err := s.exportTable(filepath.Join(dir, table+".csv"), queries[table]); err != nil {
+			return fmt.Errorf("failed to export %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) exportTable(path string, query string) error {
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := csv.NewWriter(file)
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	line := make([]string, len(columns))
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		for i, value := range values {
+			switch v := value.(type) {
+			case float64:
+				line[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			case []byte:
+				line[i] = string(v)
+			default:
+				line[i] = fmt.Sprint(v)
+			}
+		}
+		if err := w.Write(line); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestLogger(level Level) (*Logger, *bytes.Buffer) {
+	var out bytes.Buffer
+	return &Logger{out: &out, mu: &sync.Mutex{}, level: level}, &out
+}
+
+func entries(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var result []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func TestLoggerWritesOneJSONObjectPerEntryAtItsLevel(t *testing.T) {
+	logger, out := newTestLogger(LevelInfo)
+	logger = logger.With("service", "consumer")
+
+	logger.Debug("hidden")
+	logger.Warn("bid rejected", "id", "energy1", "error", errors.New("cheap"), "odd")
+
+	logged := entries(t, out)
+	if len(logged) != 1 {
+		t.Fatalf("expected 1 entry, got %v", logged)
+	}
+	entry := logged[0]
+	if entry["level"] != "warn" || entry["msg"] != "bid rejected" || entry["service"] != "consumer" ||
+		entry["id"] != "energy1" || entry["error"] != "cheap" || entry["odd"] != "(missing)" {
+		t.Errorf("unexpected entry %v", entry)
+	}
+	// the fields keep their order
+	if line := out.String(); strings.Index(line, `"service"`) > strings.Index(line, `"id"`) {
+		t.Errorf("unexpected order of the fields %s", line)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]Level{"": LevelInfo, "DEBUG": LevelDebug, "warning": LevelWarn, "error": LevelError} {
+		if level, err := ParseLevel(s); err != nil || level != want {
+			t.Errorf("ParseLevel(%q) = %v, %v", s, level, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
+
+func TestMiddlewareTagsTheLogsOfTheRequestWithItsID(t *testing.T) {
+	logger, out := newTestLogger(LevelInfo)
+	handler := logger.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).Info("handling")
+		w.WriteHeader(http.StatusConflict)
+	}))
+
+	request := httptest.NewRequest(http.MethodPost, "/bidOnToken", nil)
+	request.Header.Set(RequestIDHeader, "abc")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Header().Get(RequestIDHeader) != "abc" {
+		t.Errorf("expected the request ID in the response, got %v", recorder.Header())
+	}
+	logged := entries(t, out)
+	if len(logged) != 2 {
+		t.Fatalf("expected 2 entries, got %v", logged)
+	}
+	for _, entry := range logged {
+		if entry["request_id"] != "abc" {
+			t.Errorf("expected the request ID in %v", entry)
+		}
+	}
+	if completed := logged[1]; completed["status"] != float64(http.StatusConflict) || completed["path"] != "/bidOnToken" {
+		t.Errorf("unexpected completion entry %v", completed)
+	}
+
+	// without a request ID, one is generated
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if id := recorder.Header().Get(RequestIDHeader); len(id) != 16 {
+		t.Errorf("unexpected generated request ID %q", id)
+	}
+}
+
+func TestFromContextFallsBackToTheDefaultLogger(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	if FromContext(request.Context()) != Default() {
+		t.Error("expected the default logger")
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 計量オラクル
+// 証明書に属性 meter.oracle=true を持つユーザで実行
+// 最初のオラクルは属性 market.admin=true を持つユーザが設定する
+// go run meter.go -identity Admin oracle Org1MSP
+// go run meter.go -identity Meter1 generation <token id> <meter id> <kWh>
+// go run meter.go -identity Meter1 delivery <token id> <meter id> <kWh>
+// go run meter.go -identity Meter1 settle <forward id> <meter id> <kWh>
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"assetTransfer/auction-application/connection"
+)
+
+const layout = "2006-01-02T15:04:05+09:00"
+
+func main() {
+	label := flag.String("identity", "Meter1", "wallet identity of the meter")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+	}
+
+	var transaction string
+	var transactionArgs []string
+	switch args[0] {
+	case "oracle":
+		if len(args) != 2 {
+			usage()
+		}
+		transaction = "SetMeterOracle"
+		transactionArgs = args[1:]
+	case "generation", "delivery", "settle":
+		if len(args) != 4 {
+			usage()
+		}
+		transaction = "ConfirmGeneration"
+		if args[0] == "delivery" {
+			transaction = "ConfirmDelivery"
+		} else if args[0] == "settle" {
+			// the delivered amount of a forward, after its delivery window
+			transaction = "SettleForward"
+		}
+		transactionArgs = append(args[1:], time.Now().Format(layout))
+	default:
+		usage()
+	}
+
+	clientConnection, err := connection.Org1Peer.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer clientConnection.Close()
+
+	gateway, err := connection.ConnectLabel(clientConnection, *label)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer gateway.Close()
+
+	contract := gateway.GetNetwork(connection.ChannelName).GetContract(connection.ChaincodeName)
+
+	fmt.Printf("Submit Transaction: %s %v\n", transaction, transactionArgs)
+	result, err := contract.SubmitTransaction(transaction, transactionArgs...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(result) > 0 {
+		fmt.Printf("*** Result:%s\n", result)
+	}
+	fmt.Println("*** Transaction committed successfully")
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: meter [-identity label] oracle <mspID>")
+	fmt.Fprintln(os.Stderr, "       meter [-identity label] generation|delivery <token id> <meter id> <kWh>")
+	fmt.Fprintln(os.Stderr, "       meter [-identity label] settle <forward id> <meter id> <kWh>")
+	os.Exit(2)
+}
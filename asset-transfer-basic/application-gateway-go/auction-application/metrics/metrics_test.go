@@ -0,0 +1,83 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %s", contentType)
+	}
+	body, err := ioutil.ReadAll(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func expectLines(t *testing.T, exposition string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(exposition, line+"\n") {
+			t.Errorf("expected %q in\n%s", line, exposition)
+		}
+	}
+}
+
+func TestCounterIsExposedPerLabelValues(t *testing.T) {
+	counter := NewCounter("test_bids_total", "Bids\nby outcome.", "outcome")
+	counter.Inc("successful")
+	counter.Add(2, "successful")
+	counter.Inc(`re"jected`)
+
+	expectLines(t, scrape(t),
+		"# HELP test_bids_total Bids by outcome.",
+		"# TYPE test_bids_total counter",
+		`test_bids_total{outcome="re\"jected"} 1`,
+		`test_bids_total{outcome="successful"} 3`,
+	)
+}
+
+func TestHistogramBucketsAreCumulative(t *testing.T) {
+	histogram := NewHistogram("test_submit_seconds", "Submit latency.", []float64{1, 0.1}, "transaction")
+	histogram.Observe(0.05, "BidOnToken")
+	histogram.Observe(0.5, "BidOnToken")
+	histogram.Observe(5, "BidOnToken")
+
+	expectLines(t, scrape(t),
+		"# TYPE test_submit_seconds histogram",
+		`test_submit_seconds_bucket{transaction="BidOnToken",le="0.1"} 1`,
+		`test_submit_seconds_bucket{transaction="BidOnToken",le="1"} 2`,
+		`test_submit_seconds_bucket{transaction="BidOnToken",le="+Inf"} 3`,
+		`test_submit_seconds_sum{transaction="BidOnToken"} 5.55`,
+		`test_submit_seconds_count{transaction="BidOnToken"} 3`,
+	)
+}
+
+func TestMetricsPanicOnMisuse(t *testing.T) {
+	counter := NewCounter("test_misuse_total", "Misuse.", "outcome")
+	expectPanic(t, "a duplicate name", func() { NewCounter("test_misuse_total", "Again.") })
+	expectPanic(t, "missing label values", func() { counter.Inc() })
+}
+
+func expectPanic(t *testing.T, what string, f func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic for %s", what)
+		}
+	}()
+	f()
+}
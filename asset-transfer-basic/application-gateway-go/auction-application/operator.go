@@ -3,42 +3,48 @@ Copyright 2021 IBM All Rights Reserved.
 
 SPDX-License-Identifier: Apache-2.0
 */
-// 需要家
-// Org2のユーザで実行
+// 運営者
+// Org1のユーザで実行 (ウォレットに登録したID、walletctl.go import を参照)
+// 価格倍率 (PostPriceMultiplier) は属性 market.admin=true を持つIDで投稿する
+// go run operator.go operator_func.go operator_demand.go -identity Operator
 
 package main
 
 import (
-	"crypto/x509"
-	"fmt"
+	"flag"
 	"io/ioutil"
-	"log"
-	"path"
+	"os"
 	"time"
 	"net/http"
 
-	"github.com/hyperledger/fabric-gateway/pkg/client"
-	"github.com/hyperledger/fabric-gateway/pkg/identity"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-)
-
-const (
-	mspID         = "Org1MSP"
-	cryptoPath    = "../../../test-network/organizations/peerOrganizations/org1.example.com"
-	certPath      = cryptoPath + "/users/User1@org1.example.com/msp/signcerts/cert.pem"
-	keyPath       = cryptoPath + "/users/User1@org1.example.com/msp/keystore/"
-	tlsCertPath   = cryptoPath + "/peers/peer0.org1.example.com/tls/ca.crt"
-	peerEndpoint  = "localhost:7051"
-	gatewayPeer   = "peer0.org1.example.com"
-	channelName   = "mychannel"
-	chaincodeName = "basic"
+	"assetTransfer/auction-application/clock"
+	"assetTransfer/auction-application/connection"
+	"assetTransfer/auction-application/logging"
+	"assetTransfer/auction-application/metrics"
+	"assetTransfer/auction-application/txsubmit"
+	"assetTransfer/auction-application/wallet"
 )
 
 var now = time.Now()
 
+// clock of the price updates and sweeps, replaced by a fake clock in tests
+var appClock = clock.System
+
+// log level from LOG_LEVEL
+var logger = logging.New("operator")
+
 func main() {
-	log.Println("============ application-golang starts ============")
+	label := flag.String("identity", "Operator", "wallet identity of the operator")
+	flag.Parse()
+
+	logging.SetDefault(logger)
+	logger.Info("application-golang starts")
+
+	// the operator only serves its metrics
+	go func() {
+		http.Handle("/metrics", metrics.Handler())
+		logger.Error("metrics server ends", "error", http.ListenAndServe(":8070", nil))
+	}()
 
 	// simulation reset
 	const url = "http://localhost:8090/reset"
@@ -46,38 +52,35 @@ func main() {
 	defer resp.Body.Close()
 
 	byteArray, _ := ioutil.ReadAll(resp.Body)
-	fmt.Println(string(byteArray))
+	logger.Info("simulation reset", "response", string(byteArray))
 
 	// The gRPC client connection should be shared by all Gateway connections to this endpoint
-	clientConnection := newGrpcConnection()
+	clientConnection, err := connection.Org1Peer.Dial()
+	if err != nil {
+		panic(err)
+	}
 	defer clientConnection.Close()
+	defer wallet.Close()
 
-	id := newIdentity()
-	sign := newSign()
-
-	// Create a Gateway connection for a specific client identity
-	gateway, err := client.Connect(
-		id,
-		client.WithSign(sign),
-		client.WithClientConnection(clientConnection),
-		// Default timeouts for different gRPC calls
-		client.WithEvaluateTimeout(5*time.Second),
-		client.WithEndorseTimeout(15*time.Second),
-		client.WithSubmitTimeout(5*time.Second),
-		client.WithCommitStatusTimeout(1*time.Minute),
-	)
+	// Create a Gateway connection for the operator's identity in the wallet
+	gateway, err := connection.ConnectLabel(clientConnection, *label)
 	if err != nil {
 		panic(err)
 	}
 	defer gateway.Close()
 
-	network := gateway.GetNetwork(channelName)
-	contract := network.GetContract(chaincodeName)
+	network := gateway.GetNetwork(connection.ChannelName)
+	contract := txsubmit.NewContract(network.GetContract(connection.ChaincodeName))
 
-	fmt.Println("initLedger:")
 	InitLedger(contract)
 
-	fmt.Println("updateSolorUnitPrice:")
+	go SweepExpiredTokens(contract)
+
+	// demand-response signals from a local file or an HTTP feed
+	if feed := os.Getenv("DEMAND_RESPONSE_FEED"); feed != "" {
+		go IngestDemandResponse(contract, feed)
+	}
+
 	UpdateSolorUnitPrice(contract)
 
 	// fmt.Println("getAllTokens:")
@@ -104,72 +107,5 @@ func main() {
 	// fmt.Println("exampleErrorHandling:")
 	// ExampleErrorHandling(contract)
 
-	log.Println("============ application-golang ends ============")
-}
-
-// newGrpcConnection creates a gRPC connection to the Gateway server.
-func newGrpcConnection() *grpc.ClientConn {
-	certificate, err := loadCertificate(tlsCertPath)
-	if err != nil {
-		panic(err)
-	}
-
-	certPool := x509.NewCertPool()
-	certPool.AddCert(certificate)
-	transportCredentials := credentials.NewClientTLSFromCert(certPool, gatewayPeer)
-
-	connection, err := grpc.Dial(peerEndpoint, grpc.WithTransportCredentials(transportCredentials))
-	if err != nil {
-		panic(fmt.Errorf("failed to create gRPC connection: %w", err))
-	}
-
-	return connection
-}
-
-// newIdentity creates a client identity for this Gateway connection using an X.509 certificate.
-func newIdentity() *identity.X509Identity {
-	certificate, err := loadCertificate(certPath)
-	if err != nil {
-		panic(err)
-	}
-
-	id, err := identity.NewX509Identity(mspID, certificate)
-	if err != nil {
-		panic(err)
-	}
-
-	return id
-}
-
-func loadCertificate(filename string) (*x509.Certificate, error) {
-	certificatePEM, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read certificate file: %w", err)
-	}
-	return identity.CertificateFromPEM(certificatePEM)
-}
-
-// newSign creates a function that generates a digital signature from a message digest using a private key.
-func newSign() identity.Sign {
-	files, err := ioutil.ReadDir(keyPath)
-	if err != nil {
-		panic(fmt.Errorf("failed to read private key directory: %w", err))
-	}
-	privateKeyPEM, err := ioutil.ReadFile(path.Join(keyPath, files[0].Name()))
-
-	if err != nil {
-		panic(fmt.Errorf("failed to read private key file: %w", err))
-	}
-
-	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
-	if err != nil {
-		panic(err)
-	}
-
-	sign, err := identity.NewPrivateKeySign(privateKey)
-	if err != nil {
-		panic(err)
-	}
-
-	return sign
+	logger.Info("application-golang ends")
 }
@@ -0,0 +1,135 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 運用者
+// デマンドレスポンス信号の取り込みと価格倍率の登録
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"assetTransfer/auction-application/metrics"
+	"assetTransfer/auction-application/txsubmit"
+)
+
+const demandPollInterval = 1 // minutes
+
+// DemandSignal is a demand-response event of the feed: the prices of a zone and small
+// category are multiplied by multiplier between start and end. An empty zone or
+// category applies to all of them, and an empty start is the time the signal is read.
+type DemandSignal struct {
+	ID         string    `json:"id"`
+	Zone       string    `json:"zone"`
+	Category   string    `json:"category"`
+	Multiplier float64   `json:"multiplier"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Reason     string    `json:"reason"`
+}
+
+var priceMultipliersPosted = metrics.NewCounter("operator_price_multipliers_posted_total",
+	"Demand-response price multipliers posted by the operator, by kind (surge or discount).", "kind")
+
+// IngestDemandResponse reads the demand-response feed every minute and posts its new or
+// changed signals as price multipliers. The feed is a JSON array of signals in a local
+// file, or at an http(s) URL.
+func IngestDemandResponse(contract txsubmit.Contract, feed string) {
+	posted := map[string]DemandSignal{}
+	ingestDemandSignals(contract, feed, posted)
+	ticker := appClock.NewTicker(time.Minute * demandPollInterval)
+	for {
+		<-ticker.C()
+		ingestDemandSignals(contract, feed, posted)
+	}
+}
+
+// ingestDemandSignals posts the signals of the feed that are not in posted yet and have
+// not ended. The signals that fail are retried at the next poll.
+func ingestDemandSignals(contract txsubmit.Contract, feed string, posted map[string]DemandSignal) {
+	signals, err := loadDemandSignals(feed)
+	if err != nil {
+		logger.Error("failed to read the demand-response feed", "feed", feed, "error", err)
+		return
+	}
+	nowTime := appClock.Now()
+	for _, signal := range signals {
+		if signal.Start.IsZero() {
+			if previous, ok := posted[signal.ID]; ok {
+				signal.Start = previous.Start
+			} else {
+				signal.Start = nowTime
+			}
+		}
+		if previous, ok := posted[signal.ID]; ok && previous == signal {
+			continue
+		}
+		if !signal.End.After(nowTime) {
+			continue
+		}
+		if err := postPriceMultiplier(contract, signal); err != nil {
+			logger.Error("failed to post the price multiplier", "id", signal.ID, "error", err)
+			continue
+		}
+		posted[signal.ID] = signal
+	}
+}
+
+func loadDemandSignals(feed string) ([]DemandSignal, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(feed, "http://") || strings.HasPrefix(feed, "https://") {
+		var resp *http.Response
+		resp, err = http.Get(feed)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("the feed answered %s", resp.Status)
+		}
+		data, err = ioutil.ReadAll(resp.Body)
+	} else {
+		data, err = ioutil.ReadFile(feed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var signals []DemandSignal
+	if err = json.Unmarshal(data, &signals); err != nil {
+		return nil, fmt.Errorf("invalid demand-response feed: %w", err)
+	}
+	for _, signal := range signals {
+		if signal.ID == "" || signal.Multiplier <= 0 {
+			return nil, fmt.Errorf("invalid demand-response signal %+v: id and a positive multiplier are required", signal)
+		}
+	}
+	return signals, nil
+}
+
+func postPriceMultiplier(contract txsubmit.Contract, signal DemandSignal) error {
+	var stringMultiplier = strconv.FormatFloat(signal.Multiplier, 'f', -1, 64)
+	_, err := contract.Submit("PostPriceMultiplier", txsubmit.DefaultOptions, signal.ID, signal.Zone, signal.Category,
+		stringMultiplier, signal.Start.Format(time.RFC3339), signal.End.Format(time.RFC3339), signal.Reason)
+	if err != nil {
+		return err
+	}
+
+	kind := "surge"
+	if signal.Multiplier < 1 {
+		kind = "discount"
+	}
+	priceMultipliersPosted.Inc(kind)
+	logger.Info("price multiplier posted", "id", signal.ID, "zone", signal.Zone, "category", signal.Category,
+		"multiplier", signal.Multiplier, "start", signal.Start.Format(time.RFC3339), "end", signal.End.Format(time.RFC3339))
+	return nil
+}
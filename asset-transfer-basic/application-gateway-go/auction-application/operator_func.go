@@ -18,7 +18,8 @@ import (
 	"strconv"
 	"errors"
 
-	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"assetTransfer/auction-application/metrics"
+	"assetTransfer/auction-application/txsubmit"
 	//"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
 	//"google.golang.org/grpc/status"
 )
@@ -31,35 +32,49 @@ type Solor struct {
 	Price float64
 }
 
+// SweepResult lists the tokens closed by SweepExpired
+type SweepResult struct {
+	Sold     []string `json:"Sold"`
+	Old      []string `json:"Old"`
+	Extended []string `json:"Extended"`
+	Resale   []string `json:"Resale"`
+	Forward  []string `json:"Forward"`
+	PriceMultipliers []string `json:"Price Multipliers"`
+}
+
+var auctionsClosed = metrics.NewCounter("auction_auctions_closed_total",
+	"Auctions closed by the operator sweep, by the final status of the token.", "status")
+
 const (
 	totalDataNumber = 12
 	hoursAdayHas = 24
+	sweepInterval = 1 // minutes
+	sweepMaxCount = 100
 )
 
-func UpdateSolorUnitPrice(contract *client.Contract) {
+func UpdateSolorUnitPrice(contract txsubmit.Contract) {
 
 	priceList := price()
 
-	nowTime := time.Now()
+	nowTime := appClock.Now()
 	var err error
 	err = errors.New("default error")
 	for err != nil {
 		err = updateSolor(contract, priceList)
 	}
 
-	next := time.Date(nowTime.Year(), nowTime.Month(), nowTime.Day(), nowTime.Hour() + 1, 0, 0, 0, time.Local)
-	fmt.Println(next.Sub(nowTime))
-	timer := time.NewTimer(next.Sub(nowTime))
+	next := time.Date(nowTime.Year(), nowTime.Month(), nowTime.Day(), nowTime.Hour() + 1, 0, 0, 0, nowTime.Location())
+	logger.Debug("next unit price update", "in", next.Sub(nowTime).String())
 	err = errors.New("default error")
-	<-timer.C
+	<-appClock.After(next.Sub(nowTime))
 	for err != nil {
 		err = updateSolor(contract, priceList)
 	}
 	
-	ticker := time.NewTicker(time.Hour * 1)
+	ticker := appClock.NewTicker(time.Hour * 1)
 	for {
 		err = errors.New("default error")
-		<-ticker.C
+		<-ticker.C()
 		//cerr := updateSolor(contract, priceList)
 		for err != nil {
 			err = updateSolor(contract, priceList)
@@ -68,14 +83,37 @@ func UpdateSolorUnitPrice(contract *client.Contract) {
 
 }
 
-func updateSolor(contract *client.Contract, priceList [totalDataNumber][hoursAdayHas]float64) error {
-	nowTime := time.Now()
+// SweepExpiredTokens closes, every minute, the auctions of tokens whose producer did not
+// call AuctionEnd in time.
+func SweepExpiredTokens(contract txsubmit.Contract) {
+	ticker := appClock.NewTicker(time.Minute * sweepInterval)
+	for {
+		<-ticker.C()
+		submitResult, err := contract.Submit("SweepExpired", txsubmit.DefaultOptions, strconv.Itoa(sweepMaxCount))
+		if err != nil {
+			logger.Error("sweep failed", "error", err)
+			continue
+		}
+		var result SweepResult
+		if err = json.Unmarshal(submitResult.Payload, &result); err != nil {
+			logger.Error("failed to parse the sweep result", "error", err)
+			continue
+		}
+		auctionsClosed.Add(float64(len(result.Sold)), "sold")
+		auctionsClosed.Add(float64(len(result.Old)), "old")
+		logger.Info("sweep completed", "sold", result.Sold, "old", result.Old,
+			"extended", result.Extended, "resale", result.Resale, "forward", result.Forward,
+			"price_multipliers", result.PriceMultipliers)
+	}
+}
+
+func updateSolor(contract txsubmit.Contract, priceList [totalDataNumber][hoursAdayHas]float64) error {
+	nowTime := appClock.Now()
 	month := int(nowTime.Month())
 	hour := int(nowTime.Hour())
 		
 	//month: 1-12, hour:0-23
 	price := priceList[month - 1][hour]
-	fmt.Printf("month:%d, hour:%d, price:%g\n", month, hour, price)
 	err := update(contract, "solar", price)
 	if err != nil {
 		return err
@@ -83,32 +121,25 @@ func updateSolor(contract *client.Contract, priceList [totalDataNumber][hoursAda
 	return nil
 }
 
-func update(contract *client.Contract, smallCategory string, unitPrice float64) error {
-
-	fmt.Printf("Submit Transaction: changeUnitPrice\n")
-	var timestamp = time.Now()
+func update(contract txsubmit.Contract, smallCategory string, unitPrice float64) error {
+	var timestamp = appClock.Now()
 	var layout = "2006-01-02T15:04:05+09:00"
 	var stringTimestamp = timestamp.Format(layout)
 	var stringUnitPrice = strconv.FormatFloat(unitPrice, 'f', -1, 64)
-	fmt.Println(smallCategory)
-	fmt.Println(stringUnitPrice)
-	fmt.Println(stringTimestamp)
-
 
 	// smallCategory string, newUnitPrice float64, timestamp time.Time
-	_, err := contract.SubmitTransaction("UpdateUnitPrice", smallCategory, stringUnitPrice, stringTimestamp)
+	_, err := contract.Submit("UpdateUnitPrice", txsubmit.DefaultOptions, smallCategory, stringUnitPrice, stringTimestamp)
 	if err != nil {
+		logger.Error("failed to update the unit price", "category", smallCategory, "unit_price", unitPrice, "error", err)
 		return err
 		// panic(fmt.Errorf("failed to submit transaction: %w", err))
 	}
 
-	fmt.Printf("*** Transaction committed successfully\n")
-
-	energy, err := readToken(contract, "solar-power-cost")
+	energy, err := readToken(contract, smallCategory+"-power-cost")
 	if err != nil {
 		return err
 	}
-	fmt.Println(energy)
+	logger.Info("unit price updated", "category", smallCategory, "unit_price", energy.UnitPrice)
 	return nil
 
 }
@@ -131,10 +162,9 @@ type Energy struct {
 	//MyBidStatus		 string    `json:"My Bid Status"`
 }
 
-func readToken(contract *client.Contract, energyId string) (Energy, error) {
-	fmt.Printf("Async Submit Transaction: ReadToken'\n")
+func readToken(contract txsubmit.Contract, energyId string) (Energy, error) {
 	result := Energy{}
-	evaluateResult, err := contract.EvaluateTransaction("ReadToken", energyId)
+	evaluateResult, err := contract.Evaluate("ReadToken", energyId)
 	if err != nil {
 		return result, err
 		// panic(fmt.Errorf("failed to evaluate transaction: %w", err))
@@ -234,13 +264,11 @@ func price() [totalDataNumber][hoursAdayHas]float64 {
 
 // This type of transaction would typically only be run once by an application the first time it was started after its
 // initial deployment. A new version of the chaincode deployed later would likely not need to run an "init" function.
-func InitLedger(contract *client.Contract) {
-	fmt.Printf("Submit Transaction: InitLedger, function creates the initial set of assets on the ledger \n")
-
-	_, err := contract.SubmitTransaction("InitLedger")
+func InitLedger(contract txsubmit.Contract) {
+	_, err := contract.Submit("InitLedger", txsubmit.DefaultOptions)
 	if err != nil {
 		panic(fmt.Errorf("failed to submit transaction: %w", err))
 	}
 
-	fmt.Printf("*** Transaction committed successfully\n")
+	logger.Info("ledger initialized")
 }
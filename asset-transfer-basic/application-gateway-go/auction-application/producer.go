@@ -9,32 +9,22 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
-	"crypto/x509"
-	"fmt"
 	"io/ioutil"
-	"log"
-	"path"
+	"os"
+	"strings"
 	"time"
 	"net/http"
 	"encoding/json"
 	"bytes"
 
-	"github.com/hyperledger/fabric-gateway/pkg/client"
-	"github.com/hyperledger/fabric-gateway/pkg/identity"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-)
-
-const (
-	mspID         = "Org1MSP"
-	cryptoPath    = "../../../test-network/organizations/peerOrganizations/org1.example.com"
-	certPath      = cryptoPath + "/users/User1@org1.example.com/msp/signcerts/cert.pem"
-	keyPath       = cryptoPath + "/users/User1@org1.example.com/msp/keystore/"
-	tlsCertPath   = cryptoPath + "/peers/peer0.org1.example.com/tls/ca.crt"
-	peerEndpoint  = "localhost:7051"
-	gatewayPeer   = "peer0.org1.example.com"
-	channelName   = "mychannel"
-	chaincodeName = "basic"
+	"assetTransfer/auction-application/clock"
+	"assetTransfer/auction-application/connection"
+	"assetTransfer/auction-application/logging"
+	"assetTransfer/auction-application/metrics"
+	"assetTransfer/auction-application/ratelimit"
+	"assetTransfer/auction-application/txsubmit"
+	"assetTransfer/auction-application/wallet"
+	"assetTransfer/auction-application/webhook"
 )
 
 type Input struct {
@@ -42,30 +32,88 @@ type Input struct {
 	Longitude        float64   `json:"longitude"`
 	User            string    `json:"user"`
 	Category string `json:"category"`
+	// optional descending price: "linear" or "step" from the unit price to floorPrice over 30min
+	Schedule         string    `json:"schedule"`
+	FloorPrice       float64   `json:"floorPrice"`
+	StepMinutes      int       `json:"stepMinutes"`
+	// optional minimum price, kept in the private data of the producer's org
+	ReservePrice     float64   `json:"reservePrice"`
+	reserveSalt      string
+	// optional number of tokens generated, issued in one transaction
+	Tokens           int       `json:"tokens"`
+	// logs of the request, tagged with its request ID
+	logger           *logging.Logger
 }
 
 var now = time.Now()
 
+// clock of the auction rounds, replaced by a fake clock in tests
+var appClock = clock.System
+
+// simulator notifications; endpoints can be overridden with a JSON file in WEBHOOK_CONFIG
+var webhookEndpoints = []webhook.Endpoint{
+	{Name: "token", URL: "http://localhost:8090/token"},
+	{Name: "auction", URL: "http://localhost:8090/auction"},
+}
+
+var dispatcher *webhook.Dispatcher
+
+// log level from LOG_LEVEL
+var logger = logging.New("producer")
+
+// identities of the users; requests are signed by the identity of the authenticated user
+var userWallet *wallet.Wallet
+
+// requests per minute of a client IP and of an authenticated user, overridden with
+// RATE_LIMIT_IP and RATE_LIMIT_USER; 0 disables the limit
+var ipLimiter = ratelimit.FromEnv("ip", 120)
+var userLimiter = ratelimit.FromEnv("user", 60)
+
 func main() {
-	log.Println("============ application-golang starts ============")
-	http.HandleFunc("/createToken", handler)
-	http.ListenAndServe(":8080", nil)
-	log.Println("============ application-golang ends ============")
+	logging.SetDefault(logger)
+	logger.Info("application-golang starts")
+	endpoints, err := webhook.LoadEndpoints(os.Getenv("WEBHOOK_CONFIG"), webhookEndpoints)
+	if err != nil {
+		panic(err)
+	}
+	dispatcher, err = webhook.NewDispatcher("producer-webhook-outbox.json", endpoints)
+	if err != nil {
+		panic(err)
+	}
+	dispatcher.Start()
+
+	userWallet, err = connection.OpenWallet()
+	if err != nil {
+		panic(err)
+	}
+	// the HSM sessions of SIGNER=pkcs11
+	defer wallet.Close()
+
+	http.Handle("/createToken", logger.Middleware(ipLimiter.Middleware(userLimiter.UserMiddleware(userWallet.Authenticate, http.HandlerFunc(handler)))))
+	http.Handle("/withdrawToken", logger.Middleware(ipLimiter.Middleware(userLimiter.UserMiddleware(userWallet.Authenticate, http.HandlerFunc(withdrawHandler)))))
+	http.Handle("/createForward", logger.Middleware(ipLimiter.Middleware(userLimiter.UserMiddleware(userWallet.Authenticate, http.HandlerFunc(forwardHandler)))))
+	http.Handle("/metrics", metrics.Handler())
+	// the dead letters are listed, replayed and purged from this host only
+	go func() {
+		err := http.ListenAndServe("127.0.0.1:8081", logger.Middleware(dispatcher.AdminHandler()))
+		logger.Error("webhook admin ends", "error", err)
+	}()
+	err = http.ListenAndServe(":8080", nil)
+	logger.Error("application-golang ends", "error", err)
 }
 
 func handler(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("hadler")
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed) //405
 		w.Write([]byte("Only POST"))
 		return
 	}
+	user := wallet.FromContext(r.Context())
 	/*if r.Header.Get("Content-Type") != "application/json" {
 		w.WriteHeader(http.StatusBadRequest) //400
 		w.Write([]byte("Only json"))
 		return
 	}*/
-	fmt.Println(r.Header.Get("Content-Type"))
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest) //400
@@ -81,10 +129,30 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
-	createEnergy, timestamp, err := createContract(requestInput)
+	if requestInput.User != "" && requestInput.User != user.Label {
+		w.WriteHeader(http.StatusForbidden) //403
+		w.Write([]byte("user does not match the authenticated identity"))
+		return
+	}
+	requestInput.User = user.Label
+	requestInput.logger = logging.FromContext(r.Context()).With("user", user.Label)
+	if requestInput.ReservePrice > 0 {
+		requestInput.reserveSalt, err = newSalt()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+	if requestInput.Tokens > 1 {
+		createTokensResponse(w, requestInput, user)
+		return
+	}
+	createEnergy, timestamp, err := createContract(requestInput, user)
 	// fmt.Println(createEnergy)
 	// fmt.Println(err)
 	if err != nil {
+		requestInput.logger.Error("failed to connect to the gateway", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
@@ -97,46 +165,42 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
+	// the chaincode refuses tokens beyond the quota of the identity (SetMarketQuota)
+	if strings.Contains(createEnergy.Error, "tokens per hour is used up") {
+		w.WriteHeader(http.StatusTooManyRequests) //429
+	}
 	w.Write([]byte(buf.String()))
 
-	if createEnergy.Error == "" {
-		go HttpPostCreatedToken(createEnergy)
-		go auctionContract(createEnergy, timestamp, requestInput)
+	if createEnergy.Error != "" {
+		requestInput.logger.Warn("token not created", "error", createEnergy.Error)
+	} else {
+		go HttpPostCreatedToken(createEnergy, requestInput)
+		go auctionContract(createEnergy, timestamp, requestInput, user)
 	}
 
 }
 
-func createContract(input Input) (Energy, time.Time, error) {
+func createContract(input Input, user *wallet.Identity) (Energy, time.Time, error) {
 	var energy Energy
 	var timestamp time.Time
 
 	// The gRPC client connection should be shared by all Gateway connections to this endpoint
-	clientConnection := newGrpcConnection()
+	clientConnection, err := connection.Org1Peer.Dial()
+	if err != nil {
+		return energy, timestamp, err
+	}
 	defer clientConnection.Close()
 
-	id := newIdentity()
-	sign := newSign()
-
 	// Create a Gateway connection for a specific client identity
-	gateway, err := client.Connect(
-		id,
-		client.WithSign(sign),
-		client.WithClientConnection(clientConnection),
-		// Default timeouts for different gRPC calls
-		client.WithEvaluateTimeout(5*time.Second),
-		client.WithEndorseTimeout(15*time.Second),
-		client.WithSubmitTimeout(5*time.Second),
-		client.WithCommitStatusTimeout(1*time.Minute),
-	)
+	gateway, err := connection.Connect(clientConnection, user)
 	if err != nil {
 		return energy, timestamp, err
 	}
 	defer gateway.Close()
 
-	network := gateway.GetNetwork(channelName)
-	contract := network.GetContract(chaincodeName)
+	network := gateway.GetNetwork(connection.ChannelName)
+	contract := txsubmit.NewContract(network.GetContract(connection.ChaincodeName))
 
-	fmt.Println("Create:")
 	energy, timestamp = Create(contract, input)
 	
 	return energy, timestamp, nil
@@ -144,103 +208,26 @@ func createContract(input Input) (Energy, time.Time, error) {
 
 }
 
-func auctionContract(energy Energy, timestamp time.Time, input Input) {
+func auctionContract(energy Energy, timestamp time.Time, input Input, user *wallet.Identity) {
 	//log.Println("============ application-golang starts ============")
 
 	// The gRPC client connection should be shared by all Gateway connections to this endpoint
-	clientConnection := newGrpcConnection()
+	clientConnection, err := connection.Org1Peer.Dial()
+	if err != nil {
+		panic(err)
+	}
 	defer clientConnection.Close()
 
-	id := newIdentity()
-	sign := newSign()
-
 	// Create a Gateway connection for a specific client identity
-	gateway, err := client.Connect(
-		id,
-		client.WithSign(sign),
-		client.WithClientConnection(clientConnection),
-		// Default timeouts for different gRPC calls
-		client.WithEvaluateTimeout(5*time.Second),
-		client.WithEndorseTimeout(15*time.Second),
-		client.WithSubmitTimeout(5*time.Second),
-		client.WithCommitStatusTimeout(1*time.Minute),
-	)
+	gateway, err := connection.Connect(clientConnection, user)
 	if err != nil {
 		panic(err)
 	}
 	defer gateway.Close()
 
-	network := gateway.GetNetwork(channelName)
-	contract := network.GetContract(chaincodeName)
+	network := gateway.GetNetwork(connection.ChannelName)
+	contract := txsubmit.NewContract(network.GetContract(connection.ChaincodeName))
 
-	fmt.Println("Auction:")
 	Auction(contract, energy, timestamp, input)
 
 }
-
-// newGrpcConnection creates a gRPC connection to the Gateway server.
-func newGrpcConnection() *grpc.ClientConn {
-	certificate, err := loadCertificate(tlsCertPath)
-	if err != nil {
-		panic(err)
-	}
-
-	certPool := x509.NewCertPool()
-	certPool.AddCert(certificate)
-	transportCredentials := credentials.NewClientTLSFromCert(certPool, gatewayPeer)
-
-	connection, err := grpc.Dial(peerEndpoint, grpc.WithTransportCredentials(transportCredentials))
-	if err != nil {
-		panic(fmt.Errorf("failed to create gRPC connection: %w", err))
-	}
-
-	return connection
-}
-
-// newIdentity creates a client identity for this Gateway connection using an X.509 certificate.
-func newIdentity() *identity.X509Identity {
-	certificate, err := loadCertificate(certPath)
-	if err != nil {
-		panic(err)
-	}
-
-	id, err := identity.NewX509Identity(mspID, certificate)
-	if err != nil {
-		panic(err)
-	}
-
-	return id
-}
-
-func loadCertificate(filename string) (*x509.Certificate, error) {
-	certificatePEM, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read certificate file: %w", err)
-	}
-	return identity.CertificateFromPEM(certificatePEM)
-}
-
-// newSign creates a function that generates a digital signature from a message digest using a private key.
-func newSign() identity.Sign {
-	files, err := ioutil.ReadDir(keyPath)
-	if err != nil {
-		panic(fmt.Errorf("failed to read private key directory: %w", err))
-	}
-	privateKeyPEM, err := ioutil.ReadFile(path.Join(keyPath, files[0].Name()))
-
-	if err != nil {
-		panic(fmt.Errorf("failed to read private key file: %w", err))
-	}
-
-	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
-	if err != nil {
-		panic(err)
-	}
-
-	sign, err := identity.NewPrivateKeySign(privateKey)
-	if err != nil {
-		panic(err)
-	}
-
-	return sign
-}
@@ -0,0 +1,160 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 発電者
+// 複数トークンの一括発行 (CreateTokens)
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"assetTransfer/auction-application/connection"
+	"assetTransfer/auction-application/txsubmit"
+	"assetTransfer/auction-application/wallet"
+)
+
+// maxTokensPerRequest is the limit of the chaincode on the items of a bulk transaction
+const maxTokensPerRequest = 50
+
+type TokenSpec struct {
+	ID            string  `json:"ID"`
+	Latitude      float64 `json:"Latitude"`
+	Longitude     float64 `json:"Longitude"`
+	LargeCategory string  `json:"LargeCategory"`
+	SmallCategory string  `json:"SmallCategory"`
+}
+
+// BulkResult is the result of one item of CreateTokens
+type BulkResult struct {
+	ID      string `json:"ID"`
+	Message string `json:"Message"`
+	Error   string `json:"Error"`
+}
+
+// createTokensResponse issues input.Tokens tokens and starts their auctions. The response
+// lists the tokens, with the error of the ones that were not created.
+func createTokensResponse(w http.ResponseWriter, input Input, user *wallet.Identity) {
+	if input.Tokens > maxTokensPerRequest {
+		w.WriteHeader(http.StatusBadRequest) //400
+		w.Write([]byte(fmt.Sprintf("at most %d tokens can be created in a request", maxTokensPerRequest)))
+		return
+	}
+	energies, timestamp, err := createTokensContract(input, user)
+	if err != nil {
+		input.logger.Error("failed to create tokens", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if err = enc.Encode(&energies); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Write([]byte(buf.String()))
+
+	for _, energy := range energies {
+		if energy.Error != "" {
+			input.logger.Warn("token not created", "id", energy.ID, "error", energy.Error)
+			continue
+		}
+		go HttpPostCreatedToken(energy, input)
+		go auctionContract(energy, timestamp, input, user)
+	}
+}
+
+func createTokensContract(input Input, user *wallet.Identity) ([]Energy, time.Time, error) {
+	var timestamp time.Time
+
+	// The gRPC client connection should be shared by all Gateway connections to this endpoint
+	clientConnection, err := connection.Org1Peer.Dial()
+	if err != nil {
+		return nil, timestamp, err
+	}
+	defer clientConnection.Close()
+
+	// Create a Gateway connection for a specific client identity
+	gateway, err := connection.Connect(clientConnection, user)
+	if err != nil {
+		return nil, timestamp, err
+	}
+	defer gateway.Close()
+
+	network := gateway.GetNetwork(connection.ChannelName)
+	contract := txsubmit.NewContract(network.GetContract(connection.ChaincodeName))
+
+	return CreateTokens(contract, input)
+}
+
+// CreateTokens issues input.Tokens tokens in one transaction. A token that was not
+// created is returned with its error, as Create does.
+func CreateTokens(contract txsubmit.Contract, input Input) ([]Energy, time.Time, error) {
+	var largeCategory string
+	if input.Category == "solar" || input.Category == "wind" {
+		largeCategory = "green"
+	} else {
+		largeCategory = "depletable"
+	}
+	var timestamp = appClock.Now()
+	rand.Seed(time.Now().UnixNano())
+	prefix := timestamp.Format(layout) + input.User + "-" + strconv.Itoa(rand.Intn(10000))
+
+	specs := make([]TokenSpec, input.Tokens)
+	for i := range specs {
+		specs[i] = TokenSpec{ID: prefix + "-" + strconv.Itoa(i), Latitude: input.Latitude, Longitude: input.Longitude,
+			LargeCategory: largeCategory, SmallCategory: input.Category}
+	}
+	specsJSON, err := json.Marshal(specs)
+	if err != nil {
+		return nil, timestamp, err
+	}
+	reserve, err := reserveTransient(input)
+	if err != nil {
+		return nil, timestamp, err
+	}
+	options := txsubmit.DefaultOptions
+	options.Transient = reserve
+	options.Logger = input.logger
+	result, err := contract.Submit("CreateTokens", options, input.User, string(specsJSON), timestamp.Format(layout))
+	if err != nil {
+		return nil, timestamp, err
+	}
+	var results []BulkResult
+	err = json.Unmarshal(result.Payload, &results)
+	if err != nil {
+		return nil, timestamp, fmt.Errorf("failed to parse the results of CreateTokens: %w", err)
+	}
+	input.logger.Info("tokens created", "tx_id", result.TransactionID, "count", len(results), "category", input.Category)
+
+	energies := make([]Energy, len(results))
+	for i, item := range results {
+		if item.Error != "" {
+			energies[i] = Energy{ID: item.ID, Error: "createToken: " + item.Error}
+			continue
+		}
+		if input.Schedule != "" {
+			err = setPriceSchedule(contract, item.ID, input)
+			if err != nil {
+				energies[i] = Energy{ID: item.ID, Error: "setPriceSchedule: " + err.Error()}
+				continue
+			}
+		}
+		energies[i], err = readToken(contract, item.ID)
+		if err != nil {
+			energies[i] = Energy{ID: item.ID, Error: "readToken: " + err.Error()}
+		}
+	}
+	return energies, timestamp, nil
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 発電者
+// 予測発電量に基づく先渡しトークン (forward) の発行とオークション
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"assetTransfer/auction-application/connection"
+	"assetTransfer/auction-application/logging"
+	"assetTransfer/auction-application/metrics"
+	"assetTransfer/auction-application/txsubmit"
+	"assetTransfer/auction-application/wallet"
+)
+
+type ForwardInput struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	User      string  `json:"user"`
+	Category  string  `json:"category"`
+	// kWh promised for the delivery window, at most the forecast generation
+	Quantity      float64   `json:"quantity"`
+	Forecast      float64   `json:"forecast"`
+	DeliveryStart time.Time `json:"deliveryStart"`
+	DeliveryEnd   time.Time `json:"deliveryEnd"`
+	// logs of the request, tagged with its request ID
+	logger *logging.Logger
+}
+
+type Forward struct {
+	DocType          string    `json:"DocType"`
+	ID               string    `json:"ID"`
+	Producer         string    `json:"Producer"`
+	Owner            string    `json:"Owner"`
+	LargeCategory    string    `json:"LargeCategory"`
+	SmallCategory    string    `json:"SmallCategory"`
+	Latitude         float64   `json:"Latitude"`
+	Longitude        float64   `json:"Longitude"`
+	Quantity         float64   `json:"Quantity"`
+	Forecast         float64   `json:"Forecast"`
+	DeliveryStart    time.Time `json:"Delivery Start"`
+	DeliveryEnd      time.Time `json:"Delivery End"`
+	UnitPrice        float64   `json:"Unit Price"`
+	BidPrice         float64   `json:"Bid Price"`
+	AuctionStartTime time.Time `json:"Auction Start Time"`
+	AuctionEndTime   time.Time `json:"Auction End Time"`
+	Collateral       float64   `json:"Collateral"`
+	Status           string    `json:"Status"`
+	Delivered        float64   `json:"Delivered"`
+	Penalty          float64   `json:"Penalty"`
+}
+
+var forwardsClosed = metrics.NewCounter("auction_forwards_closed_total",
+	"Forward auctions closed by the producer, by the final status of the forward.", "status")
+
+func forwardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed) //405
+		w.Write([]byte("Only POST"))
+		return
+	}
+	user := wallet.FromContext(r.Context())
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest) //400
+		w.Write([]byte(err.Error()))
+		return
+	}
+	var requestInput ForwardInput
+	err = json.Unmarshal(body, &requestInput)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest) //400
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if requestInput.User != "" && requestInput.User != user.Label {
+		w.WriteHeader(http.StatusForbidden) //403
+		w.Write([]byte("user does not match the authenticated identity"))
+		return
+	}
+	requestInput.User = user.Label
+	requestInput.logger = logging.FromContext(r.Context()).With("user", user.Label)
+
+	forward, err := forwardContract(requestInput, user)
+	if err != nil {
+		requestInput.logger.Warn("forward not created", "error", err)
+		w.WriteHeader(http.StatusConflict) //409
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if err = enc.Encode(&forward); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Write([]byte(buf.String()))
+
+	go forwardAuctionContract(forward, requestInput, user)
+}
+
+func forwardContract(input ForwardInput, user *wallet.Identity) (Forward, error) {
+	// The gRPC client connection should be shared by all Gateway connections to this endpoint
+	clientConnection, err := connection.Org1Peer.Dial()
+	if err != nil {
+		return Forward{}, err
+	}
+	defer clientConnection.Close()
+
+	// Create a Gateway connection for a specific client identity
+	gateway, err := connection.Connect(clientConnection, user)
+	if err != nil {
+		return Forward{}, err
+	}
+	defer gateway.Close()
+
+	network := gateway.GetNetwork(connection.ChannelName)
+	contract := txsubmit.NewContract(network.GetContract(connection.ChaincodeName))
+
+	return CreateForward(contract, input)
+}
+
+func forwardAuctionContract(forward Forward, input ForwardInput, user *wallet.Identity) {
+	// the auction is closed on a connection of its own, opened when it ends
+	<-appClock.After(forward.AuctionEndTime.Sub(appClock.Now()))
+
+	// The gRPC client connection should be shared by all Gateway connections to this endpoint
+	clientConnection, err := connection.Org1Peer.Dial()
+	if err != nil {
+		input.logger.Error("failed to connect to the gateway", "id", forward.ID, "error", err)
+		return
+	}
+	defer clientConnection.Close()
+
+	// Create a Gateway connection for a specific client identity
+	gateway, err := connection.Connect(clientConnection, user)
+	if err != nil {
+		input.logger.Error("failed to connect to the gateway", "id", forward.ID, "error", err)
+		return
+	}
+	defer gateway.Close()
+
+	network := gateway.GetNetwork(connection.ChannelName)
+	contract := txsubmit.NewContract(network.GetContract(connection.ChaincodeName))
+
+	ForwardAuction(contract, forward, input)
+}
+
+// CreateForward issues a forward for the delivery window of input.
+func CreateForward(contract txsubmit.Contract, input ForwardInput) (Forward, error) {
+	var largeCategory string
+	if input.Category == "solar" || input.Category == "wind" {
+		largeCategory = "green"
+	} else {
+		largeCategory = "depletable"
+	}
+	var timestamp = appClock.Now()
+	id := timestamp.Format(layout) + input.User + "-forward-" + strconv.Itoa(rand.Intn(10000))
+
+	// the delivery window is passed with the offset of the request
+	_, err := contract.Submit("CreateForward", txsubmit.DefaultOptions, id,
+		strconv.FormatFloat(input.Latitude, 'f', -1, 64), strconv.FormatFloat(input.Longitude, 'f', -1, 64),
+		input.User, largeCategory, input.Category,
+		strconv.FormatFloat(input.Quantity, 'f', -1, 64), strconv.FormatFloat(input.Forecast, 'f', -1, 64),
+		input.DeliveryStart.Format(time.RFC3339), input.DeliveryEnd.Format(time.RFC3339), timestamp.Format(layout))
+	if err != nil {
+		return Forward{}, err
+	}
+	input.logger.Info("forward created", "id", id, "quantity", input.Quantity,
+		"delivery_start", input.DeliveryStart.Format(time.RFC3339))
+	return readForward(contract, id)
+}
+
+// ForwardAuction closes the auction of a forward, which must have reached its end time.
+func ForwardAuction(contract txsubmit.Contract, forward Forward, input ForwardInput) {
+	options := txsubmit.DefaultOptions
+	options.Logger = input.logger
+	result, err := contract.Submit("ForwardAuctionEnd", options, forward.ID, input.User, appClock.Now().Format(layout))
+	if err != nil {
+		input.logger.Error("forward auction end failed", "id", forward.ID, "error", err)
+		return
+	}
+	input.logger.Debug("forward auction end result", "id", forward.ID, "result", string(result.Payload))
+
+	closed, err := readForward(contract, forward.ID)
+	if err != nil {
+		forwardsClosed.Inc("unknown")
+		input.logger.Error("failed to read the closed forward", "id", forward.ID, "error", err)
+		return
+	}
+	forwardsClosed.Inc(closed.Status)
+	input.logger.Info("forward auction closed", "id", forward.ID, "status", closed.Status,
+		"owner", closed.Owner, "bid_price", closed.BidPrice, "collateral", closed.Collateral)
+}
+
+func readForward(contract txsubmit.Contract, id string) (Forward, error) {
+	var forward Forward
+	evaluateResult, err := contract.Evaluate("ReadForward", id)
+	if err != nil {
+		return forward, err
+	}
+	err = json.Unmarshal(evaluateResult, &forward)
+	if err != nil {
+		return forward, fmt.Errorf("failed to parse the forward %s: %w", id, err)
+	}
+	return forward, nil
+}
@@ -13,14 +13,12 @@ import (
 	//"context"
 	"encoding/json"
 	//"errors"
-	"fmt"
 	"time"
 	"math/rand"
 	"strconv"
-	"net/http"
-	"bytes"
 
-	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"assetTransfer/auction-application/metrics"
+	"assetTransfer/auction-application/txsubmit"
 	//"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
 	//"google.golang.org/grpc/status"
 )
@@ -40,9 +38,20 @@ type Energy struct {
 	Producer         string    `json:"Producer"`
 	SmallCategory    string    `json:"SmallCategory"`
 	Status           string    `json:"Status"`
+	PriceSchedule    *PriceSchedule `json:"Price Schedule,omitempty"`
 	Error string `json:"Error"`
 }
 
+type PriceSchedule struct {
+	Type        string  `json:"Type"`
+	StartPrice  float64 `json:"Start Price"`
+	FloorPrice  float64 `json:"Floor Price"`
+	StepMinutes int     `json:"Step Minutes"`
+}
+
+var auctionsClosed = metrics.NewCounter("auction_auctions_closed_total",
+	"Auctions closed by the producer, by the final status of the token.", "status")
+
 const (
 	earthRadius = 6378137.0
 	//myLatitude         = "35.54738979492469" //0-89
@@ -53,14 +62,14 @@ const (
 	layout = "2006-01-02T15:04:05+09:00"
 )
 
-func Create(contract *client.Contract, input Input) (Energy, time.Time) {
+func Create(contract txsubmit.Contract, input Input) (Energy, time.Time) {
 	var largeCategory string
 	if (input.Category == "solar" || input.Category == "wind") {
 		largeCategory = "green"
 	} else {
 		largeCategory = "depletable"
 	}
-	var timestamp = time.Now()
+	var timestamp = appClock.Now()
 	rand.Seed(time.Now().UnixNano())
 	// create id
 	id := timestamp.Format(layout) + input.User + "-" + strconv.Itoa(rand.Intn(10000))
@@ -78,37 +87,40 @@ func Create(contract *client.Contract, input Input) (Energy, time.Time) {
 
 }
 
-func Auction(contract *client.Contract, energy Energy, timestamp time.Time, input Input) {
-	ticker := time.NewTicker(time.Minute * auctionEndInterval)
+func Auction(contract txsubmit.Contract, energy Energy, timestamp time.Time, input Input) {
+	ticker := appClock.NewTicker(time.Minute * auctionEndInterval)
 	count := 0
 	// Check for bidders every 5 minutes
 loop:
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			count++
-			fmt.Printf("count:%d\n", count)
 			auctionEndTimestamp := timestamp.Add(time.Minute * time.Duration(count*auctionEndInterval))
 			massage, err := auctionEnd(contract, energy.ID, auctionEndTimestamp, input)
 			if (err != nil) {
-				fmt.Println(err)
+				input.logger.Error("auction round failed", "id", energy.ID, "round", count, "error", err)
 			}
-			fmt.Printf("timestamp:%v, id:%s\n", auctionEndTimestamp, energy.ID)
+			input.logger.Debug("auction round closed", "id", energy.ID, "round", count, "timestamp", auctionEndTimestamp.Format(layout))
 
 			stopmassage1 := "the energy " + energy.ID + " was generated more than 30min ago. This was not sold."
 			stopmassage2 := "the energy " + energy.ID + " was sold. It was generetad more than 30min ago."
 			stopmassage3 := "the energy " + energy.ID + " was sold"
-			if massage == stopmassage1 || massage == stopmassage2 || massage == stopmassage3 {
+			stopmassage4 := "the energy " + energy.ID + " was withdrawn"
+			if massage == stopmassage1 || massage == stopmassage2 || massage == stopmassage3 || massage == stopmassage4 {
 				ticker.Stop()
 				break loop
-			} else if count == auctionEndMax - 1 {
+			} else if count == auctionEndMax - 1 && input.Schedule == "" {
 				// discount the auction between 25min and 30min
 				err = discountUnitPrice(contract, energy.ID)
-				fmt.Println("discount")
+				if err != nil {
+					input.logger.Error("discount failed", "id", energy.ID, "error", err)
+				} else {
+					input.logger.Info("unit price discounted", "id", energy.ID)
+				}
 			} else if count == auctionEndMax {
 				// last auction
 				ticker.Stop()
-				fmt.Println("final")
 				break loop
 			} 
 		}
@@ -116,25 +128,40 @@ loop:
 	// http post
 	resultEnergy, err := readToken(contract, energy.ID)
 	if err != nil {
-		fmt.Println(err)
+		auctionsClosed.Inc("unknown")
+		input.logger.Error("failed to read the closed token", "id", energy.ID, "error", err)
 	} else {
-		fmt.Println(resultEnergy)
-		httpPostAuctionEnd(resultEnergy)
+		auctionsClosed.Inc(resultEnergy.Status)
+		input.logger.Info("auction closed", "id", energy.ID, "status", resultEnergy.Status,
+			"owner", resultEnergy.Owner, "bid_price", resultEnergy.BidPrice)
+		httpPostAuctionEnd(resultEnergy, input)
 	}
 }
 
-func createToken(contract *client.Contract, energyId string, timestamp time.Time, largeCAT string, smallCAT string, input Input) (Energy, error) {
-	fmt.Printf("Submit Transaction: CreateToken, creates new token with ID, Latitude, Longitude, Owner, Large Category, Small Category and timestamp \n")
+func createToken(contract txsubmit.Contract, energyId string, timestamp time.Time, largeCAT string, smallCAT string, input Input) (Energy, error) {
 	var stringTimestamp = timestamp.Format(layout)
 	var stringLatitude = strconv.FormatFloat(input.Latitude, 'f', -1, 64)
 	var stringLongitude = strconv.FormatFloat(input.Longitude, 'f', -1, 64)
 	var energy Energy
-	_, err := contract.SubmitTransaction("CreateToken", energyId, stringLatitude, stringLongitude, input.User, largeCAT, smallCAT, stringTimestamp)
+	reserve, err := reserveTransient(input)
+	if err != nil {
+		return energy, err
+	}
+	options := txsubmit.DefaultOptions
+	options.Transient = reserve
+	options.Logger = input.logger
+	_, err = contract.Submit("CreateToken", options, energyId, stringLatitude, stringLongitude, input.User, largeCAT, smallCAT, stringTimestamp)
 	if err != nil {
 		return energy, err
 	}
+	input.logger.Info("token created", "id", energyId, "category", smallCAT)
 
-	fmt.Printf("*** Transaction committed successfully\n")
+	if input.Schedule != "" {
+		err = setPriceSchedule(contract, energyId, input)
+		if err != nil {
+			return energy, err
+		}
+	}
 
 	energy, err = readToken(contract, energyId)
 	if err != nil {
@@ -144,35 +171,49 @@ func createToken(contract *client.Contract, energyId string, timestamp time.Time
 	return energy, nil
 }
 
-func discountUnitPrice(contract *client.Contract, energyId string) error {
+func setPriceSchedule(contract txsubmit.Contract, energyId string, input Input) error {
+	input.logger.Info("setting price schedule", "id", energyId, "schedule", input.Schedule, "floor_price", input.FloorPrice)
+	var stringFloorPrice = strconv.FormatFloat(input.FloorPrice, 'f', -1, 64)
+	_, err := contract.Submit("SetPriceSchedule", txsubmit.DefaultOptions, energyId, input.Schedule, stringFloorPrice, strconv.Itoa(input.StepMinutes))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func discountUnitPrice(contract txsubmit.Contract, energyId string) error {
 
-	_, err := contract.SubmitTransaction("DiscountUnitPrice", energyId)
+	_, err := contract.Submit("DiscountUnitPrice", txsubmit.DefaultOptions, energyId)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func auctionEnd(contract *client.Contract, energyId string, timestamp time.Time, input Input) (string, error) {
-	fmt.Printf("Evaluate Transaction: auctionEnd\n")
+func auctionEnd(contract txsubmit.Contract, energyId string, timestamp time.Time, input Input) (string, error) {
 	var stringTimestamp = timestamp.Format(layout)
-	fmt.Println(energyId)
-	fmt.Println(input.User)
-	fmt.Println(stringTimestamp)
-	evaluateResult, err := contract.SubmitTransaction("AuctionEnd", energyId, input.User, stringTimestamp)
+	// the reserve price is passed again so that the chaincode can check it against its hash
+	reserve, err := reserveTransient(input)
 	if err != nil {
 		return "", err
 	}
-	massage := string(evaluateResult)
+	// AuctionEnd reads the token consumers are bidding on, so it is retried on read conflicts
+	options := txsubmit.DefaultOptions
+	options.Transient = reserve
+	options.Logger = input.logger
+	result, err := contract.Submit("AuctionEnd", options, energyId, input.User, stringTimestamp)
+	if err != nil {
+		return "", err
+	}
+	massage := string(result.Payload)
 
-	fmt.Printf("*** Result:%s\n", massage)
+	input.logger.Debug("auction end result", "id", energyId, "result", massage)
 	return massage, nil
 }
 
-func readToken(contract *client.Contract, energyId string) (Energy, error) {
-	fmt.Printf("Async Submit Transaction: ReadToken\n")
+func readToken(contract txsubmit.Contract, energyId string) (Energy, error) {
 	var energy Energy
-	evaluateResult, err := contract.EvaluateTransaction("ReadToken", energyId)
+	evaluateResult, err := contract.Evaluate("ReadToken", energyId)
 	if err != nil {
 		return energy, err
 	}
@@ -185,10 +226,7 @@ func readToken(contract *client.Contract, energyId string) (Energy, error) {
 	return energy, nil
 }
 
-func HttpPostCreatedToken(energy Energy) {
-	// const URL = "https://webhook.site/ba5e750f-7ffd-437b-962b-02ea67be8ca6"
-	const URL = "http://localhost:8090/token"
-	fmt.Println(energy)
+func HttpPostCreatedToken(energy Energy, input Input) {
 	type CreateToken struct {
 		TokenId          string    `json:"TokenId"`
 		TokenPrice        float64   `json:"TokenPrice"`
@@ -202,26 +240,13 @@ func HttpPostCreatedToken(energy Energy) {
 	token.TokenLon = energy.Longitude
 	token.TokenPrice = energy.UnitPrice
 
-	tokenJson, err := json.Marshal(token)
+	err := dispatcher.Enqueue("token", token)
 	if err != nil {
-		fmt.Printf("err1")
-		fmt.Println(err)
-	}
-	res, err2 := http.Post(URL, "application/json", bytes.NewBuffer(tokenJson))
-	defer res.Body.Close()
-
-	if err2 != nil {
-		fmt.Printf("err2")
-		fmt.Println(err2)
-	} else {
-		fmt.Println(res.Status)
+		input.logger.Error("failed to enqueue webhook", "endpoint", "token", "id", energy.ID, "error", err)
 	}
 }
 
-func httpPostAuctionEnd(energy Energy) {
-	// const URL = "https://webhook.site/ba5e750f-7ffd-437b-962b-02ea67be8ca6"
-	const URL = "http://localhost:8090/auction"
-
+func httpPostAuctionEnd(energy Energy, input Input) {
 	type AuctionEndToken struct {
 		WinnerCarId string `json:"WinnerCarId"`
 		TokenId string `json:"TokenId"`
@@ -236,19 +261,10 @@ func httpPostAuctionEnd(energy Energy) {
 	}
 
 	token.TokenId = energy.ID
-	
 
-	tokenJson, err := json.Marshal(token)
+	err := dispatcher.Enqueue("auction", token)
 	if err != nil {
-		fmt.Println(err)
-	}
-	res, err2 := http.Post(URL, "application/json", bytes.NewBuffer(tokenJson))
-	defer res.Body.Close()
-
-	if err2 != nil {
-		fmt.Println(err2)
-	} else {
-		fmt.Println(res.Status)
+		input.logger.Error("failed to enqueue webhook", "endpoint", "auction", "id", energy.ID, "error", err)
 	}
 }
 
@@ -0,0 +1,121 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 発電者
+// 最低価格 (reserve price) とトークンの取り下げ
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"assetTransfer/auction-application/connection"
+	"assetTransfer/auction-application/logging"
+	"assetTransfer/auction-application/txsubmit"
+	"assetTransfer/auction-application/wallet"
+)
+
+type WithdrawInput struct {
+	ID string `json:"id"`
+}
+
+// Reserve is passed to the chaincode in the transient map so that the reserve price
+// is only stored in the private data of the producer's org.
+type Reserve struct {
+	ReservePrice float64 `json:"ReservePrice"`
+	Salt         string  `json:"Salt"`
+}
+
+func withdrawHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed) //405
+		w.Write([]byte("Only POST"))
+		return
+	}
+	user := wallet.FromContext(r.Context())
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest) //400
+		w.Write([]byte(err.Error()))
+		return
+	}
+	var requestInput WithdrawInput
+	err = json.Unmarshal(body, &requestInput)
+	if err != nil || requestInput.ID == "" {
+		w.WriteHeader(http.StatusBadRequest) //400
+		w.Write([]byte("id is required"))
+		return
+	}
+
+	logger := logging.FromContext(r.Context())
+	status, err := withdrawContract(requestInput.ID, user)
+	if err != nil {
+		logger.Warn("withdraw failed", "id", requestInput.ID, "user", user.Label, "error", err)
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	logger.Info("token withdrawn", "id", requestInput.ID, "user", user.Label)
+	w.Write([]byte("the energy " + requestInput.ID + " was withdrawn"))
+}
+
+func withdrawContract(energyId string, user *wallet.Identity) (int, error) {
+	// The gRPC client connection should be shared by all Gateway connections to this endpoint
+	clientConnection, err := connection.Org1Peer.Dial()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer clientConnection.Close()
+
+	// Create a Gateway connection for a specific client identity
+	gateway, err := connection.Connect(clientConnection, user)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer gateway.Close()
+
+	network := gateway.GetNetwork(connection.ChannelName)
+	contract := txsubmit.NewContract(network.GetContract(connection.ChaincodeName))
+
+	energy, err := readToken(contract, energyId)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+	if energy.Producer != user.Label {
+		return http.StatusForbidden, fmt.Errorf("the energy %s was not produced by %s", energyId, user.Label)
+	}
+
+	_, err = contract.Submit("WithdrawToken", txsubmit.DefaultOptions, energyId)
+	if err != nil {
+		return http.StatusConflict, err
+	}
+	return http.StatusOK, nil
+}
+
+// reserveTransient returns the transient data carrying the reserve price of input, if
+// it has one.
+func reserveTransient(input Input) (map[string][]byte, error) {
+	if input.ReservePrice <= 0 {
+		return nil, nil
+	}
+	reserveJSON, err := json.Marshal(Reserve{ReservePrice: input.ReservePrice, Salt: input.reserveSalt})
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{"reserve": reserveJSON}, nil
+}
+
+func newSalt() (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt), nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package ratelimit throttles the HTTP requests of the auction services per client IP
+// and per authenticated user, so that a single client cannot flood the chaincode with
+// tokens and bids. Throttled requests get 429 Too Many Requests with a Retry-After.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"assetTransfer/auction-application/clock"
+	"assetTransfer/auction-application/logging"
+	"assetTransfer/auction-application/metrics"
+	"assetTransfer/auction-application/wallet"
+)
+
+// maxBuckets bounds the memory of a limiter; the full buckets are dropped beyond it
+const maxBuckets = 10000
+
+var throttled = metrics.NewCounter("http_requests_throttled_total",
+	"Requests refused with 429 Too Many Requests, by limiter.", "limiter")
+
+// Limiter is a token bucket per key, refilled at a steady rate up to a burst.
+type Limiter struct {
+	name  string
+	clock clock.Clock
+	rate  float64 // tokens per second, 0 for no limit
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns a limiter allowing perMinute requests per key on average and burst
+// requests at once. A limiter with perMinute 0 allows every request.
+func New(name string, clk clock.Clock, perMinute int, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{name: name, clock: clk, rate: float64(perMinute) / 60, burst: float64(burst),
+		buckets: map[string]*bucket{}}
+}
+
+// FromEnv returns a limiter with the requests per minute of the environment variable
+// RATE_LIMIT_<NAME>, perMinute by default, and a burst of the same size.
+func FromEnv(name string, perMinute int) *Limiter {
+	variable := "RATE_LIMIT_" + strings.ToUpper(name)
+	if value := os.Getenv(variable); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			fmt.Fprintf(os.Stderr, "invalid %s %q, using %d\n", variable, value, perMinute)
+		} else {
+			perMinute = n
+		}
+	}
+	return New(name, clock.System, perMinute, perMinute)
+}
+
+// Allow takes a token from the bucket of key. When it is empty, Allow returns false
+// and how long until the next token.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l.rate == 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration(math.Ceil((1 - b.tokens) / l.rate * float64(time.Second)))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops the buckets that have refilled, which are the same as new ones.
+func (l *Limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Middleware refuses the requests of a client IP beyond the limit.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if ok, retryAfter := l.Allow(ip); !ok {
+			logging.FromContext(r.Context()).Warn("request throttled", "limiter", l.name, "ip", ip)
+			l.Reject(w, retryAfter)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UserMiddleware authenticates the requests and refuses the requests of a user beyond
+// the limit. A request that fails to authenticate gets 401 Unauthorized with a Basic
+// challenge; the handler gets the identity with wallet.FromContext.
+func (l *Limiter) UserMiddleware(authenticate func(*http.Request) (*wallet.Identity, error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="auction"`)
+			w.WriteHeader(http.StatusUnauthorized) //401
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if ok, retryAfter := l.Allow(user.Label); !ok {
+			logging.FromContext(r.Context()).Warn("request throttled", "limiter", l.name, "user", user.Label)
+			l.Reject(w, retryAfter)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(wallet.NewContext(r.Context(), user)))
+	})
+}
+
+// Reject answers 429 Too Many Requests, with the seconds to wait in Retry-After.
+func (l *Limiter) Reject(w http.ResponseWriter, retryAfter time.Duration) {
+	throttled.Inc(l.name)
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests) //429
+	w.Write([]byte(fmt.Sprintf("too many requests, retry in %ds", seconds)))
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"assetTransfer/auction-application/clock"
+	"assetTransfer/auction-application/wallet"
+)
+
+func TestAllowRefillsAtTheRate(t *testing.T) {
+	clk := clock.NewFake(time.Date(2022, 11, 6, 16, 0, 0, 0, time.UTC))
+	limiter := New("user", clk, 60, 2)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.Allow("User1"); !ok {
+			t.Fatalf("request %d of the burst was refused", i)
+		}
+	}
+	ok, retryAfter := limiter.Allow("User1")
+	if ok || retryAfter != time.Second {
+		t.Fatalf("expected a refusal for 1s, got %v %v", ok, retryAfter)
+	}
+	if ok, _ = limiter.Allow("User2"); !ok {
+		t.Error("another key was refused")
+	}
+
+	clk.Advance(time.Second)
+	if ok, _ = limiter.Allow("User1"); !ok {
+		t.Error("the request was refused after the refill")
+	}
+}
+
+func TestMiddlewareAnswersTooManyRequests(t *testing.T) {
+	clk := clock.NewFake(time.Date(2022, 11, 6, 16, 0, 0, 0, time.UTC))
+	limiter := New("ip", clk, 1, 1)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	codes := []int{}
+	for i := 0; i < 2; i++ {
+		request := httptest.NewRequest(http.MethodPost, "/createToken", nil)
+		// the port differs between the connections of a client
+		request.RemoteAddr = fmt.Sprintf("192.0.2.1:%d", 50000+i)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		codes = append(codes, recorder.Code)
+		if i == 1 && recorder.Header().Get("Retry-After") != "60" {
+			t.Errorf("expected Retry-After 60, got %q", recorder.Header().Get("Retry-After"))
+		}
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("expected the second request from the same IP to be refused, got %v", codes)
+	}
+}
+
+func TestUserMiddlewareAuthenticatesAndLimitsTheUser(t *testing.T) {
+	clk := clock.NewFake(time.Date(2022, 11, 6, 16, 0, 0, 0, time.UTC))
+	limiter := New("user", clk, 1, 1)
+	authenticate := func(r *http.Request) (*wallet.Identity, error) {
+		label, _, ok := r.BasicAuth()
+		if !ok {
+			return nil, errors.New("unauthorized")
+		}
+		return &wallet.Identity{Label: label}, nil
+	}
+	var users []string
+	handler := limiter.UserMiddleware(authenticate, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		users = append(users, wallet.FromContext(r.Context()).Label)
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/bidOnToken", nil))
+	if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("expected 401 with a challenge, got %d %q", recorder.Code, recorder.Header().Get("WWW-Authenticate"))
+	}
+
+	codes := []int{}
+	for _, label := range []string{"User1", "User1", "User2"} {
+		request := httptest.NewRequest(http.MethodPost, "/bidOnToken", nil)
+		request.SetBasicAuth(label, "key")
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		codes = append(codes, recorder.Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests || codes[2] != http.StatusOK {
+		t.Errorf("expected only the second request of User1 to be refused, got %v", codes)
+	}
+	if len(users) != 2 || users[0] != "User1" || users[1] != "User2" {
+		t.Errorf("expected the handler to get the authenticated users, got %v", users)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 市場の分析レポート
+// go run report.go -group category|hour|cell [-category solar] [-from 2022-08-01T06:00:00+09:00] [-to ...] [-out report.csv]
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"assetTransfer/auction-application/connection"
+)
+
+const layout = "2006-01-02T15:04:05+09:00"
+
+type MarketStats struct {
+	Key                  string  `json:"Key"`
+	Sold                 int     `json:"Sold"`
+	Unsold               int     `json:"Unsold"`
+	Open                 int     `json:"Open"`
+	Volume               float64 `json:"Volume"`
+	AverageClearingPrice float64 `json:"Average Clearing Price"`
+	UnsoldRate           float64 `json:"Unsold Rate"`
+}
+
+func main() {
+	now := time.Now()
+	groupBy := flag.String("group", "category", "group the tokens by category, hour or cell")
+	category := flag.String("category", "", "only report the tokens of this small category")
+	from := flag.String("from", now.Add(-24*time.Hour).Format(layout), "start of the generated time range")
+	to := flag.String("to", now.Format(layout), "end of the generated time range (exclusive)")
+	outPath := flag.String("out", "", "CSV file for the report (default stdout)")
+	label := flag.String("identity", "User1", "wallet identity")
+	flag.Parse()
+
+	clientConnection, err := connection.Org1Peer.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer clientConnection.Close()
+
+	gateway, err := connection.ConnectLabel(clientConnection, *label)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer gateway.Close()
+
+	contract := gateway.GetNetwork(connection.ChannelName).GetContract(connection.ChaincodeName)
+
+	var evaluateResult []byte
+	if *category == "" {
+		fmt.Fprintf(os.Stderr, "Evaluate Transaction: QueryMarketStats %s %s %s\n", *groupBy, *from, *to)
+		evaluateResult, err = contract.EvaluateTransaction("QueryMarketStats", *groupBy, *from, *to)
+	} else {
+		fmt.Fprintf(os.Stderr, "Evaluate Transaction: QueryMarketStatsForCategory %s %s %s %s\n", *category, *groupBy, *from, *to)
+		evaluateResult, err = contract.EvaluateTransaction("QueryMarketStatsForCategory", *category, *groupBy, *from, *to)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var stats []MarketStats
+	if len(evaluateResult) > 0 {
+		if err = json.Unmarshal(evaluateResult, &stats); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	out := os.Stdout
+	if *outPath != "" {
+		file, err := os.Create(*outPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		out = file
+	}
+	if err = writeReport(out, *groupBy, stats); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func writeReport(out *os.File, groupBy string, stats []MarketStats) error {
+	writer := csv.NewWriter(out)
+	err := writer.Write([]string{groupBy, "sold", "unsold", "open", "volume_kwh", "avg_clearing_price", "unsold_rate"})
+	if err != nil {
+		return err
+	}
+	for _, s := range stats {
+		err = writer.Write([]string{
+			s.Key,
+			strconv.Itoa(s.Sold),
+			strconv.Itoa(s.Unsold),
+			strconv.Itoa(s.Open),
+			strconv.FormatFloat(s.Volume, 'f', 3, 64),
+			strconv.FormatFloat(s.AverageClearingPrice, 'f', 6, 64),
+			strconv.FormatFloat(s.UnsoldRate, 'f', 4, 64),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
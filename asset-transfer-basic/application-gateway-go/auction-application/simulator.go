@@ -0,0 +1,185 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 市場シミュレーション
+// go run simulator*.go -config simulation.json -out metrics.csv   (チェーンコードをメモリ上で実行)
+// go run simulator*.go -gateway -identity User1   (実際のネットワークに対して実行)
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"assetTransfer/auction-application/clock"
+	"assetTransfer/auction-application/connection"
+	"assetTransfer/auction-application/fabrictest"
+	"assetTransfer/auction-application/txsubmit"
+)
+
+const (
+	producerMSPID = "Org1MSP"
+	consumerMSPID = "Org2MSP"
+)
+
+// Market gives the simulated producers and consumers the contracts they trade with.
+type Market interface {
+	// Contract returns the contract of a producer of Org1MSP or a consumer of Org2MSP.
+	Contract(mspID string, user string) (txsubmit.Contract, error)
+	// SetTime is called at each simulated minute.
+	SetTime(now time.Time)
+}
+
+// ledgerMarket runs the auction chaincode in memory, with the transactions timestamped
+// by the simulated time.
+type ledgerMarket struct {
+	ledger *fabrictest.Ledger
+	clock  *clock.Fake
+}
+
+func newLedgerMarket(start time.Time) (*ledgerMarket, error) {
+	clk := clock.NewFake(start)
+	ledger, err := fabrictest.NewLedger(clk)
+	if err != nil {
+		return nil, err
+	}
+	operator, err := ledger.Contract(producerMSPID, "Admin", nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = operator.Submit("InitLedger", txsubmit.DefaultOptions); err != nil {
+		return nil, fmt.Errorf("InitLedger: %w", err)
+	}
+	return &ledgerMarket{ledger: ledger, clock: clk}, nil
+}
+
+func (m *ledgerMarket) Contract(mspID string, user string) (txsubmit.Contract, error) {
+	return m.ledger.Contract(mspID, user, nil)
+}
+
+func (m *ledgerMarket) SetTime(now time.Time) {
+	m.clock.Set(now)
+}
+
+// gatewayMarket submits the transactions of every agent with one wallet identity.
+// The simulated time is only passed in the transaction arguments.
+type gatewayMarket struct {
+	contract txsubmit.Contract
+}
+
+func (m gatewayMarket) Contract(mspID string, user string) (txsubmit.Contract, error) {
+	return m.contract, nil
+}
+
+func (m gatewayMarket) SetTime(now time.Time) {}
+
+type SimulationConfig struct {
+	Seed              int64                  `json:"seed"`
+	Start             time.Time              `json:"start"`
+	DurationHours     int                    `json:"durationHours"`
+	CenterLatitude    float64                `json:"centerLatitude"`
+	CenterLongitude   float64                `json:"centerLongitude"`
+	AreaRadiusKm      float64                `json:"areaRadiusKm"`
+	Producers         int                    `json:"producers"`
+	ProducerMix       map[string]float64     `json:"producerMix"`       // share of producers per small category
+	GenerationProfile map[string][24]float64 `json:"generationProfile"` // tokens per hour per producer, by hour of day
+	Consumers         int                    `json:"consumers"`
+	BatteryMin        float64                `json:"batteryMin"` // %
+	BatteryMax        float64                `json:"batteryMax"` // %
+	ChargeThreshold   float64                `json:"chargeThreshold"`
+	TokensPerRequest  int                    `json:"tokensPerRequest"`
+	BatteryPerToken   float64                `json:"batteryPerToken"` // % charged by one token
+	DrainPerHour      float64                `json:"drainPerHour"`    // % used per hour of driving
+}
+
+var defaultSimulationConfig = SimulationConfig{
+	Seed:            1,
+	Start:           time.Date(2022, 8, 1, 6, 0, 0, 0, time.Local),
+	DurationHours:   12,
+	CenterLatitude:  35.5552824466371,
+	CenterLongitude: 139.65527497388206,
+	AreaRadiusKm:    3,
+	Producers:       20,
+	ProducerMix:     map[string]float64{"solar": 0.6, "wind": 0.3, "thermal": 0.1},
+	GenerationProfile: map[string][24]float64{
+		"solar":   {0, 0, 0, 0, 0, 0.5, 1, 2, 3, 4, 5, 6, 6, 5, 4, 3, 2, 1, 0.5, 0, 0, 0, 0, 0},
+		"wind":    {2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2},
+		"thermal": {4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4},
+	},
+	Consumers:        50,
+	BatteryMin:       10,
+	BatteryMax:       90,
+	ChargeThreshold:  40,
+	TokensPerRequest: 3,
+	BatteryPerToken:  5,
+	DrainPerHour:     8,
+}
+
+func main() {
+	configPath := flag.String("config", "", "JSON file overriding the default simulation settings")
+	outPath := flag.String("out", "", "CSV file for the hourly metrics (default stdout)")
+	useGateway := flag.Bool("gateway", false, "run against the test network instead of the in-memory chaincode")
+	label := flag.String("identity", "User1", "wallet identity used with -gateway")
+	flag.Parse()
+
+	config := defaultSimulationConfig
+	if *configPath != "" {
+		configJSON, err := ioutil.ReadFile(*configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = json.Unmarshal(configJSON, &config); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	out := os.Stdout
+	if *outPath != "" {
+		file, err := os.Create(*outPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	var market Market
+	if *useGateway {
+		clientConnection, err := connection.Org1Peer.Dial()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer clientConnection.Close()
+
+		gateway, err := connection.ConnectLabel(clientConnection, *label)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer gateway.Close()
+
+		market = gatewayMarket{contract: txsubmit.NewContract(gateway.GetNetwork(connection.ChannelName).GetContract(connection.ChaincodeName))}
+	} else {
+		ledgerMarket, err := newLedgerMarket(config.Start)
+		if err != nil {
+			log.Fatal(err)
+		}
+		market = ledgerMarket
+	}
+
+	log.Println("============ simulation starts ============")
+	metrics, err := Simulate(market, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = metrics.WriteCSV(out); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("============ simulation ends ============")
+}
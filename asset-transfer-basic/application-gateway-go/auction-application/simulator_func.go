@@ -0,0 +1,470 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 市場シミュレーション
+// 発電者 (producer.go) と電気自動車 (consumer.go) の動作を模擬時間で再現する
+
+package main
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"assetTransfer/auction-application/geohash"
+	"assetTransfer/auction-application/txsubmit"
+)
+
+type Energy struct {
+	DocType          string    `json:"DocType"`
+	UnitPrice        float64   `json:"Unit Price"`
+	BidPrice         float64   `json:"Bid Price"`
+	GeneratedTime    time.Time `json:"Generated Time"`
+	AuctionStartTime time.Time `json:"Auction Start Time"`
+	BidTime          time.Time `json:"Bid Time"`
+	ID               string    `json:"ID"`
+	LargeCategory    string    `json:"LargeCategory"`
+	Latitude         float64   `json:"Latitude"`
+	Longitude        float64   `json:"Longitude"`
+	Owner            string    `json:"Owner"`
+	Producer         string    `json:"Producer"`
+	SmallCategory    string    `json:"SmallCategory"`
+	Status           string    `json:"Status"`
+}
+
+const (
+	earthRadius        = 6378137.0
+	pricePerMater      = 0.000001
+	kmPerBattery       = 0.05 // battery(%) * kmPerBattery = x km
+	auctionEndMax      = 6
+	auctionEndInterval = 5
+	kmPerDegree        = 111.32
+	// geohash cells of the search, about 39km x 20km, as in consumer.go
+	searchGeohashPrecision = 4
+)
+
+type producerAgent struct {
+	name          string
+	latitude      float64
+	longitude     float64
+	smallCategory string
+	contract      txsubmit.Contract
+}
+
+type consumerAgent struct {
+	name      string
+	latitude  float64
+	longitude float64
+	battery   float64
+	pending   []pendingBid
+	contract  txsubmit.Contract
+}
+
+type pendingBid struct {
+	id         string
+	resultTime time.Time
+	distance   float64
+}
+
+type auctionState struct {
+	id       string
+	producer *producerAgent
+	created  time.Time
+	count    int
+}
+
+// HourMetrics are the market figures of one simulated hour.
+type HourMetrics struct {
+	Hour       time.Time
+	Created    int
+	Sold       int
+	Unsold     int
+	PriceSum   float64
+	Bids       int
+	WonBids    int
+	DistanceKm float64
+}
+
+// Metrics are the hourly market figures of a simulation run.
+type Metrics struct {
+	Hours []*HourMetrics
+}
+
+func (m *Metrics) hour(now time.Time) *HourMetrics {
+	hour := now.Truncate(time.Hour)
+	if len(m.Hours) == 0 || !m.Hours[len(m.Hours)-1].Hour.Equal(hour) {
+		m.Hours = append(m.Hours, &HourMetrics{Hour: hour})
+	}
+	return m.Hours[len(m.Hours)-1]
+}
+
+// WriteCSV writes one row per simulated hour followed by a total row.
+func (m *Metrics) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"hour", "created", "sold", "unsold", "sell_through_rate",
+		"avg_clearing_price", "bids", "won_bids", "distance_km"})
+
+	total := HourMetrics{}
+	for _, hour := range m.Hours {
+		writer.Write(hour.record(hour.Hour.Format(time.RFC3339)))
+		total.Created += hour.Created
+		total.Sold += hour.Sold
+		total.Unsold += hour.Unsold
+		total.PriceSum += hour.PriceSum
+		total.Bids += hour.Bids
+		total.WonBids += hour.WonBids
+		total.DistanceKm += hour.DistanceKm
+	}
+	writer.Write(total.record("total"))
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func (h *HourMetrics) record(label string) []string {
+	sellThroughRate := 0.0
+	if h.Sold+h.Unsold > 0 {
+		sellThroughRate = float64(h.Sold) / float64(h.Sold+h.Unsold)
+	}
+	averagePrice := 0.0
+	if h.Sold > 0 {
+		averagePrice = h.PriceSum / float64(h.Sold)
+	}
+	return []string{
+		label,
+		strconv.Itoa(h.Created),
+		strconv.Itoa(h.Sold),
+		strconv.Itoa(h.Unsold),
+		strconv.FormatFloat(sellThroughRate, 'f', 4, 64),
+		strconv.FormatFloat(averagePrice, 'f', 6, 64),
+		strconv.Itoa(h.Bids),
+		strconv.Itoa(h.WonBids),
+		strconv.FormatFloat(h.DistanceKm, 'f', 3, 64),
+	}
+}
+
+// Simulate runs the market minute by minute. Producers mint tokens according to their
+// generation profile and close their auctions every 5 minutes like producer.go; consumers
+// drive, and bid on nearby tokens like consumer.go when their battery runs low. Each
+// agent trades with its own contract of market.
+func Simulate(market Market, config SimulationConfig) (*Metrics, error) {
+	rng := rand.New(rand.NewSource(config.Seed))
+	runID := strconv.FormatInt(time.Now().Unix(), 36)
+
+	producers := newProducers(rng, config)
+	for _, producer := range producers {
+		contract, err := market.Contract(producerMSPID, producer.name)
+		if err != nil {
+			return nil, err
+		}
+		producer.contract = contract
+	}
+	consumers := newConsumers(rng, config)
+	for _, consumer := range consumers {
+		contract, err := market.Contract(consumerMSPID, consumer.name)
+		if err != nil {
+			return nil, err
+		}
+		consumer.contract = contract
+	}
+	auctions := []*auctionState{}
+	metrics := &Metrics{}
+	sequence := 0
+
+	end := config.Start.Add(time.Hour * time.Duration(config.DurationHours))
+	for now := config.Start; now.Before(end); now = now.Add(time.Minute) {
+		market.SetTime(now)
+		hourMetrics := metrics.hour(now)
+
+		for _, producer := range producers {
+			rate := config.GenerationProfile[producer.smallCategory][now.Hour()]
+			if rng.Float64() >= rate/60 {
+				continue
+			}
+			sequence++
+			id := fmt.Sprintf("sim%s-%s-%d", runID, producer.name, sequence)
+			_, err := producer.contract.Submit("CreateToken", txsubmit.DefaultOptions, id,
+				strconv.FormatFloat(producer.latitude, 'f', -1, 64), strconv.FormatFloat(producer.longitude, 'f', -1, 64),
+				producer.name, largeCategory(producer.smallCategory), producer.smallCategory, now.Format(time.RFC3339))
+			if err != nil {
+				return nil, fmt.Errorf("CreateToken %s: %w", id, err)
+			}
+			auctions = append(auctions, &auctionState{id: id, producer: producer, created: now})
+			hourMetrics.Created++
+		}
+
+		active := auctions[:0]
+		for _, auction := range auctions {
+			closed, err := closeAuctionRound(auction, now, hourMetrics)
+			if err != nil {
+				return nil, err
+			}
+			if !closed {
+				active = append(active, auction)
+			}
+		}
+		auctions = active
+
+		for _, consumer := range consumers {
+			consumer.battery = math.Max(0, consumer.battery-config.DrainPerHour/60)
+			if err := resolveBids(consumer, now, config, hourMetrics); err != nil {
+				return nil, err
+			}
+			if consumer.battery < config.ChargeThreshold && len(consumer.pending) == 0 {
+				if err := buy(consumer, now, config, hourMetrics); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return metrics, nil
+}
+
+func newProducers(rng *rand.Rand, config SimulationConfig) []*producerAgent {
+	categories := make([]string, 0, len(config.ProducerMix))
+	for category := range config.ProducerMix {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	producers := []*producerAgent{}
+	for i := 0; i < config.Producers; i++ {
+		latitude, longitude := randomLocation(rng, config)
+		producers = append(producers, &producerAgent{
+			name:          fmt.Sprintf("P%d", i+1),
+			latitude:      latitude,
+			longitude:     longitude,
+			smallCategory: pickCategory(rng, categories, config.ProducerMix),
+		})
+	}
+	return producers
+}
+
+func newConsumers(rng *rand.Rand, config SimulationConfig) []*consumerAgent {
+	consumers := []*consumerAgent{}
+	for i := 0; i < config.Consumers; i++ {
+		latitude, longitude := randomLocation(rng, config)
+		consumers = append(consumers, &consumerAgent{
+			name:      fmt.Sprintf("EV%d", i+1),
+			latitude:  latitude,
+			longitude: longitude,
+			battery:   config.BatteryMin + rng.Float64()*(config.BatteryMax-config.BatteryMin),
+		})
+	}
+	return consumers
+}
+
+func pickCategory(rng *rand.Rand, categories []string, mix map[string]float64) string {
+	total := 0.0
+	for _, category := range categories {
+		total += mix[category]
+	}
+	r := rng.Float64() * total
+	for _, category := range categories {
+		r -= mix[category]
+		if r < 0 {
+			return category
+		}
+	}
+	return categories[len(categories)-1]
+}
+
+func largeCategory(smallCategory string) string {
+	if smallCategory == "solar" || smallCategory == "wind" {
+		return "green"
+	}
+	return "depletable"
+}
+
+// randomLocation returns a point uniformly distributed within the simulation area.
+func randomLocation(rng *rand.Rand, config SimulationConfig) (float64, float64) {
+	r := config.AreaRadiusKm * math.Sqrt(rng.Float64())
+	theta := rng.Float64() * 2 * math.Pi
+	latitude := config.CenterLatitude + r*math.Cos(theta)/kmPerDegree
+	longitude := config.CenterLongitude + r*math.Sin(theta)/(kmPerDegree*math.Cos(config.CenterLatitude*math.Pi/180))
+	return latitude, longitude
+}
+
+// closeAuctionRound calls AuctionEnd every 5 minutes after the token was created,
+// discounting it before the last round, and reports whether the auction is over, as
+// Auction of producer.go does.
+func closeAuctionRound(auction *auctionState, now time.Time, hourMetrics *HourMetrics) (bool, error) {
+	producer := auction.producer
+	roundEnd := auction.created.Add(time.Minute * time.Duration((auction.count+1)*auctionEndInterval))
+	if now.Before(roundEnd) {
+		return false, nil
+	}
+	auction.count++
+
+	_, err := producer.contract.Submit("AuctionEnd", txsubmit.DefaultOptions, auction.id, producer.name, roundEnd.Format(time.RFC3339))
+	if err != nil {
+		return false, fmt.Errorf("AuctionEnd %s: %w", auction.id, err)
+	}
+	energy, err := readToken(producer.contract, auction.id)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case energy.Status == "sold":
+		hourMetrics.Sold++
+		hourMetrics.PriceSum += energy.BidPrice
+		return true, nil
+	case energy.Status != "generated" || auction.count >= auctionEndMax:
+		hourMetrics.Unsold++
+		return true, nil
+	case auction.count == auctionEndMax-1:
+		// discount the auction between 25min and 30min
+		if _, err := producer.contract.Submit("DiscountUnitPrice", txsubmit.DefaultOptions, auction.id); err != nil {
+			return false, fmt.Errorf("DiscountUnitPrice %s: %w", auction.id, err)
+		}
+	}
+	return false, nil
+}
+
+// buy searches the tokens within the battery-derived radius and bids on the most
+// expensive ones first, as Buy of consumer.go does: the search only reveals coarse
+// geohash cells, and the location of the bids is passed in the transient map.
+func buy(consumer *consumerAgent, now time.Time, config SimulationConfig, hourMetrics *HourMetrics) error {
+	searchRange := (100 - consumer.battery) * kmPerBattery * 1000
+	latitudeDifference := searchRange / 1000 / kmPerDegree
+	longitudeDifference := latitudeDifference / math.Cos(consumer.latitude*math.Pi/180)
+
+	energies := []Energy{}
+	cells := geohash.Cover(consumer.latitude-latitudeDifference, consumer.latitude+latitudeDifference,
+		consumer.longitude-longitudeDifference, consumer.longitude+longitudeDifference, searchGeohashPrecision)
+	for _, cell := range cells {
+		evaluateResult, err := consumer.contract.Evaluate("QueryByGeohash", "generated", cell)
+		if err != nil {
+			return fmt.Errorf("QueryByGeohash %s: %w", cell, err)
+		}
+		if len(evaluateResult) == 0 {
+			continue
+		}
+		var cellEnergies []Energy
+		if err = json.Unmarshal(evaluateResult, &cellEnergies); err != nil {
+			return err
+		}
+		energies = append(energies, cellEnergies...)
+	}
+
+	auctionStartTimeCompare := now.Add(time.Minute * -auctionEndInterval)
+	type candidate struct {
+		energy   Energy
+		distance float64
+	}
+	candidates := []candidate{}
+	for _, energy := range energies {
+		d := distance(consumer.latitude, consumer.longitude, energy.Latitude, energy.Longitude)
+		if energy.Owner != consumer.name && d <= searchRange && !auctionStartTimeCompare.After(energy.AuctionStartTime) {
+			energy.BidPrice = energy.UnitPrice + d*pricePerMater
+			candidates = append(candidates, candidate{energy: energy, distance: d})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].energy.BidPrice > candidates[j].energy.BidPrice
+	})
+
+	for _, c := range candidates {
+		if len(consumer.pending) >= config.TokensPerRequest {
+			break
+		}
+		location, err := locationTransient(consumer)
+		if err != nil {
+			return err
+		}
+		options := txsubmit.DefaultOptions
+		options.Transient = location
+		hourMetrics.Bids++
+		result, err := consumer.contract.Submit("BidOnTokenPrivate", options, c.energy.ID, consumer.name,
+			strconv.FormatFloat(c.energy.BidPrice, 'f', -1, 64), now.Format(time.RFC3339))
+		if err != nil {
+			return fmt.Errorf("BidOnTokenPrivate %s: %w", c.energy.ID, err)
+		}
+		if string(result.Payload) == "your bid was successful" {
+			consumer.pending = append(consumer.pending, pendingBid{
+				id:         c.energy.ID,
+				resultTime: c.energy.AuctionStartTime.Add(time.Minute * auctionEndInterval),
+				distance:   c.distance,
+			})
+		}
+	}
+	return nil
+}
+
+// locationTransient returns the transient data carrying the location of the consumer,
+// with a new salt for each bid.
+func locationTransient(consumer *consumerAgent) (map[string][]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := cryptorand.Read(salt); err != nil {
+		return nil, err
+	}
+	locationJSON, err := json.Marshal(map[string]interface{}{
+		"Latitude":  consumer.latitude,
+		"Longitude": consumer.longitude,
+		"Salt":      hex.EncodeToString(salt),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{"location": locationJSON}, nil
+}
+
+// resolveBids checks the bids whose auction round has closed; won tokens charge the
+// battery and add the distance to the token to the distance travelled.
+func resolveBids(consumer *consumerAgent, now time.Time, config SimulationConfig, hourMetrics *HourMetrics) error {
+	pending := consumer.pending[:0]
+	for _, bid := range consumer.pending {
+		if now.Before(bid.resultTime) {
+			pending = append(pending, bid)
+			continue
+		}
+		energy, err := readToken(consumer.contract, bid.id)
+		if err != nil {
+			return err
+		}
+		if energy.Owner == consumer.name {
+			hourMetrics.WonBids++
+			hourMetrics.DistanceKm += bid.distance / 1000
+			consumer.battery = math.Min(100, consumer.battery+config.BatteryPerToken)
+		}
+	}
+	consumer.pending = pending
+	return nil
+}
+
+func readToken(contract txsubmit.Contract, energyId string) (Energy, error) {
+	var energy Energy
+	evaluateResult, err := contract.Evaluate("ReadToken", energyId)
+	if err != nil {
+		return energy, fmt.Errorf("ReadToken %s: %w", energyId, err)
+	}
+	err = json.Unmarshal(evaluateResult, &energy)
+	return energy, err
+}
+
+func distance(lat1 float64, lng1 float64, lat2 float64, lng2 float64) float64 {
+	rlat1 := lat1 * math.Pi / 180
+	rlng1 := lng1 * math.Pi / 180
+	rlat2 := lat2 * math.Pi / 180
+	rlng2 := lng2 * math.Pi / 180
+
+	angle :=
+		math.Sin(rlat1)*math.Sin(rlat2) +
+			math.Cos(rlat1)*math.Cos(rlat2)*
+				math.Cos(rlng1-rlng2)
+
+	// rounding can push the cosine of identical points slightly above 1
+	return earthRadius * math.Acos(math.Min(1, angle))
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 市場シミュレーションのテスト
+// go test simulator*.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSimulateTradesOnTheChaincode(t *testing.T) {
+	config := defaultSimulationConfig
+	config.Start = time.Date(2022, 8, 1, 10, 0, 0, 0, time.Local)
+	config.DurationHours = 2
+	config.Producers = 5
+	config.Consumers = 10
+	config.BatteryMax = 30 // every consumer is looking for a charge
+
+	market, err := newLedgerMarket(config.Start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := Simulate(market, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total := HourMetrics{}
+	for _, hour := range metrics.Hours {
+		total.Created += hour.Created
+		total.Sold += hour.Sold
+		total.Unsold += hour.Unsold
+		total.Bids += hour.Bids
+		total.WonBids += hour.WonBids
+	}
+	if total.Created == 0 || total.Bids == 0 || total.Sold == 0 {
+		t.Fatalf("expected tokens to be created, bid on and sold, got %+v", total)
+	}
+	if total.Sold+total.Unsold > total.Created || total.WonBids > total.Sold {
+		t.Errorf("inconsistent metrics %+v", total)
+	}
+
+	// the sold tokens were closed by the chaincode, and only the geohash of the bidders is public
+	consumer, err := market.Contract(consumerMSPID, "EV1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := consumer.Evaluate("QueryByStatus", "sold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sold []map[string]interface{}
+	if err = json.Unmarshal(result, &sold); err != nil {
+		t.Fatal(err)
+	}
+	if len(sold) != total.Sold {
+		t.Errorf("expected %d sold tokens on the ledger, got %d", total.Sold, len(sold))
+	}
+	for _, token := range sold {
+		if bidderGeohash, _ := token["Bidder Geohash"].(string); bidderGeohash == "" || token["Owner"] == token["Producer"] {
+			t.Errorf("unexpected sold token %v", token)
+		}
+	}
+
+	var out bytes.Buffer
+	if err = metrics.WriteCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(metrics.Hours)+2 || records[len(records)-1][0] != "total" {
+		t.Errorf("unexpected CSV %v", records)
+	}
+}
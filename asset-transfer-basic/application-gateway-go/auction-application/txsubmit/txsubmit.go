@@ -0,0 +1,235 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package txsubmit submits transactions step by step (endorse, submit, commit status)
+// so that the auction applications know where a transaction failed, and retries
+// transactions that fail validation because of a read conflict with a concurrent
+// transaction, e.g. two consumers bidding on the same token in the same block.
+package txsubmit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"assetTransfer/auction-application/logging"
+	"assetTransfer/auction-application/metrics"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Stage is the step of the transaction flow that failed.
+type Stage string
+
+const (
+	StageEndorse      Stage = "endorse"
+	StageSubmit       Stage = "submit"
+	StageCommitStatus Stage = "commitStatus"
+	StageCommit       Stage = "commit"
+)
+
+// Options controls the retry of conflicting transactions.
+type Options struct {
+	MaxAttempts int               // total attempts including the first one
+	BaseDelay   time.Duration     // delay before the first retry, doubled for each further retry
+	MaxJitter   time.Duration     // random delay added to each retry so that competing clients spread out
+	Transient   map[string][]byte // private data passed to the chaincode, not recorded on the ledger
+	Logger      *logging.Logger   // logs the transaction IDs; the default logger when nil
+}
+
+// DefaultOptions is used by Submit.
+var DefaultOptions = Options{
+	MaxAttempts: 5,
+	BaseDelay:   200 * time.Millisecond,
+	MaxJitter:   300 * time.Millisecond,
+}
+
+// Contract evaluates and submits the transactions of the auction chaincode. NewContract
+// returns the implementation on a Fabric Gateway contract; the fabrictest package
+// provides an in-memory one for tests.
+type Contract interface {
+	// Evaluate runs a transaction function on a peer without updating the ledger.
+	Evaluate(name string, args ...string) ([]byte, error)
+	// Submit endorses, submits and waits for the commit of a transaction.
+	Submit(name string, options Options, args ...string) (*Result, error)
+}
+
+type gatewayContract struct {
+	contract *client.Contract
+}
+
+// NewContract returns the Contract of a Fabric Gateway contract.
+func NewContract(contract *client.Contract) Contract {
+	return &gatewayContract{contract: contract}
+}
+
+func (c *gatewayContract) Evaluate(name string, args ...string) ([]byte, error) {
+	return c.contract.EvaluateTransaction(name, args...)
+}
+
+func (c *gatewayContract) Submit(name string, options Options, args ...string) (*Result, error) {
+	return SubmitWithOptions(c.contract, options, name, args...)
+}
+
+// Result describes a committed transaction, or the last attempt of a failed one.
+type Result struct {
+	TransactionID string
+	Payload       []byte
+	Code          peer.TxValidationCode
+	BlockNumber   uint64
+	Attempts      int
+}
+
+// Error is returned when a transaction could not be committed successfully.
+type Error struct {
+	Stage         Stage
+	TransactionID string
+	Code          peer.TxValidationCode // only meaningful for StageCommit
+	Attempts      int
+	Err           error
+}
+
+func (e *Error) Error() string {
+	if e.Stage == StageCommit {
+		return fmt.Sprintf("transaction %s failed to commit with status %s after %d attempt(s)", e.TransactionID, e.Code, e.Attempts)
+	}
+	return fmt.Sprintf("%s error for transaction %s after %d attempt(s) (gRPC status %v): %s",
+		e.Stage, e.TransactionID, e.Attempts, status.Code(e.Err), e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the commit status could not be obtained in time. The
+// transaction may still commit later.
+func (e *Error) Timeout() bool {
+	return e.Stage == StageCommitStatus && errors.Is(e.Err, context.DeadlineExceeded)
+}
+
+// Conflict reports whether the transaction was invalidated by a concurrent update of
+// the keys it read, which is worth retrying with a fresh endorsement.
+func (e *Error) Conflict() bool {
+	return e.Stage == StageCommit &&
+		(e.Code == peer.TxValidationCode_MVCC_READ_CONFLICT || e.Code == peer.TxValidationCode_PHANTOM_READ_CONFLICT)
+}
+
+// Details returns the messages of the peers and orderers embedded in the gRPC status of err.
+func Details(err error) []string {
+	var details []string
+	for _, detail := range status.Convert(err).Details() {
+		switch detail := detail.(type) {
+		case *gateway.ErrorDetail:
+			details = append(details, fmt.Sprintf("endpoint: %s, mspId: %s, message: %s", detail.Address, detail.MspId, detail.Message))
+		}
+	}
+	return details
+}
+
+var (
+	stageSeconds = metrics.NewHistogram("auction_transaction_stage_seconds",
+		"Latency of the endorse, submit and commitStatus steps of the submitted transactions.",
+		metrics.LatencyBuckets, "transaction", "stage")
+	transactionsTotal = metrics.NewCounter("auction_transactions_total",
+		"Submitted transactions by the step that failed, or committed.", "transaction", "result")
+)
+
+var (
+	jitterMutex  sync.Mutex
+	jitterSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Submit submits a transaction with DefaultOptions.
+func Submit(contract *client.Contract, name string, args ...string) (*Result, error) {
+	return SubmitWithOptions(contract, DefaultOptions, name, args...)
+}
+
+// SubmitWithOptions endorses, submits and waits for the commit of a transaction. When the
+// transaction fails validation with a read conflict it is endorsed again, so the chaincode
+// re-evaluates it against the current world state, up to options.MaxAttempts times.
+func SubmitWithOptions(contract *client.Contract, options Options, name string, args ...string) (*Result, error) {
+	proposalOptions := []client.ProposalOption{client.WithArguments(args...)}
+	if options.Transient != nil {
+		proposalOptions = append(proposalOptions, client.WithTransient(options.Transient))
+	}
+	logger := options.Logger
+	if logger == nil {
+		logger = logging.Default()
+	}
+
+	for attempt := 1; ; attempt++ {
+		result, err := submitOnce(contract, name, proposalOptions)
+		result.Attempts = attempt
+		if err == nil {
+			transactionsTotal.Inc(name, "committed")
+			logger.Info("transaction committed", "transaction", name, "tx_id", result.TransactionID,
+				"block", result.BlockNumber, "attempt", attempt)
+			return result, nil
+		}
+
+		err.Attempts = attempt
+		transactionsTotal.Inc(name, string(err.Stage))
+		if !err.Conflict() || attempt >= options.MaxAttempts {
+			logger.Error("transaction failed", "transaction", name, "tx_id", err.TransactionID,
+				"stage", err.Stage, "attempt", attempt, "error", err)
+			return result, err
+		}
+
+		delay := options.BaseDelay << (attempt - 1)
+		if options.MaxJitter > 0 {
+			jitterMutex.Lock()
+			delay += time.Duration(jitterSource.Int63n(int64(options.MaxJitter)))
+			jitterMutex.Unlock()
+		}
+		logger.Warn("transaction conflict, retrying", "transaction", name, "tx_id", err.TransactionID,
+			"code", err.Code, "attempt", attempt, "delay_ms", delay.Milliseconds())
+		time.Sleep(delay)
+	}
+}
+
+func submitOnce(contract *client.Contract, name string, proposalOptions []client.ProposalOption) (*Result, *Error) {
+	result := &Result{}
+
+	proposal, err := contract.NewProposal(name, proposalOptions...)
+	if err != nil {
+		return result, &Error{Stage: StageEndorse, Err: err}
+	}
+	result.TransactionID = proposal.TransactionID()
+
+	start := time.Now()
+	transaction, err := proposal.Endorse()
+	stageSeconds.ObserveSince(start, name, string(StageEndorse))
+	if err != nil {
+		return result, &Error{Stage: StageEndorse, TransactionID: result.TransactionID, Err: err}
+	}
+	result.Payload = transaction.Result()
+
+	start = time.Now()
+	commit, err := transaction.Submit()
+	stageSeconds.ObserveSince(start, name, string(StageSubmit))
+	if err != nil {
+		return result, &Error{Stage: StageSubmit, TransactionID: result.TransactionID, Err: err}
+	}
+
+	start = time.Now()
+	commitStatus, err := commit.Status()
+	stageSeconds.ObserveSince(start, name, string(StageCommitStatus))
+	if err != nil {
+		return result, &Error{Stage: StageCommitStatus, TransactionID: result.TransactionID, Err: err}
+	}
+	result.Code = commitStatus.Code
+	result.BlockNumber = commitStatus.BlockNumber
+
+	if !commitStatus.Successful {
+		return result, &Error{Stage: StageCommit, TransactionID: result.TransactionID, Code: commitStatus.Code}
+	}
+	return result, nil
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package txsubmit
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeGateway endorses every proposal with the result "ok" and answers the commit
+// status of the n-th submitted transaction with codes[n], VALID after the last one.
+type fakeGateway struct {
+	gateway.UnimplementedGatewayServer
+
+	mu          sync.Mutex
+	codes       []peer.TxValidationCode
+	endorseErr  error
+	endorsed    []string // transaction IDs
+	submitted   int
+	transientOK bool
+}
+
+func marshal(m proto.Message) []byte {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func (g *fakeGateway) Endorse(ctx context.Context, request *gateway.EndorseRequest) (*gateway.EndorseResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.endorseErr != nil {
+		return nil, g.endorseErr
+	}
+	g.endorsed = append(g.endorsed, request.GetTransactionId())
+
+	proposalPayload := &peer.ChaincodeProposalPayload{}
+	proposal := &peer.Proposal{}
+	if err := proto.Unmarshal(request.GetProposedTransaction().GetProposalBytes(), proposal); err == nil {
+		if err = proto.Unmarshal(proposal.GetPayload(), proposalPayload); err == nil {
+			g.transientOK = g.transientOK || len(proposalPayload.GetTransientMap()["location"]) > 0
+		}
+	}
+
+	responsePayload := marshal(&peer.ProposalResponsePayload{
+		Extension: marshal(&peer.ChaincodeAction{Response: &peer.Response{Status: 200, Payload: []byte("ok")}}),
+	})
+	actionPayload := marshal(&peer.ChaincodeActionPayload{
+		Action: &peer.ChaincodeEndorsedAction{ProposalResponsePayload: responsePayload},
+	})
+	payload := marshal(&common.Payload{
+		Header: &common.Header{ChannelHeader: marshal(&common.ChannelHeader{
+			Type:      int32(common.HeaderType_ENDORSER_TRANSACTION),
+			ChannelId: request.GetChannelId(),
+			TxId:      request.GetTransactionId(),
+		})},
+		Data: marshal(&peer.Transaction{Actions: []*peer.TransactionAction{{Payload: actionPayload}}}),
+	})
+	return &gateway.EndorseResponse{PreparedTransaction: &common.Envelope{Payload: payload}}, nil
+}
+
+func (g *fakeGateway) Submit(ctx context.Context, request *gateway.SubmitRequest) (*gateway.SubmitResponse, error) {
+	return &gateway.SubmitResponse{}, nil
+}
+
+func (g *fakeGateway) CommitStatus(ctx context.Context, request *gateway.SignedCommitStatusRequest) (*gateway.CommitStatusResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	code := peer.TxValidationCode_VALID
+	if g.submitted < len(g.codes) {
+		code = g.codes[g.submitted]
+	}
+	g.submitted++
+	return &gateway.CommitStatusResponse{Result: code, BlockNumber: uint64(g.submitted)}, nil
+}
+
+// newContract connects a Gateway client to g through an in-memory listener.
+func newContract(t *testing.T, g *fakeGateway) *client.Contract {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	gateway.RegisterGatewayServer(server, g)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	connection, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { connection.Close() })
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "User1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificateDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(certificateDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := identity.NewX509Identity("Org1MSP", certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign, err := identity.NewPrivateKeySign(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gw, err := client.Connect(id, client.WithSign(sign), client.WithClientConnection(connection),
+		client.WithEvaluateTimeout(time.Second), client.WithEndorseTimeout(time.Second),
+		client.WithSubmitTimeout(time.Second), client.WithCommitStatusTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { gw.Close() })
+	return gw.GetNetwork("mychannel").GetContract("basic")
+}
+
+var fastRetries = Options{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+func TestSubmitRetriesReadConflictsWithANewEndorsement(t *testing.T) {
+	g := &fakeGateway{codes: []peer.TxValidationCode{peer.TxValidationCode_MVCC_READ_CONFLICT}}
+	contract := newContract(t, g)
+
+	options := fastRetries
+	options.Transient = map[string][]byte{"location": []byte(`{"Latitude":35.5}`)}
+	result, err := SubmitWithOptions(contract, options, "BidOnTokenPrivate", "energy1", "User2", "0.03", "2022-11-06T16:00:00+09:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Attempts != 2 || string(result.Payload) != "ok" || result.Code != peer.TxValidationCode_VALID || result.BlockNumber != 2 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if len(g.endorsed) != 2 || g.endorsed[0] == g.endorsed[1] || result.TransactionID != g.endorsed[1] {
+		t.Errorf("expected a second endorsement with a new transaction ID, got %v and %s", g.endorsed, result.TransactionID)
+	}
+	if !g.transientOK {
+		t.Error("the transient data was not passed to the endorsement")
+	}
+}
+
+func TestSubmitStopsAtTheMaxAttemptsAndOnOtherFailures(t *testing.T) {
+	conflict := peer.TxValidationCode_PHANTOM_READ_CONFLICT
+	g := &fakeGateway{codes: []peer.TxValidationCode{conflict, conflict, conflict, conflict}}
+	_, err := SubmitWithOptions(newContract(t, g), fastRetries, "AuctionEnd", "energy1")
+	var txErr *Error
+	if !errors.As(err, &txErr) || !txErr.Conflict() || txErr.Attempts != 3 || len(g.endorsed) != 3 {
+		t.Fatalf("expected a conflict after 3 attempts, got %v (%d endorsements)", err, len(g.endorsed))
+	}
+
+	// an invalid endorsement is not a conflict, so it is not retried
+	g = &fakeGateway{codes: []peer.TxValidationCode{peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE}}
+	_, err = SubmitWithOptions(newContract(t, g), fastRetries, "AuctionEnd", "energy1")
+	if !errors.As(err, &txErr) || txErr.Stage != StageCommit || txErr.Conflict() || txErr.Attempts != 1 {
+		t.Errorf("expected a commit failure without retry, got %v", err)
+	}
+
+	g = &fakeGateway{endorseErr: status.Error(codes.Aborted, "chaincode response 500, the energy energy1 does not exist")}
+	result, err := SubmitWithOptions(newContract(t, g), fastRetries, "AuctionEnd", "energy1")
+	if !errors.As(err, &txErr) || txErr.Stage != StageEndorse || txErr.Attempts != 1 || result.TransactionID == "" {
+		t.Errorf("expected an endorsement failure, got %v %+v", err, result)
+	}
+}
+
+func TestErrorTimeoutIsOnlyForTheCommitStatus(t *testing.T) {
+	timeout := &Error{Stage: StageCommitStatus, Err: context.DeadlineExceeded}
+	if !timeout.Timeout() {
+		t.Error("expected a commit status deadline to be a timeout")
+	}
+	if (&Error{Stage: StageEndorse, Err: context.DeadlineExceeded}).Timeout() {
+		t.Error("expected an endorsement deadline not to be a commit timeout")
+	}
+	if !errors.Is(timeout, context.DeadlineExceeded) {
+		t.Error("expected the error to unwrap to the gRPC error")
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// 太陽光の単価更新のテスト
+// go test unitprice_test.go operator.go operator_func.go operator_demand.go
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"assetTransfer/auction-application/clock"
+	"assetTransfer/auction-application/fabrictest"
+	"assetTransfer/auction-application/txsubmit"
+)
+
+var jst = time.FixedZone("JST", 9*60*60)
+
+func solarUnitPrice(t *testing.T, ledger *fabrictest.Ledger) float64 {
+	t.Helper()
+	var cost Energy
+	if err := json.Unmarshal(ledger.State("solar-power-cost"), &cost); err != nil {
+		t.Fatal(err)
+	}
+	return cost.UnitPrice
+}
+
+// waitForSolarUnitPrice waits for the update of the operator running in the background.
+func waitForSolarUnitPrice(t *testing.T, ledger *fabrictest.Ledger, expected float64) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for solarUnitPrice(t, ledger) != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the solar unit price %v, got %v", expected, solarUnitPrice(t, ledger))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUpdateSolorUnitPriceFollowsTheHourlyPrices(t *testing.T) {
+	clk := clock.NewFake(time.Date(2022, 11, 6, 16, 30, 0, 0, jst))
+	appClock = clk
+	defer func() { appClock = clock.System }()
+
+	ledger, err := fabrictest.NewLedger(clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract, err := ledger.Contract("Org1MSP", "User1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = contract.Submit("InitLedger", txsubmit.DefaultOptions); err != nil {
+		t.Fatal(err)
+	}
+	priceList := price()
+
+	go UpdateSolorUnitPrice(contract)
+
+	// the price of the current hour at once, then on each hour
+	waitForSolarUnitPrice(t, ledger, priceList[10][16])
+	clk.BlockUntil(1)
+	clk.Advance(30 * time.Minute)
+	waitForSolarUnitPrice(t, ledger, priceList[10][17])
+	for hour := 18; hour < 21; hour++ {
+		clk.BlockUntil(1)
+		clk.Advance(time.Hour)
+		waitForSolarUnitPrice(t, ledger, priceList[10][hour])
+	}
+}
+
+func TestDemandResponseSignalsMultiplyThePricesUntilTheyEnd(t *testing.T) {
+	clk := clock.NewFake(time.Date(2022, 11, 6, 16, 30, 0, 0, jst))
+	appClock = clk
+	defer func() { appClock = clock.System }()
+
+	ledger, err := fabrictest.NewLedger(clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	operator, err := ledger.Contract("Org1MSP", "Operator", map[string]string{"market.admin": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := ledger.Contract("Org2MSP", "User2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = operator.Submit("InitLedger", txsubmit.DefaultOptions); err != nil {
+		t.Fatal(err)
+	}
+	createToken := func(id string) Energy {
+		t.Helper()
+		_, err := operator.Submit("CreateToken", txsubmit.DefaultOptions, id, "35.5", "139.6", "User1", "green", "solar",
+			clk.Now().Format(time.RFC3339))
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := readToken(operator, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	bid := func(id string, price string) string {
+		t.Helper()
+		result, err := consumer.Submit("BidOnToken", txsubmit.DefaultOptions, id, "User2", price, clk.Now().Format(time.RFC3339))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(result.Payload)
+	}
+
+	before := createToken("before")
+	feed := filepath.Join(t.TempDir(), "feed.json")
+	signals := `[{"id": "peak", "category": "solar", "multiplier": 1.5, "end": "2022-11-06T17:00:00+09:00", "reason": "evening peak"},
+		{"id": "ended", "multiplier": 0.5, "end": "2022-11-06T16:00:00+09:00"}]`
+	if err = ioutil.WriteFile(feed, []byte(signals), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ingestDemandSignals(operator, feed, map[string]DemandSignal{})
+
+	// the bids on the tokens issued before the surge must cover it
+	if message := bid("before", "0.025"); message != "your bid price is below the demand-response price 0.03" {
+		t.Errorf("expected the bid to be below the demand-response price, got %q (unit price %v)", message, before.UnitPrice)
+	}
+	if message := bid("before", "0.031"); message != "your bid was successful" {
+		t.Errorf("expected a bid covering the surge, got %q", message)
+	}
+	if during := createToken("during"); math.Abs(during.UnitPrice-before.UnitPrice*1.5) > 1e-12 {
+		t.Errorf("expected the unit price of a token issued during the surge to be %v, got %v", before.UnitPrice*1.5, during.UnitPrice)
+	}
+
+	clk.Advance(30 * time.Minute)
+	submitResult, err := operator.Submit("SweepExpired", txsubmit.DefaultOptions, "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result SweepResult
+	if err = json.Unmarshal(submitResult.Payload, &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.PriceMultipliers) != 1 || result.PriceMultipliers[0] != "peak" {
+		t.Errorf("expected the sweep to delete the ended multiplier, got %+v", result)
+	}
+	if after := createToken("after"); after.UnitPrice != before.UnitPrice {
+		t.Errorf("expected the unit price %v after the surge, got %v", before.UnitPrice, after.UnitPrice)
+	}
+}
@@ -0,0 +1,82 @@
+//go:build pkcs11
+// +build pkcs11
+
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package wallet
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+// hsm keeps one factory per library and one signer per key, since an HSM signer holds
+// a logged-in session; the signers are safe for concurrent use.
+var hsm struct {
+	sync.Mutex
+	factory *identity.HSMSignerFactory
+	library string
+	signers map[string]identity.Sign
+	closers []identity.HSMSignClose
+}
+
+func hsmSign(config HSMConfig, ski []byte) (identity.Sign, error) {
+	hsm.Lock()
+	defer hsm.Unlock()
+
+	if hsm.factory != nil && hsm.library != config.Library {
+		if err := closeSigners(); err != nil {
+			return nil, err
+		}
+	}
+	if hsm.factory == nil {
+		factory, err := identity.NewHSMSignerFactory(config.Library)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the PKCS#11 library %s: %w", config.Library, err)
+		}
+		hsm.factory, hsm.library, hsm.signers = factory, config.Library, map[string]identity.Sign{}
+	}
+
+	key := config.Label + "/" + string(ski)
+	if sign, ok := hsm.signers[key]; ok {
+		return sign, nil
+	}
+	sign, close, err := hsm.factory.NewHSMSigner(identity.HSMSignerOptions{
+		Label:      config.Label,
+		Pin:        config.Pin,
+		Identifier: string(ski),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the key %x in the HSM token %s: %w", ski, config.Label, err)
+	}
+	hsm.signers[key] = sign
+	hsm.closers = append(hsm.closers, close)
+	return sign, nil
+}
+
+func closeHSM() error {
+	hsm.Lock()
+	defer hsm.Unlock()
+	return closeSigners()
+}
+
+func closeSigners() error {
+	if hsm.factory == nil {
+		return nil
+	}
+	var firstErr error
+	for _, close := range hsm.closers {
+		if err := close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	hsm.factory.Dispose()
+	hsm.factory, hsm.library, hsm.signers, hsm.closers = nil, "", nil, nil
+	return firstErr
+}
@@ -0,0 +1,26 @@
+//go:build !pkcs11
+// +build !pkcs11
+
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package wallet
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+// hsmSign needs cgo and the PKCS#11 support of fabric-gateway, which are only built
+// with the pkcs11 tag.
+func hsmSign(config HSMConfig, ski []byte) (identity.Sign, error) {
+	return nil, fmt.Errorf("SIGNER=%s requires building with -tags pkcs11", SignerPKCS11)
+}
+
+func closeHSM() error {
+	return nil
+}
@@ -0,0 +1,145 @@
+//go:build pkcs11
+// +build pkcs11
+
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+// softHSMConfig returns the token of the SoftHSM setup of the HSM samples, e.g.
+//
+//	softhsm2-util --init-token --slot 0 --label ForFabric --pin 98765432 --so-pin 1234
+//
+// with SIGNER=pkcs11 PKCS11_LABEL=ForFabric PKCS11_PIN=98765432.
+func softHSMConfig(t *testing.T) HSMConfig {
+	t.Helper()
+	config, err := HSMConfigFromEnv()
+	if err != nil {
+		t.Skipf("SoftHSM is not configured: %v", err)
+	}
+	if config == nil {
+		t.Skip("SoftHSM is not configured, set SIGNER=pkcs11")
+	}
+	return *config
+}
+
+// generateKey creates a P-256 key pair in the token with the SKI of its public key
+// as CKA_ID, as the PKCS#11 enabled fabric-ca-client does.
+func generateKey(t *testing.T, config HSMConfig) *ecdsa.PublicKey {
+	t.Helper()
+	ctx := pkcs11.New(config.Library)
+	if err := ctx.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Finalize()
+
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var session pkcs11.SessionHandle
+	found := false
+	for _, slot := range slots {
+		if info, err := ctx.GetTokenInfo(slot); err == nil && info.Label == config.Label {
+			session, err = ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Fatalf("no token %s", config.Label)
+	}
+	defer ctx.CloseSession(session)
+	if err = ctx.Login(session, pkcs11.CKU_USER, config.Pin); err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Logout(session)
+
+	curve, err := asn1.Marshal(asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}) // P-256
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicHandle, privateHandle, err := ctx.GenerateKeyPair(session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, curve),
+		},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attributes, err := ctx.GetAttributeValue(session, publicHandle, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var point []byte
+	if _, err = asn1.Unmarshal(attributes[0].Value, &point); err != nil {
+		t.Fatal(err)
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), point)
+	if x == nil {
+		t.Fatal("invalid EC point")
+	}
+	publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+
+	ski, err := SKI(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, handle := range []pkcs11.ObjectHandle{publicHandle, privateHandle} {
+		if err = ctx.SetAttributeValue(session, handle, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_ID, ski)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return publicKey
+}
+
+func TestNewSignWithSoftHSM(t *testing.T) {
+	config := softHSMConfig(t)
+	publicKey := generateKey(t, config)
+	ConfigureHSM(&config)
+	defer ConfigureHSM(nil)
+	defer Close()
+
+	// the certificate of the key is issued by another key, as by a CA
+	issuer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign, err := NewSign(newCertificate(t, publicKey, issuer), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("proposal"))
+	signature, err := sign(digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(publicKey, digest[:], signature) {
+		t.Error("the HSM signature does not verify with the public key of the certificate")
+	}
+}
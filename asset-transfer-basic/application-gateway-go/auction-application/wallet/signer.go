@@ -0,0 +1,153 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+// SIGNER selects how the identities sign: with the private key stored with them
+// ("file", the default) or with a key held in an HSM ("pkcs11").
+const (
+	SignerFile   = "file"
+	SignerPKCS11 = "pkcs11"
+)
+
+// softHSMLibraries are searched when PKCS11_LIB is not set, as in the HSM samples.
+var softHSMLibraries = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/libacsp-pkcs11.so",
+}
+
+// HSMConfig is the PKCS#11 token holding the private keys. The key of an identity is
+// the one whose CKA_ID is the SKI of its certificate, as the PKCS#11 enabled
+// fabric-ca-client stores it.
+type HSMConfig struct {
+	Library string // path of the PKCS#11 library
+	Label   string // label of the token
+	Pin     string // user PIN of the token
+}
+
+// signer is the HSM configuration of the identities, read from the environment on
+// the first signature
+var signer struct {
+	sync.Mutex
+	loaded bool
+	hsm    *HSMConfig // nil in file mode
+	err    error
+}
+
+// HSMConfigFromEnv returns the HSM configuration of SIGNER=pkcs11 from PKCS11_LIB,
+// PKCS11_LABEL and PKCS11_PIN, or nil if the identities sign with their files.
+func HSMConfigFromEnv() (*HSMConfig, error) {
+	switch mode := os.Getenv("SIGNER"); mode {
+	case "", SignerFile:
+		return nil, nil
+	case SignerPKCS11:
+	default:
+		return nil, fmt.Errorf("unknown SIGNER %q, expected %s or %s", mode, SignerFile, SignerPKCS11)
+	}
+
+	config := &HSMConfig{
+		Library: os.Getenv("PKCS11_LIB"),
+		Label:   os.Getenv("PKCS11_LABEL"),
+		Pin:     os.Getenv("PKCS11_PIN"),
+	}
+	if config.Library == "" {
+		for _, library := range softHSMLibraries {
+			if _, err := os.Stat(library); !errors.Is(err, os.ErrNotExist) {
+				config.Library = library
+				break
+			}
+		}
+	}
+	if config.Library == "" {
+		return nil, fmt.Errorf("no PKCS#11 library found, set PKCS11_LIB")
+	}
+	if config.Label == "" || config.Pin == "" {
+		return nil, fmt.Errorf("PKCS11_LABEL and PKCS11_PIN are required with SIGNER=%s", SignerPKCS11)
+	}
+	return config, nil
+}
+
+// ConfigureHSM makes the identities sign with the keys of an HSM, or with their files
+// if config is nil, instead of following the environment.
+func ConfigureHSM(config *HSMConfig) {
+	signer.Lock()
+	defer signer.Unlock()
+	signer.loaded, signer.hsm, signer.err = true, config, nil
+}
+
+func hsmConfig() (*HSMConfig, error) {
+	signer.Lock()
+	defer signer.Unlock()
+	if !signer.loaded {
+		signer.hsm, signer.err = HSMConfigFromEnv()
+		signer.loaded = true
+	}
+	return signer.hsm, signer.err
+}
+
+// SignsWithHSM reports whether the identities sign with the keys of an HSM, so that
+// their private keys are not needed.
+func SignsWithHSM() (bool, error) {
+	config, err := hsmConfig()
+	return config != nil, err
+}
+
+// NewSign returns a function that signs message digests for the owner of a
+// certificate: with privateKeyPEM, or with the HSM key of the certificate when
+// SIGNER=pkcs11, in which case privateKeyPEM is not used.
+func NewSign(certificatePEM []byte, privateKeyPEM []byte) (identity.Sign, error) {
+	config, err := hsmConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		certificate, err := identity.CertificateFromPEM(certificatePEM)
+		if err != nil {
+			return nil, err
+		}
+		ski, err := SKI(certificate.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		return hsmSign(*config, ski)
+	}
+
+	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return identity.NewPrivateKeySign(privateKey)
+}
+
+// SKI returns the subject key identifier of an ECDSA public key: the SHA-256 hash of
+// its uncompressed point.
+func SKI(publicKey interface{}) ([]byte, error) {
+	ecdsaKey, ok := publicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported public key type %T, expected ECDSA", publicKey)
+	}
+	ski := sha256.Sum256(elliptic.Marshal(ecdsaKey.Curve, ecdsaKey.X, ecdsaKey.Y))
+	return ski[:], nil
+}
+
+// Close releases the HSM sessions of the signers; signing afterwards opens new ones.
+func Close() error {
+	return closeHSM()
+}
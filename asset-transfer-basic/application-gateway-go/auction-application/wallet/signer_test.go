@@ -0,0 +1,71 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestNewSignWithThePrivateKeyFile(t *testing.T) {
+	ConfigureHSM(nil)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	sign, err := NewSign(newCertificate(t, &key.PublicKey, key), keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("proposal"))
+	signature, err := sign(digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+		t.Error("the signature does not verify with the public key")
+	}
+}
+
+func TestHSMConfigFromEnv(t *testing.T) {
+	t.Setenv("SIGNER", "")
+	if config, err := HSMConfigFromEnv(); config != nil || err != nil {
+		t.Errorf("expected the file mode by default, got %+v %v", config, err)
+	}
+
+	t.Setenv("SIGNER", SignerPKCS11)
+	t.Setenv("PKCS11_LIB", "/opt/hsm/libpkcs11.so")
+	t.Setenv("PKCS11_LABEL", "ForFabric")
+	t.Setenv("PKCS11_PIN", "")
+	if _, err := HSMConfigFromEnv(); err == nil {
+		t.Error("expected an error without a PIN")
+	}
+	t.Setenv("PKCS11_PIN", "98765432")
+	config, err := HSMConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *config != (HSMConfig{Library: "/opt/hsm/libpkcs11.so", Label: "ForFabric", Pin: "98765432"}) {
+		t.Errorf("unexpected configuration %+v", config)
+	}
+
+	t.Setenv("SIGNER", "vault")
+	if _, err = HSMConfigFromEnv(); err == nil {
+		t.Error("expected an error for an unknown signer")
+	}
+}
@@ -0,0 +1,286 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package wallet stores the X.509 identities of the auction users in a directory,
+// one JSON file per user, so that each HTTP request can be signed by the identity
+// of the user who sent it.
+package wallet
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+const fileExtension = ".id"
+
+// ErrNotFound is returned when the wallet has no identity with the requested label.
+var ErrNotFound = errors.New("identity not found in wallet")
+
+// ErrUnauthorized is returned when a request carries no or wrong credentials.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Identity is a user's enrollment certificate and private key.
+type Identity struct {
+	Label       string `json:"label"`
+	MspID       string `json:"mspId"`
+	Certificate string `json:"certificate"` // PEM
+	PrivateKey  string `json:"privateKey"`  // PEM, empty if the key is in an HSM
+	APIKeyHash  string `json:"apiKeyHash,omitempty"`
+}
+
+// Wallet is a directory of identities.
+type Wallet struct {
+	dir string
+}
+
+// New opens the wallet in dir, creating the directory if needed.
+func New(dir string) (*Wallet, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create wallet directory: %w", err)
+	}
+	return &Wallet{dir: dir}, nil
+}
+
+// Put stores id, replacing any identity with the same label.
+func (w *Wallet) Put(id *Identity) error {
+	if err := validLabel(id.Label); err != nil {
+		return err
+	}
+	idJSON, err := json.MarshalIndent(id, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(w.path(id.Label), idJSON, 0600)
+}
+
+// Get returns the identity stored under label.
+func (w *Wallet) Get(label string) (*Identity, error) {
+	if err := validLabel(label); err != nil {
+		return nil, err
+	}
+	idJSON, err := os.ReadFile(w.path(label))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, label)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity %s: %w", label, err)
+	}
+
+	var id Identity
+	if err = json.Unmarshal(idJSON, &id); err != nil {
+		return nil, fmt.Errorf("failed to parse identity %s: %w", label, err)
+	}
+	return &id, nil
+}
+
+// List returns the labels of all identities in the wallet.
+func (w *Wallet) List() ([]string, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wallet directory: %w", err)
+	}
+
+	labels := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), fileExtension) {
+			labels = append(labels, strings.TrimSuffix(entry.Name(), fileExtension))
+		}
+	}
+	sort.Strings(labels)
+	return labels, nil
+}
+
+// Remove deletes the identity stored under label.
+func (w *Wallet) Remove(label string) error {
+	if err := validLabel(label); err != nil {
+		return err
+	}
+	err := os.Remove(w.path(label))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrNotFound, label)
+	}
+	return err
+}
+
+// Import reads signcerts/cert.pem and the first key in keystore/ of an MSP directory,
+// as generated by cryptogen or the Fabric CA client, and stores them under label.
+// With SIGNER=pkcs11 the private key is in the HSM, so only the certificate is stored.
+func (w *Wallet) Import(label string, mspID string, mspDir string) (*Identity, error) {
+	certificatePEM, err := os.ReadFile(filepath.Join(mspDir, "signcerts", "cert.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file: %w", err)
+	}
+	if _, err = identity.CertificateFromPEM(certificatePEM); err != nil {
+		return nil, err
+	}
+
+	withHSM, err := SignsWithHSM()
+	if err != nil {
+		return nil, err
+	}
+	var privateKeyPEM []byte
+	if !withHSM {
+		privateKeyPEM, err = ReadPrivateKey(filepath.Join(mspDir, "keystore"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	id := &Identity{
+		Label:       label,
+		MspID:       mspID,
+		Certificate: string(certificatePEM),
+		PrivateKey:  string(privateKeyPEM),
+	}
+	if existing, err := w.Get(label); err == nil {
+		id.APIKeyHash = existing.APIKeyHash
+	}
+	if err = w.Put(id); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// Export writes the identity stored under label as an MSP directory
+// (signcerts/cert.pem and keystore/priv_sk).
+func (w *Wallet) Export(label string, mspDir string) error {
+	id, err := w.Get(label)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range []string{"signcerts", "keystore"} {
+		if err = os.MkdirAll(filepath.Join(mspDir, dir), 0700); err != nil {
+			return err
+		}
+	}
+	if err = os.WriteFile(filepath.Join(mspDir, "signcerts", "cert.pem"), []byte(id.Certificate), 0644); err != nil {
+		return err
+	}
+	// the key of an identity imported with SIGNER=pkcs11 stays in the HSM
+	if id.PrivateKey == "" {
+		return nil
+	}
+	return os.WriteFile(filepath.Join(mspDir, "keystore", "priv_sk"), []byte(id.PrivateKey), 0600)
+}
+
+// ReadPrivateKey reads the first key in the keystore directory of an MSP.
+func ReadPrivateKey(keyDir string) ([]byte, error) {
+	files, err := os.ReadDir(keyDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key directory: %w", err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no private key in %s", keyDir)
+	}
+	privateKeyPEM, err := os.ReadFile(filepath.Join(keyDir, files[0].Name()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	}
+	if _, err = identity.PrivateKeyFromPEM(privateKeyPEM); err != nil {
+		return nil, err
+	}
+	return privateKeyPEM, nil
+}
+
+// GenerateAPIKey creates a new random API key for the identity stored under label,
+// stores its hash and returns the key. Any previous key stops working.
+func (w *Wallet) GenerateAPIKey(label string) (string, error) {
+	id, err := w.Get(label)
+	if err != nil {
+		return "", err
+	}
+
+	key := make([]byte, 24)
+	if _, err = rand.Read(key); err != nil {
+		return "", err
+	}
+	apiKey := hex.EncodeToString(key)
+	id.APIKeyHash = hashAPIKey(apiKey)
+	if err = w.Put(id); err != nil {
+		return "", err
+	}
+	return apiKey, nil
+}
+
+// Authenticate returns the identity of the user of an HTTP request. The request must
+// use basic authentication with the wallet label as user name and the identity's API
+// key as password.
+func (w *Wallet) Authenticate(r *http.Request) (*Identity, error) {
+	label, apiKey, ok := r.BasicAuth()
+	if !ok || validLabel(label) != nil {
+		return nil, ErrUnauthorized
+	}
+
+	id, err := w.Get(label)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+	if id.APIKeyHash == "" || subtle.ConstantTimeCompare([]byte(id.APIKeyHash), []byte(hashAPIKey(apiKey))) != 1 {
+		return nil, ErrUnauthorized
+	}
+	return id, nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the authenticated identity.
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the authenticated identity of the context, or nil.
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(contextKey{}).(*Identity)
+	return id
+}
+
+// X509Identity returns the client identity for a Gateway connection.
+func (id *Identity) X509Identity() (*identity.X509Identity, error) {
+	certificate, err := identity.CertificateFromPEM([]byte(id.Certificate))
+	if err != nil {
+		return nil, err
+	}
+	return identity.NewX509Identity(id.MspID, certificate)
+}
+
+// Sign returns a function that signs message digests with the identity's private key,
+// or with its key in the HSM when SIGNER=pkcs11 (see NewSign).
+func (id *Identity) Sign() (identity.Sign, error) {
+	return NewSign([]byte(id.Certificate), []byte(id.PrivateKey))
+}
+
+func (w *Wallet) path(label string) string {
+	return filepath.Join(w.dir, label+fileExtension)
+}
+
+func validLabel(label string) error {
+	if label == "" || strings.ContainsAny(label, `/\`) || label == "." || label == ".." {
+		return fmt.Errorf("invalid identity label %q", label)
+	}
+	return nil
+}
+
+func hashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
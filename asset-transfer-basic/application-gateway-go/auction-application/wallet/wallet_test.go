@@ -0,0 +1,165 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newCertificate returns a self-signed certificate of publicKey in PEM, signed by key.
+func newCertificate(t *testing.T, publicKey *ecdsa.PublicKey, key *ecdsa.PrivateKey) []byte {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "User1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificateDER, err := x509.CreateCertificate(rand.Reader, template, template, publicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateDER})
+}
+
+// newMSPDir writes an MSP directory with a new key and its certificate, as cryptogen does.
+func newMSPDir(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mspDir := t.TempDir()
+	for _, dir := range []string{"signcerts", "keystore"} {
+		if err = os.MkdirAll(filepath.Join(mspDir, dir), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.WriteFile(filepath.Join(mspDir, "signcerts", "cert.pem"), newCertificate(t, &key.PublicKey, key), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err = os.WriteFile(filepath.Join(mspDir, "keystore", "priv_sk"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return mspDir
+}
+
+func TestImportExportAndListTheIdentities(t *testing.T) {
+	ConfigureHSM(nil)
+	w, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mspDir := newMSPDir(t)
+	for _, label := range []string{"User2", "User1"} {
+		if _, err = w.Import(label, "Org1MSP", mspDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	labels, err := w.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(labels, []string{"User1", "User2"}) {
+		t.Errorf("labels = %v", labels)
+	}
+	id, err := w.Get("User1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.MspID != "Org1MSP" || id.PrivateKey == "" {
+		t.Errorf("unexpected identity %+v", id)
+	}
+	if _, err = id.X509Identity(); err != nil {
+		t.Error(err)
+	}
+
+	exported := t.TempDir()
+	if err = w.Export("User1", exported); err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(exported, "keystore", "priv_sk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(keyPEM) != id.PrivateKey {
+		t.Error("the exported key is not the imported one")
+	}
+
+	if err = w.Remove("User2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Get("User2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after Remove, got %v", err)
+	}
+	if _, err = w.Get("../User1"); err == nil {
+		t.Error("expected an error for a label with a path")
+	}
+}
+
+func TestAuthenticateWithTheAPIKeyOfTheIdentity(t *testing.T) {
+	ConfigureHSM(nil)
+	w, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Import("User1", "Org1MSP", newMSPDir(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest("POST", "/bid", nil)
+	request.SetBasicAuth("User1", "anything")
+	if _, err = w.Authenticate(request); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized before an API key is generated, got %v", err)
+	}
+
+	apiKey, err := w.GenerateAPIKey("User1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.SetBasicAuth("User1", apiKey)
+	id, err := w.Authenticate(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Label != "User1" {
+		t.Errorf("authenticated %s, want User1", id.Label)
+	}
+
+	// a new import keeps the API key
+	if _, err = w.Import("User1", "Org1MSP", newMSPDir(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Authenticate(request); err != nil {
+		t.Errorf("the API key stopped working after a new import: %v", err)
+	}
+
+	request.SetBasicAuth("User2", apiKey)
+	if _, err = w.Authenticate(request); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized for an unknown user, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+// ウォレット管理
+// go run walletctl.go list
+// go run walletctl.go import User1 Org1MSP ../../../test-network/organizations/peerOrganizations/org1.example.com/users/User1@org1.example.com/msp
+// go run walletctl.go export User1 ./User1-msp
+// go run walletctl.go apikey User1
+// go run walletctl.go remove User1
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"assetTransfer/auction-application/connection"
+)
+
+func main() {
+	userWallet, err := connection.OpenWallet()
+	if err != nil {
+		exit(err)
+	}
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+	args := os.Args[2:]
+
+	switch os.Args[1] {
+	case "list":
+		labels, err := userWallet.List()
+		if err != nil {
+			exit(err)
+		}
+		for _, label := range labels {
+			id, err := userWallet.Get(label)
+			if err != nil {
+				exit(err)
+			}
+			key := "file"
+			if id.PrivateKey == "" {
+				key = "hsm"
+			}
+			fmt.Printf("%s\t%s\tkey:%s\tapikey:%t\n", id.Label, id.MspID, key, id.APIKeyHash != "")
+		}
+	case "import":
+		if len(args) != 3 {
+			usage()
+		}
+		if _, err := userWallet.Import(args[0], args[1], args[2]); err != nil {
+			exit(err)
+		}
+		fmt.Printf("imported %s\n", args[0])
+	case "export":
+		if len(args) != 2 {
+			usage()
+		}
+		if err := userWallet.Export(args[0], args[1]); err != nil {
+			exit(err)
+		}
+		fmt.Printf("exported %s to %s\n", args[0], args[1])
+	case "apikey":
+		if len(args) != 1 {
+			usage()
+		}
+		apiKey, err := userWallet.GenerateAPIKey(args[0])
+		if err != nil {
+			exit(err)
+		}
+		fmt.Println(apiKey)
+	case "remove":
+		if len(args) != 1 {
+			usage()
+		}
+		if err := userWallet.Remove(args[0]); err != nil {
+			exit(err)
+		}
+		fmt.Printf("removed %s\n", args[0])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: walletctl <command> [arguments]
+
+commands:
+  list
+  import <label> <mspID> <mspDir>
+  export <label> <mspDir>
+  apikey <label>
+  remove <label>
+
+The wallet directory is WALLET_PATH (default ./identities).
+With SIGNER=pkcs11 the private keys stay in the HSM token PKCS11_LABEL (PIN
+PKCS11_PIN, library PKCS11_LIB or SoftHSM) and import only reads the certificate.`)
+	os.Exit(2)
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
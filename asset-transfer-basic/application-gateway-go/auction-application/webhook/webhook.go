@@ -0,0 +1,501 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package webhook delivers the simulator notifications of the auction applications.
+// Every notification is written to a durable outbox file before it is sent, retried
+// with exponential backoff, signed with HMAC-SHA256 and moved to the dead-letter
+// list when an endpoint keeps failing. Dead letters are replayed or purged by hand,
+// and purged automatically after deadLetterRetention.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"assetTransfer/auction-application/logging"
+	"assetTransfer/auction-application/metrics"
+)
+
+const (
+	// SignatureHeader carries "sha256=<hex HMAC of the body>" when the endpoint has a secret.
+	SignatureHeader = "X-Signature-256"
+	// DeliveryHeader carries the delivery ID so receivers can drop duplicates.
+	DeliveryHeader = "X-Delivery-Id"
+
+	defaultMaxAttempts = 8
+	defaultTimeout     = 5 // seconds
+	initialBackoff     = time.Second
+	maxBackoff         = 5 * time.Minute
+	pollInterval       = time.Second
+	// deadLetterRetention bounds the outbox when the dead letters are left alone
+	deadLetterRetention = 7 * 24 * time.Hour
+)
+
+var (
+	deliveriesTotal = metrics.NewCounter("auction_webhook_deliveries_total",
+		"Webhook delivery attempts by endpoint and result (delivered, failed or dead).", "endpoint", "result")
+	deliverySeconds = metrics.NewHistogram("auction_webhook_delivery_seconds",
+		"Latency of the webhook delivery attempts.", metrics.LatencyBuckets, "endpoint")
+)
+
+// Endpoint is the per-receiver configuration.
+type Endpoint struct {
+	Name           string `json:"name"`
+	URL            string `json:"url"`
+	Secret         string `json:"secret"`
+	MaxAttempts    int    `json:"maxAttempts"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+}
+
+// Delivery is one notification stored in the outbox.
+type Delivery struct {
+	ID          string          `json:"id"`
+	Endpoint    string          `json:"endpoint"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+	LastError   string          `json:"lastError"`
+	Dead        bool            `json:"dead"`
+	DeadAt      time.Time       `json:"deadAt"`
+
+	inFlight bool
+}
+
+// Dispatcher owns the outbox and sends pending deliveries in the background.
+type Dispatcher struct {
+	mu         sync.Mutex
+	outboxPath string
+	endpoints  map[string]Endpoint
+	deliveries []*Delivery
+	sequence   int64
+	httpClient *http.Client
+	wake       chan struct{}
+}
+
+// LoadEndpoints returns the default endpoints overridden by the entries of the JSON
+// file at path (matched by name). An empty path returns the defaults unchanged.
+func LoadEndpoints(path string, defaults []Endpoint) ([]Endpoint, error) {
+	endpoints := append([]Endpoint{}, defaults...)
+	if path == "" {
+		return endpoints, nil
+	}
+
+	configJSON, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook config: %w", err)
+	}
+	var configured []Endpoint
+	if err = json.Unmarshal(configJSON, &configured); err != nil {
+		return nil, fmt.Errorf("failed to parse webhook config: %w", err)
+	}
+
+	for _, endpoint := range configured {
+		replaced := false
+		for i := range endpoints {
+			if endpoints[i].Name == endpoint.Name {
+				endpoints[i] = endpoint
+				replaced = true
+			}
+		}
+		if !replaced {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints, nil
+}
+
+// NewDispatcher creates a dispatcher and reloads any deliveries left in the outbox
+// file by a previous run.
+func NewDispatcher(outboxPath string, endpoints []Endpoint) (*Dispatcher, error) {
+	d := &Dispatcher{
+		outboxPath: outboxPath,
+		endpoints:  map[string]Endpoint{},
+		httpClient: &http.Client{},
+		wake:       make(chan struct{}, 1),
+	}
+	for _, endpoint := range endpoints {
+		if endpoint.MaxAttempts <= 0 {
+			endpoint.MaxAttempts = defaultMaxAttempts
+		}
+		if endpoint.TimeoutSeconds <= 0 {
+			endpoint.TimeoutSeconds = defaultTimeout
+		}
+		d.endpoints[endpoint.Name] = endpoint
+	}
+
+	outboxJSON, err := os.ReadFile(outboxPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read webhook outbox: %w", err)
+	}
+	if len(outboxJSON) > 0 {
+		if err = json.Unmarshal(outboxJSON, &d.deliveries); err != nil {
+			return nil, fmt.Errorf("failed to parse webhook outbox: %w", err)
+		}
+	}
+	// the retention of the dead letters of an outbox written before DeadAt starts now
+	for _, delivery := range d.deliveries {
+		if delivery.Dead && delivery.DeadAt.IsZero() {
+			delivery.DeadAt = time.Now()
+		}
+	}
+
+	return d, nil
+}
+
+// Start sends pending deliveries until the process exits.
+func (d *Dispatcher) Start() {
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-d.wake:
+			}
+			d.dispatchDue()
+		}
+	}()
+}
+
+// Enqueue stores the JSON encoding of payload in the outbox for the named endpoint.
+// The notification is sent asynchronously; Enqueue only fails when it cannot be stored.
+func (d *Dispatcher) Enqueue(endpointName string, payload interface{}) error {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	if _, ok := d.endpoints[endpointName]; !ok {
+		d.mu.Unlock()
+		return fmt.Errorf("webhook endpoint %s is not configured", endpointName)
+	}
+	now := time.Now()
+	d.sequence++
+	d.deliveries = append(d.deliveries, &Delivery{
+		ID:          strconv.FormatInt(now.UnixNano(), 36) + "-" + strconv.FormatInt(d.sequence, 36),
+		Endpoint:    endpointName,
+		Payload:     payloadJSON,
+		CreatedAt:   now,
+		NextAttempt: now,
+	})
+	err = d.persist()
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// DeadLetters returns the deliveries that exhausted their attempts.
+func (d *Dispatcher) DeadLetters() []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dead := []Delivery{}
+	for _, delivery := range d.deliveries {
+		if delivery.Dead {
+			dead = append(dead, *delivery)
+		}
+	}
+	return dead
+}
+
+// DeadLetterHandler lists the dead-letter deliveries as JSON.
+func (d *Dispatcher) DeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed) //405
+		w.Write([]byte("Only GET"))
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(d.DeadLetters()); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+}
+
+// Replay sends the dead letters with the given IDs again, with a new set of attempts,
+// or all of them if no ID is given. It returns the number of deliveries replayed.
+func (d *Dispatcher) Replay(ids ...string) (int, error) {
+	d.mu.Lock()
+	now := time.Now()
+	replayed := 0
+	for _, delivery := range d.deliveries {
+		if !delivery.Dead || !selected(delivery.ID, ids) {
+			continue
+		}
+		delivery.Dead = false
+		delivery.DeadAt = time.Time{}
+		delivery.Attempts = 0
+		delivery.NextAttempt = now
+		replayed++
+	}
+	err := d.persist()
+	d.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return replayed, nil
+}
+
+// Purge deletes the dead letters with the given IDs, or all of them if no ID is given.
+// It returns the number of deliveries deleted.
+func (d *Dispatcher) Purge(ids ...string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	purged := d.removeDead(func(delivery *Delivery) bool { return selected(delivery.ID, ids) })
+	if err := d.persist(); err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// ReplayHandler replays the dead letters of the "id" query parameters, or all of them.
+func (d *Dispatcher) ReplayHandler(w http.ResponseWriter, r *http.Request) {
+	d.handleDeadLetters(w, r, d.Replay)
+}
+
+// PurgeHandler deletes the dead letters of the "id" query parameters, or all of them.
+func (d *Dispatcher) PurgeHandler(w http.ResponseWriter, r *http.Request) {
+	d.handleDeadLetters(w, r, d.Purge)
+}
+
+// AdminHandler serves the dead-letter endpoints under /webhooks/deadletter. They are
+// not authenticated and must only be served on a loopback address.
+func (d *Dispatcher) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhooks/deadletter", d.DeadLetterHandler)
+	mux.HandleFunc("/webhooks/deadletter/replay", d.ReplayHandler)
+	mux.HandleFunc("/webhooks/deadletter/purge", d.PurgeHandler)
+	return mux
+}
+
+func (d *Dispatcher) handleDeadLetters(w http.ResponseWriter, r *http.Request, apply func(ids ...string) (int, error)) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed) //405
+		w.Write([]byte("Only POST"))
+		return
+	}
+
+	count, err := apply(r.URL.Query()["id"]...)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"count": count})
+}
+
+// Sign returns the signature header value of body for the given secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *Dispatcher) dispatchDue() {
+	d.mu.Lock()
+	now := time.Now()
+	expired := d.removeDead(func(delivery *Delivery) bool {
+		return now.Sub(delivery.DeadAt) > deadLetterRetention
+	})
+	if expired > 0 {
+		if err := d.persist(); err != nil {
+			logging.Default().Error("failed to persist webhook outbox", "error", err)
+		}
+	}
+	for _, delivery := range d.deliveries {
+		if delivery.Dead || delivery.inFlight || delivery.NextAttempt.After(now) {
+			continue
+		}
+		delivery.inFlight = true
+		go d.deliver(delivery, d.endpoints[delivery.Endpoint])
+	}
+	d.mu.Unlock()
+}
+
+func (d *Dispatcher) deliver(delivery *Delivery, endpoint Endpoint) {
+	start := time.Now()
+	err := d.send(delivery, endpoint)
+	deliverySeconds.ObserveSince(start, delivery.Endpoint)
+	logger := logging.Default().With("delivery_id", delivery.ID, "endpoint", delivery.Endpoint)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delivery.inFlight = false
+	delivery.Attempts++
+	if err == nil {
+		d.remove(delivery)
+		deliveriesTotal.Inc(delivery.Endpoint, "delivered")
+		logger.Debug("webhook delivered", "attempt", delivery.Attempts)
+	} else {
+		delivery.LastError = err.Error()
+		if endpoint.URL == "" || delivery.Attempts >= endpoint.MaxAttempts {
+			delivery.Dead = true
+			delivery.DeadAt = time.Now()
+			deliveriesTotal.Inc(delivery.Endpoint, "dead")
+			logger.Error("webhook moved to dead letters", "attempt", delivery.Attempts, "error", err)
+		} else {
+			delivery.NextAttempt = time.Now().Add(backoff(delivery.Attempts))
+			deliveriesTotal.Inc(delivery.Endpoint, "failed")
+			logger.Warn("webhook delivery failed", "attempt", delivery.Attempts, "error", err)
+		}
+	}
+
+	if err := d.persist(); err != nil {
+		logger.Error("failed to persist webhook outbox", "error", err)
+	}
+}
+
+func (d *Dispatcher) send(delivery *Delivery, endpoint Endpoint) error {
+	if endpoint.URL == "" {
+		return fmt.Errorf("webhook endpoint %s is not configured", delivery.Endpoint)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	if endpoint.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(endpoint.Secret, delivery.Payload))
+	}
+
+	client := *d.httpClient
+	client.Timeout = time.Duration(endpoint.TimeoutSeconds) * time.Second
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status %s", res.Status)
+	}
+	return nil
+}
+
+func (d *Dispatcher) remove(delivery *Delivery) {
+	for i, candidate := range d.deliveries {
+		if candidate == delivery {
+			d.deliveries = append(d.deliveries[:i], d.deliveries[i+1:]...)
+			return
+		}
+	}
+}
+
+// removeDead deletes the dead letters matching the condition and returns their number;
+// the caller must hold d.mu.
+func (d *Dispatcher) removeDead(matches func(delivery *Delivery) bool) int {
+	kept := d.deliveries[:0]
+	removed := 0
+	for _, delivery := range d.deliveries {
+		if delivery.Dead && matches(delivery) {
+			removed++
+			continue
+		}
+		kept = append(kept, delivery)
+	}
+	d.deliveries = kept
+	return removed
+}
+
+// persist rewrites the outbox file and syncs it to disk, so that an enqueued
+// notification survives a crash; the caller must hold d.mu.
+func (d *Dispatcher) persist() error {
+	outboxJSON, err := json.Marshal(d.deliveries)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := d.outboxPath + ".tmp"
+	if err = writeFileSync(tmpPath, outboxJSON); err != nil {
+		return fmt.Errorf("failed to write webhook outbox: %w", err)
+	}
+	if err = os.Rename(tmpPath, d.outboxPath); err != nil {
+		return fmt.Errorf("failed to write webhook outbox: %w", err)
+	}
+	// the rename is durable once the directory is synced
+	dir, err := os.Open(filepath.Dir(d.outboxPath))
+	if err != nil {
+		return fmt.Errorf("failed to sync webhook outbox: %w", err)
+	}
+	defer dir.Close()
+	if err = dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync webhook outbox: %w", err)
+	}
+	return nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// selected reports whether id is one of ids, or ids is empty.
+func selected(id string, ids []string) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+func backoff(attempts int) time.Duration {
+	wait := initialBackoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2021 IBM All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is a webhook endpoint that fails until it is told to accept.
+type receiver struct {
+	mu       sync.Mutex
+	accept   bool
+	received []string // delivery IDs
+	bodies   [][]byte
+	signed   []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if !rc.accept {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	rc.received = append(rc.received, r.Header.Get(DeliveryHeader))
+	rc.bodies = append(rc.bodies, body)
+	rc.signed = append(rc.signed, r.Header.Get(SignatureHeader))
+}
+
+func (rc *receiver) setAccept(accept bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.accept = accept
+}
+
+func (rc *receiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.received)
+}
+
+// waitFor dispatches the due deliveries until cond is true.
+func waitFor(t *testing.T, d *Dispatcher, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		d.dispatchDue()
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newTestDispatcher(t *testing.T, outboxPath string, url string) *Dispatcher {
+	t.Helper()
+	d, err := NewDispatcher(outboxPath, []Endpoint{{Name: "bid", URL: url, Secret: "s3cret", MaxAttempts: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDeliveriesAreSignedAndDeadLettersReplayed(t *testing.T) {
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+	outboxPath := filepath.Join(t.TempDir(), "outbox.json")
+	d := newTestDispatcher(t, outboxPath, server.URL)
+
+	if err := d.Enqueue("bid", map[string]string{"TokenId": "energy1"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, d, "the dead letter", func() bool { return len(d.DeadLetters()) == 1 })
+
+	// the dead letters survive a restart
+	d = newTestDispatcher(t, outboxPath, server.URL)
+	dead := d.DeadLetters()
+	if len(dead) != 1 || dead[0].LastError == "" || dead[0].DeadAt.IsZero() {
+		t.Fatalf("unexpected dead letters after a restart %+v", dead)
+	}
+
+	rc.setAccept(true)
+	request := httptest.NewRequest(http.MethodPost, "/webhooks/deadletter/replay?id="+dead[0].ID, nil)
+	response := httptest.NewRecorder()
+	d.AdminHandler().ServeHTTP(response, request)
+	if response.Code != http.StatusOK || response.Body.String() != "{\"count\":1}\n" {
+		t.Fatalf("unexpected replay response %d %s", response.Code, response.Body.String())
+	}
+	waitFor(t, d, "the replayed delivery", func() bool { return rc.count() == 1 })
+
+	if rc.received[0] != dead[0].ID || rc.signed[0] != Sign("s3cret", rc.bodies[0]) {
+		t.Errorf("unexpected delivery %s %s", rc.received[0], rc.signed[0])
+	}
+	var payload map[string]string
+	if err := json.Unmarshal(rc.bodies[0], &payload); err != nil || payload["TokenId"] != "energy1" {
+		t.Errorf("unexpected payload %s", rc.bodies[0])
+	}
+	waitFor(t, d, "the outbox to be emptied", func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return len(d.deliveries) == 0
+	})
+}
+
+func TestPurgeDeletesTheDeadLettersAndRetentionExpiresThem(t *testing.T) {
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+	outboxPath := filepath.Join(t.TempDir(), "outbox.json")
+	d := newTestDispatcher(t, outboxPath, server.URL)
+
+	for _, id := range []string{"energy1", "energy2", "energy3"} {
+		if err := d.Enqueue("bid", map[string]string{"TokenId": id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, d, "the dead letters", func() bool { return len(d.DeadLetters()) == 3 })
+	dead := d.DeadLetters()
+
+	request := httptest.NewRequest(http.MethodGet, "/webhooks/deadletter/purge", nil)
+	response := httptest.NewRecorder()
+	d.AdminHandler().ServeHTTP(response, request)
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected a GET to be rejected, got %d", response.Code)
+	}
+
+	purged, err := d.Purge(dead[0].ID)
+	if err != nil || purged != 1 {
+		t.Fatalf("purge of %s: %d %v", dead[0].ID, purged, err)
+	}
+	d = newTestDispatcher(t, outboxPath, server.URL)
+	if remaining := d.DeadLetters(); len(remaining) != 2 || remaining[0].ID != dead[1].ID {
+		t.Fatalf("unexpected dead letters after the purge %+v", remaining)
+	}
+
+	// the dead letters older than the retention are purged by the dispatcher
+	d.mu.Lock()
+	d.deliveries[0].DeadAt = time.Now().Add(-deadLetterRetention - time.Minute)
+	d.mu.Unlock()
+	d.dispatchDue()
+	if remaining := d.DeadLetters(); len(remaining) != 1 || remaining[0].ID != dead[2].ID {
+		t.Errorf("unexpected dead letters after the retention %+v", remaining)
+	}
+	if rc.count() != 0 {
+		t.Errorf("expected no delivery, got %d", rc.count())
+	}
+}
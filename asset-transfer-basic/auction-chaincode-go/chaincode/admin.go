@@ -0,0 +1,93 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// PriceRecord is one version of the unit price of a small category
+type PriceRecord struct {
+	TxID      string    `json:"TxID"`
+	Timestamp time.Time `json:"Timestamp"`
+	UnitPrice float64   `json:"Unit Price"`
+	IsDelete  bool      `json:"IsDelete"`
+}
+
+// GetPriceHistory returns the unit prices of a small category, newest first.
+func (s *SmartContract) GetPriceHistory(ctx contractapi.TransactionContextInterface, smallCategory string) ([]*PriceRecord, error) {
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(smallCategory + "-power-cost")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var records []*PriceRecord
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		record := PriceRecord{
+			TxID:      response.TxId,
+			Timestamp: time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)),
+			IsDelete:  response.IsDelete,
+		}
+		if len(response.Value) > 0 {
+			var cost Energy
+			err = json.Unmarshal(response.Value, &cost)
+			if err != nil {
+				return nil, err
+			}
+			record.UnitPrice = cost.UnitPrice
+		}
+		records = append(records, &record)
+	}
+
+	return records, nil
+}
+
+// GetEndorsementPolicy returns the orgs whose peers must endorse changes to a key. An
+// empty list means that the chaincode endorsement policy applies.
+func (s *SmartContract) GetEndorsementPolicy(ctx contractapi.TransactionContextInterface, key string) ([]string, error) {
+	policy, err := ctx.GetStub().GetStateValidationParameter(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get validation parameter of %s: %v", key, err)
+	}
+	if len(policy) == 0 {
+		return []string{}, nil
+	}
+
+	endorsementPolicy, err := statebased.NewStateEP(policy)
+	if err != nil {
+		return nil, err
+	}
+	return endorsementPolicy.ListOrgs(), nil
+}
+
+// SetEndorsementPolicy requires the peers of all orgs to endorse changes to a token, or
+// restores the chaincode endorsement policy if orgs is empty. Only a market admin can
+// set it, and the change itself must satisfy the current policy of the token.
+func (s *SmartContract) SetEndorsementPolicy(ctx contractapi.TransactionContextInterface, key string, orgs []string) error {
+	err := ctx.GetClientIdentity().AssertAttributeValue(marketAdminAttribute, "true")
+	if err != nil {
+		return fmt.Errorf("client is not a market admin: %v", err)
+	}
+	// the unit prices and the other documents keep the policies set by the chaincode
+	energy, err := s.ReadToken(ctx, key)
+	if err != nil {
+		return err
+	}
+	if energy.DocType != "token" {
+		return fmt.Errorf("the key %s is not a token", key)
+	}
+
+	if len(orgs) == 0 {
+		return ctx.GetStub().SetStateValidationParameter(key, nil)
+	}
+	return setStateBasedEndorsement(ctx, key, orgs...)
+}
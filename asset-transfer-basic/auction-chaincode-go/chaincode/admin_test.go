@@ -0,0 +1,24 @@
+package chaincode_test
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnlyAMarketAdminSetsTheEndorsementPolicyOfAToken(t *testing.T) {
+	l := newTestLedger(t)
+	createToken(t, l, "energy1")
+	auction := &chaincode.SmartContract{}
+
+	err := auction.SetEndorsementPolicy(l.tx(producer, nil), "energy1", []string{"Org1MSP"})
+	require.EqualError(t, err, "client is not a market admin: attribute 'market.admin' was not found")
+	// the unit prices stay endorsed by the chaincode policy
+	err = auction.SetEndorsementPolicy(l.tx(admin, nil), "solar-power-cost", []string{"Org2MSP"})
+	require.EqualError(t, err, "the key solar-power-cost is not a token")
+
+	ctx := l.tx(admin, nil)
+	require.NoError(t, auction.SetEndorsementPolicy(ctx, "energy1", []string{"Org1MSP", "Org2MSP"}))
+	require.Equal(t, "Org1MSP Org2MSP", endorsingOrgs(ctx, "energy1"))
+}
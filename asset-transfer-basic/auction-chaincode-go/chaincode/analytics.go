@@ -0,0 +1,167 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// analyticsCellPrecision is the geohash precision of the cells of a market report (about 5km)
+const analyticsCellPrecision = 5
+
+// maxUTCOffset is the largest UTC offset of a time zone. The stored times keep the offset
+// of the client, so their text differs from the UTC time by up to this much.
+const maxUTCOffset = 14 * time.Hour
+
+// MarketStats summarizes the tokens generated in a time range for one category, hour
+// or geographic cell. Tokens still in auction count as Open and are left out of the
+// rates.
+type MarketStats struct {
+	Key                  string  `json:"Key"`
+	Sold                 int     `json:"Sold"`
+	Unsold               int     `json:"Unsold"`
+	Open                 int     `json:"Open"`
+	Volume               float64 `json:"Volume"` // kWh sold
+	AverageClearingPrice float64 `json:"Average Clearing Price"`
+	UnsoldRate           float64 `json:"Unsold Rate"`
+}
+
+// QueryMarketStats returns the market statistics of the tokens generated in [from, to),
+// grouped by "category", "hour" or "cell". The times are compared as instants, whatever
+// the UTC offsets of from, to and the tokens.
+func (s *SmartContract) QueryMarketStats(ctx contractapi.TransactionContextInterface,
+	groupBy string, from time.Time, to time.Time) ([]*MarketStats, error) {
+	timeRange, err := generatedTimeRange(from, to)
+	if err != nil {
+		return nil, err
+	}
+	queryString := fmt.Sprintf(`{"selector":{"DocType":"token","Generated Time":%s},"use_index":["_design/indexGeneratedTimeDoc","indexGeneratedTime"]}`,
+		timeRange)
+	return s.marketStats(ctx, queryString, groupBy, from, to)
+}
+
+// QueryMarketStatsForCategory returns the market statistics of the tokens of one small
+// category generated in [from, to).
+func (s *SmartContract) QueryMarketStatsForCategory(ctx contractapi.TransactionContextInterface,
+	smallCategory string, groupBy string, from time.Time, to time.Time) ([]*MarketStats, error) {
+	timeRange, err := generatedTimeRange(from, to)
+	if err != nil {
+		return nil, err
+	}
+	queryString := fmt.Sprintf(`{"selector":{"DocType":"token","SmallCategory":"%s","Generated Time":%s},"use_index":["_design/indexCategoryDoc","indexCategory"]}`,
+		smallCategory, timeRange)
+	return s.marketStats(ctx, queryString, groupBy, from, to)
+}
+
+// marketStats summarizes the tokens of the query generated in [from, to).
+func (s *SmartContract) marketStats(ctx contractapi.TransactionContextInterface, queryString string, groupBy string,
+	from time.Time, to time.Time) ([]*MarketStats, error) {
+	groupKey, err := marketGroupKey(groupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	statsByKey := map[string]*MarketStats{}
+	clearingPrices := map[string]float64{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var energy Energy
+		err = json.Unmarshal(queryResponse.Value, &energy)
+		if err != nil {
+			return nil, err
+		}
+		if energy.GeneratedTime.Before(from) || !energy.GeneratedTime.Before(to) {
+			continue
+		}
+
+		key := groupKey(&energy)
+		stats, ok := statsByKey[key]
+		if !ok {
+			stats = &MarketStats{Key: key}
+			statsByKey[key] = stats
+		}
+		switch energy.Status {
+		case "sold", "resale", "consumed":
+			quantity, err := energyQuantity(ctx, &energy)
+			if err != nil {
+				return nil, err
+			}
+			stats.Sold++
+			stats.Volume += quantity
+			clearingPrices[key] += clearingPrice(&energy)
+		case "old":
+			stats.Unsold++
+		case "generated", "pending":
+			stats.Open++
+		}
+	}
+
+	var result []*MarketStats
+	for key, stats := range statsByKey {
+		if stats.Sold > 0 {
+			stats.AverageClearingPrice = clearingPrices[key] / float64(stats.Sold)
+		}
+		if closed := stats.Sold + stats.Unsold; closed > 0 {
+			stats.UnsoldRate = float64(stats.Unsold) / float64(closed)
+		}
+		result = append(result, stats)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result, nil
+}
+
+func marketGroupKey(groupBy string) (func(energy *Energy) string, error) {
+	switch groupBy {
+	case "category":
+		return func(energy *Energy) string { return energy.SmallCategory }, nil
+	case "hour":
+		return func(energy *Energy) string {
+			return energy.GeneratedTime.Truncate(time.Hour).Format(time.RFC3339)
+		}, nil
+	case "cell":
+		return func(energy *Energy) string {
+			if len(energy.Geohash) >= analyticsCellPrecision {
+				return energy.Geohash[:analyticsCellPrecision]
+			}
+			return encodeGeohash(energy.Latitude, energy.Longitude, analyticsCellPrecision)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown groupBy %s; use category, hour or cell", groupBy)
+}
+
+// clearingPrice returns the price of the first sale of a token; later resales change
+// its bid price.
+func clearingPrice(energy *Energy) float64 {
+	if len(energy.Transfers) > 0 {
+		return energy.Transfers[0].Price
+	}
+	return energy.BidPrice
+}
+
+// generatedTimeRange returns a selector on the stored times that holds [from, to). The
+// times are stored as text with the offset of the client, so the range is widened by
+// maxUTCOffset, and the tokens are then compared with from and to as instants.
+func generatedTimeRange(from time.Time, to time.Time) (string, error) {
+	fromJSON, err := json.Marshal(from.UTC().Add(-maxUTCOffset))
+	if err != nil {
+		return "", err
+	}
+	toJSON, err := json.Marshal(to.UTC().Add(maxUTCOffset))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`{"$gte":%s,"$lt":%s}`, fromJSON, toJSON), nil
+}
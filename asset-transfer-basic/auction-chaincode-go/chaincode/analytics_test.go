@@ -0,0 +1,64 @@
+package chaincode_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryMarketStatsCountsTheClosedAndOpenTokens(t *testing.T) {
+	l := newTestLedger(t)
+	createToken(t, l, "energy1")
+	createToken(t, l, "energy2")
+	l.now = start.Add(time.Minute)
+	require.Equal(t, "your bid was successful", bid(t, l, "energy1", 0.03))
+	l.now = start.Add(30 * time.Minute)
+	sweep(t, l)
+	// still in auction
+	createToken(t, l, "energy3")
+
+	auction := &chaincode.SmartContract{}
+	stats, err := auction.QueryMarketStats(l.tx(consumer, nil), "category", start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, []*chaincode.MarketStats{{
+		Key: "solar", Sold: 1, Unsold: 1, Open: 1, Volume: 1, AverageClearingPrice: 0.03, UnsoldRate: 0.5,
+	}}, stats)
+
+	stats, err = auction.QueryMarketStats(l.tx(consumer, nil), "hour", start, start.Add(30*time.Minute))
+	require.NoError(t, err)
+	require.Len(t, stats, 1)
+	require.Equal(t, "2022-11-06T16:00:00Z", stats[0].Key)
+	require.Equal(t, 0, stats[0].Open, "energy3 was generated after the range")
+
+	stats, err = auction.QueryMarketStatsForCategory(l.tx(consumer, nil), "solar", "cell", start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, stats, 1)
+	require.Len(t, stats[0].Key, 5)
+	require.Equal(t, 3, stats[0].Sold+stats[0].Unsold+stats[0].Open)
+
+	stats, err = auction.QueryMarketStatsForCategory(l.tx(consumer, nil), "wind", "category", start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, stats)
+
+	_, err = auction.QueryMarketStats(l.tx(consumer, nil), "producer", start, start.Add(time.Hour))
+	require.EqualError(t, err, "unknown groupBy producer; use category, hour or cell")
+}
+
+func TestQueryMarketStatsComparesTheTimesAcrossOffsets(t *testing.T) {
+	l := newTestLedger(t)
+	auction := &chaincode.SmartContract{}
+	jst := time.FixedZone("JST", 9*60*60)
+	for id, generated := range map[string]time.Time{"energy1": start, "energy2": start.Add(-time.Hour)} {
+		ctx := l.tx(producer, nil)
+		require.NoError(t, auction.CreateToken(ctx, id, 35.5, 139.6, "User1", "green", "solar", generated.In(jst)))
+		l.commit(ctx)
+	}
+
+	// the tokens are stored with +09:00, the range is given in UTC
+	stats, err := auction.QueryMarketStats(l.tx(consumer, nil), "category", start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, stats, 1)
+	require.Equal(t, 1, stats[0].Open)
+}
@@ -0,0 +1,201 @@
+package chaincode
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxBulkItems bounds the size of the read-write set of a bulk transaction
+const maxBulkItems = 50
+
+// TokenSpec is a token to issue with CreateTokens
+type TokenSpec struct {
+	ID            string  `json:"ID"`
+	Latitude      float64 `json:"Latitude"`
+	Longitude     float64 `json:"Longitude"`
+	LargeCategory string  `json:"LargeCategory"`
+	SmallCategory string  `json:"SmallCategory"`
+}
+
+// BidSpec is a bid to place with BidOnTokens
+type BidSpec struct {
+	ID       string  `json:"ID"`
+	BidPrice float64 `json:"Bid Price"` // per kWh
+}
+
+// BulkResult is the result of one item of a bulk transaction. Message is what the
+// single-item transaction returns, and Error why the item failed; the other items of
+// the transaction are processed regardless. An item that fails while writing to the
+// ledger fails the whole transaction instead, since its writes cannot be taken back.
+type BulkResult struct {
+	ID      string `json:"ID"`
+	Message string `json:"Message"`
+	Error   string `json:"Error"`
+}
+
+// CreateTokens issues the tokens of producer generated at timestamp in one transaction,
+// as CreateToken does for each of them, within the quota of the client. The reserve
+// price in the transient map, if any, applies to all the tokens.
+// 複数トークンの一括発行
+func (s *SmartContract) CreateTokens(ctx contractapi.TransactionContextInterface,
+	producer string, tokens []TokenSpec, timestamp time.Time) ([]BulkResult, error) {
+	if err := checkBulkSize(len(tokens)); err != nil {
+		return nil, err
+	}
+	ctx, writes := trackWrites(ctx)
+
+	quota, err := newClientQuota(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// an invalid reserve price fails the transaction rather than every item
+	reserve, err := getReserve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]BulkResult, len(tokens))
+	seen := map[string]bool{}
+	for i, token := range tokens {
+		results[i].ID = token.ID
+		// GetState does not return the writes of the transaction itself
+		if seen[token.ID] {
+			results[i].Error = fmt.Sprintf("the energy %s is issued twice", token.ID)
+			continue
+		}
+		seen[token.ID] = true
+
+		writes.wrote = false
+		err := s.createToken(ctx, quota, reserve, token.ID, token.Latitude, token.Longitude, producer,
+			token.LargeCategory, token.SmallCategory, timestamp)
+		if err != nil && writes.wrote {
+			return nil, fmt.Errorf("the energy %s failed while writing: %v", token.ID, err)
+		}
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Message = "the energy " + token.ID + " was created"
+	}
+	return results, nil
+}
+
+// BidOnTokens places the bids of newOwner in one transaction. With a location in the
+// transient map each bid is placed as BidOnTokenPrivate does, otherwise as BidOnToken.
+// 複数トークンへの一括入札
+func (s *SmartContract) BidOnTokens(ctx contractapi.TransactionContextInterface,
+	newOwner string, bids []BidSpec, timestamp time.Time) ([]BulkResult, error) {
+	if err := checkBulkSize(len(bids)); err != nil {
+		return nil, err
+	}
+	ctx, writes := trackWrites(ctx)
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("error getting transient: %v", err)
+	}
+	var location *BidLocation
+	if _, ok := transientMap[locationTransientKey]; ok {
+		location, err = getBidLocation(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	quota, err := newClientQuota(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]BulkResult, len(bids))
+	seen := map[string]bool{}
+	for i, bid := range bids {
+		results[i].ID = bid.ID
+		if seen[bid.ID] {
+			results[i].Error = fmt.Sprintf("the energy %s is bid on twice", bid.ID)
+			continue
+		}
+		seen[bid.ID] = true
+
+		bid := bid
+		writes.wrote = false
+		message, err := quota.bid(bid.ID, newOwner, func() (string, error) {
+			if location != nil {
+				return s.bidPrivate(ctx, location, bid.ID, newOwner, bid.BidPrice, timestamp)
+			}
+			return s.bidOnToken(ctx, bid.ID, newOwner, bid.BidPrice, timestamp)
+		})
+		if err != nil && writes.wrote {
+			return nil, fmt.Errorf("the bid on energy %s failed while writing: %v", bid.ID, err)
+		}
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Message = message
+	}
+	return results, nil
+}
+
+func checkBulkSize(n int) error {
+	if n == 0 {
+		return fmt.Errorf("no items are given")
+	}
+	if n > maxBulkItems {
+		return fmt.Errorf("%d items exceed the limit of %d per transaction", n, maxBulkItems)
+	}
+	return nil
+}
+
+// writeTracker records whether the transaction wrote to the ledger since wrote was last
+// reset.
+type writeTracker struct {
+	shim.ChaincodeStubInterface
+	wrote bool
+}
+
+func (w *writeTracker) PutState(key string, value []byte) error {
+	w.wrote = true
+	return w.ChaincodeStubInterface.PutState(key, value)
+}
+
+func (w *writeTracker) DelState(key string) error {
+	w.wrote = true
+	return w.ChaincodeStubInterface.DelState(key)
+}
+
+func (w *writeTracker) SetStateValidationParameter(key string, ep []byte) error {
+	w.wrote = true
+	return w.ChaincodeStubInterface.SetStateValidationParameter(key, ep)
+}
+
+func (w *writeTracker) PutPrivateData(collection string, key string, value []byte) error {
+	w.wrote = true
+	return w.ChaincodeStubInterface.PutPrivateData(collection, key, value)
+}
+
+func (w *writeTracker) DelPrivateData(collection string, key string) error {
+	w.wrote = true
+	return w.ChaincodeStubInterface.DelPrivateData(collection, key)
+}
+
+func (w *writeTracker) SetPrivateDataValidationParameter(collection string, key string, ep []byte) error {
+	w.wrote = true
+	return w.ChaincodeStubInterface.SetPrivateDataValidationParameter(collection, key, ep)
+}
+
+type trackedContext struct {
+	contractapi.TransactionContextInterface
+	stub *writeTracker
+}
+
+func (c *trackedContext) GetStub() shim.ChaincodeStubInterface {
+	return c.stub
+}
+
+// trackWrites returns ctx with a stub that records the writes of the transaction.
+func trackWrites(ctx contractapi.TransactionContextInterface) (contractapi.TransactionContextInterface, *writeTracker) {
+	writes := &writeTracker{ChaincodeStubInterface: ctx.GetStub()}
+	return &trackedContext{TransactionContextInterface: ctx, stub: writes}, writes
+}
@@ -0,0 +1,64 @@
+package chaincode_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateTokensFailsWhenAnItemFailsAfterItsWrite(t *testing.T) {
+	l := newTestLedger(t)
+	auction := &chaincode.SmartContract{}
+	tokens := []chaincode.TokenSpec{
+		{ID: "energy1", Latitude: 35.5, Longitude: 139.6, LargeCategory: "green", SmallCategory: "solar"},
+		{ID: "energy2", Latitude: 35.5, Longitude: 139.6, LargeCategory: "green", SmallCategory: "solar"},
+	}
+
+	// the token energy2 is written before its endorsement policy fails
+	ctx := l.tx(producer, nil)
+	ctx.stub.SetStateValidationParameterStub = func(key string, ep []byte) error {
+		if key == "energy2" {
+			return errors.New("peer unavailable")
+		}
+		return nil
+	}
+	_, err := auction.CreateTokens(ctx, "User1", tokens, l.now)
+	require.EqualError(t, err, "the energy energy2 failed while writing: failed to set validation parameter on energy2: peer unavailable")
+
+	// an item that fails before writing is only reported
+	tokens[1].SmallCategory = "unknown"
+	ctx = l.tx(producer, nil)
+	results, err := auction.CreateTokens(ctx, "User1", tokens, l.now)
+	require.NoError(t, err)
+	require.Equal(t, "the energy energy1 was created", results[0].Message)
+	require.NotEmpty(t, results[1].Error)
+}
+
+func TestBidOnTokensFailsWhenABidFailsAfterItsWrite(t *testing.T) {
+	l := newTestLedger(t)
+	auction := &chaincode.SmartContract{}
+	createToken(t, l, "energy1")
+	createToken(t, l, "energy2")
+	l.now = start.Add(time.Minute)
+	bids := []chaincode.BidSpec{{ID: "energy1", BidPrice: 0.03}, {ID: "energy2", BidPrice: 0.03}}
+
+	ctx := l.tx(consumer, nil)
+	putState := ctx.stub.PutStateStub
+	ctx.stub.PutStateStub = func(key string, value []byte) error {
+		if key == "energy2" {
+			return errors.New("peer unavailable")
+		}
+		return putState(key, value)
+	}
+	_, err := auction.BidOnTokens(ctx, "User2", bids, l.now)
+	require.EqualError(t, err, "the bid on energy energy2 failed while writing: peer unavailable")
+
+	ctx = l.tx(consumer, nil)
+	results, err := auction.BidOnTokens(ctx, "User2", bids, l.now)
+	require.NoError(t, err)
+	require.Equal(t, "your bid was successful", results[0].Message)
+	require.Equal(t, "your bid was successful", results[1].Message)
+}
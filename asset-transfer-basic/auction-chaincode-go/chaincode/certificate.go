@@ -0,0 +1,230 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// tokenQuantity is the quantity of a token without a generation reading
+const tokenQuantity = 1.0 // kWh
+
+// the certificates are kept under composite keys, apart from the token IDs chosen by
+// the producers
+const certificateObjectType = "certificate"
+
+// Certificate is a non-fungible guarantee of origin for a sold green token. It is
+// issued to the buyer and can be transferred until it is retired, i.e. claimed for the
+// consumption of a beneficiary.
+type Certificate struct {
+	DocType       string    `json:"DocType"`
+	ID            string    `json:"ID"`
+	TokenID       string    `json:"Token ID"`
+	Owner         string    `json:"Owner"`
+	Producer      string    `json:"Producer"`
+	SmallCategory string    `json:"SmallCategory"`
+	GeneratedTime time.Time `json:"Generated Time"`
+	Quantity      float64   `json:"Quantity"` // kWh
+	IssuedTime    time.Time `json:"Issued Time"`
+	Status        string    `json:"Status"` // "issued" or "retired"
+	Beneficiary   string    `json:"Beneficiary,omitempty" metadata:"Beneficiary,optional"`
+	RetiredTime   time.Time `json:"Retired Time"`
+}
+
+// ReadCertificate returns the certificate stored in the world state with given id.
+func (s *SmartContract) ReadCertificate(ctx contractapi.TransactionContextInterface, id string) (*Certificate, error) {
+	key, err := certificateKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	certificateJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if certificateJSON == nil {
+		return nil, fmt.Errorf("the certificate %s does not exist", id)
+	}
+
+	var certificate Certificate
+	err = json.Unmarshal(certificateJSON, &certificate)
+	if err != nil {
+		return nil, err
+	}
+	if certificate.DocType != "certificate" {
+		return nil, fmt.Errorf("%s is not a certificate", id)
+	}
+	return &certificate, nil
+}
+
+// TransferCertificate gives an issued certificate to another user.
+func (s *SmartContract) TransferCertificate(ctx contractapi.TransactionContextInterface, id string, from string, to string) error {
+	if err := checkClientIs(ctx, from); err != nil {
+		return err
+	}
+	certificate, err := s.ReadCertificate(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err = checkIssuedTo(certificate, from); err != nil {
+		return err
+	}
+	if to == "" || to == from {
+		return fmt.Errorf("invalid new owner %q", to)
+	}
+
+	certificate.Owner = to
+	return s.putCertificate(ctx, certificate)
+}
+
+// RetireCertificate claims a certificate for the consumption of beneficiary. A retired
+// certificate cannot be transferred or claimed again.
+func (s *SmartContract) RetireCertificate(ctx contractapi.TransactionContextInterface,
+	id string, owner string, beneficiary string, timestamp time.Time) error {
+	if err := checkClientIs(ctx, owner); err != nil {
+		return err
+	}
+	certificate, err := s.ReadCertificate(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err = checkIssuedTo(certificate, owner); err != nil {
+		return err
+	}
+	if beneficiary == "" {
+		beneficiary = owner
+	}
+
+	certificate.Status = "retired"
+	certificate.Beneficiary = beneficiary
+	certificate.RetiredTime = timestamp
+	return s.putCertificate(ctx, certificate)
+}
+
+// QueryCertificatesByOwner returns the certificates of owner.
+func (s *SmartContract) QueryCertificatesByOwner(ctx contractapi.TransactionContextInterface, owner string) ([]*Certificate, error) {
+	queryString := fmt.Sprintf(`{"selector":{"DocType":"certificate","Owner":"%s"},"use_index":["_design/indexOwnerDoc","indexOwner"]}`, owner)
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var certificates []*Certificate
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var certificate Certificate
+		err = json.Unmarshal(queryResponse.Value, &certificate)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, &certificate)
+	}
+
+	return certificates, nil
+}
+
+// issueCertificate issues the certificate of a green token that was just sold.
+func (s *SmartContract) issueCertificate(ctx contractapi.TransactionContextInterface, energy *Energy, timestamp time.Time) error {
+	if energy.Status != "sold" || energy.LargeCategory != "green" {
+		return nil
+	}
+	id := certificateID(energy.ID)
+	key, err := certificateKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if existing != nil {
+		var certificate Certificate
+		err = json.Unmarshal(existing, &certificate)
+		if err != nil {
+			return err
+		}
+		if certificate.DocType != "certificate" || certificate.TokenID != energy.ID {
+			return fmt.Errorf("the key of the certificate %s holds another record", id)
+		}
+		return nil
+	}
+
+	quantity, err := energyQuantity(ctx, energy)
+	if err != nil {
+		return err
+	}
+
+	certificate := Certificate{
+		DocType:       "certificate",
+		ID:            id,
+		TokenID:       energy.ID,
+		Owner:         energy.Owner,
+		Producer:      energy.Producer,
+		SmallCategory: energy.SmallCategory,
+		GeneratedTime: energy.GeneratedTime,
+		Quantity:      quantity,
+		IssuedTime:    timestamp,
+		Status:        "issued",
+	}
+	return s.putCertificate(ctx, &certificate)
+}
+
+func (s *SmartContract) putCertificate(ctx contractapi.TransactionContextInterface, certificate *Certificate) error {
+	certificateJSON, err := json.Marshal(certificate)
+	if err != nil {
+		return err
+	}
+	key, err := certificateKey(ctx, certificate.ID)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, certificateJSON)
+}
+
+func checkIssuedTo(certificate *Certificate, owner string) error {
+	if certificate.Status != "issued" {
+		return fmt.Errorf("the certificate %s was already retired", certificate.ID)
+	}
+	if certificate.Owner != owner {
+		return fmt.Errorf("the certificate %s is not owned by %s", certificate.ID, owner)
+	}
+	return nil
+}
+
+// energyQuantity returns the generation reading of a token, or tokenQuantity if the
+// token has no reading.
+func energyQuantity(ctx contractapi.TransactionContextInterface, energy *Energy) (float64, error) {
+	key, err := meterReadingKey(ctx, energy.ID, "generation")
+	if err != nil {
+		return 0, err
+	}
+	readingJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if readingJSON == nil {
+		return tokenQuantity, nil
+	}
+
+	var reading MeterReading
+	err = json.Unmarshal(readingJSON, &reading)
+	if err != nil {
+		return 0, err
+	}
+	return reading.Amount, nil
+}
+
+func certificateID(tokenID string) string {
+	return tokenID + "-certificate"
+}
+
+func certificateKey(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(certificateObjectType, []string{id})
+}
@@ -0,0 +1,52 @@
+package chaincode_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnlyTheOwnerTransfersOrRetiresTheCertificate(t *testing.T) {
+	l := soldToken(t)
+	auction := &chaincode.SmartContract{}
+
+	ctx := l.tx(other, nil)
+	err := auction.TransferCertificate(ctx, "energy1-certificate", "User2", "User3")
+	require.EqualError(t, err, "the client is not User2")
+	err = auction.RetireCertificate(ctx, "energy1-certificate", "User2", "", l.now)
+	require.EqualError(t, err, "the client is not User2")
+
+	ctx = l.tx(consumer, nil)
+	require.NoError(t, auction.TransferCertificate(ctx, "energy1-certificate", "User2", "User3"))
+	l.commit(ctx)
+
+	ctx = l.tx(other, nil)
+	require.NoError(t, auction.RetireCertificate(ctx, "energy1-certificate", "User3", "", l.now))
+	l.commit(ctx)
+	certificate, err := auction.ReadCertificate(l.tx(other, nil), "energy1-certificate")
+	require.NoError(t, err)
+	require.Equal(t, "retired", certificate.Status)
+	require.Equal(t, "User3", certificate.Beneficiary)
+
+	ctx = l.tx(other, nil)
+	err = auction.RetireCertificate(ctx, "energy1-certificate", "User3", "", l.now)
+	require.EqualError(t, err, "the certificate energy1-certificate was already retired")
+}
+
+func TestATokenNamedAfterTheCertificateDoesNotSuppressIt(t *testing.T) {
+	l := newTestLedger(t)
+	createToken(t, l, "energy1-certificate")
+	createToken(t, l, "energy1")
+	l.now = start.Add(time.Minute)
+	bid(t, l, "energy1", 0.03)
+	l.now = start.Add(5 * time.Minute)
+	sweep(t, l)
+
+	certificate, err := (&chaincode.SmartContract{}).ReadCertificate(l.tx(consumer, nil), "energy1-certificate")
+	require.NoError(t, err)
+	require.Equal(t, "energy1", certificate.TokenID)
+	require.Equal(t, "User2", certificate.Owner)
+	require.Equal(t, "token", l.token("energy1-certificate").DocType)
+}
@@ -0,0 +1,172 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// PriceMultiplier is a demand-response event: between Start and End, the prices of the
+// tokens of a zone and small category are multiplied by Multiplier, above 1 for a surge
+// and below 1 for a discount. An empty zone or category applies to all of them.
+type PriceMultiplier struct {
+	DocType       string    `json:"DocType"`
+	ID            string    `json:"ID"`
+	Zone          string    `json:"Zone"`
+	SmallCategory string    `json:"SmallCategory"`
+	Multiplier    float64   `json:"Multiplier"`
+	Start         time.Time `json:"Start"`
+	End           time.Time `json:"End"`
+	Reason        string    `json:"Reason"`
+}
+
+// the price multipliers are kept under composite keys, so that they are read as a key
+// range rather than with a query
+const priceMultiplierObjectType = "priceMultiplier"
+
+func priceMultiplierKey(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(priceMultiplierObjectType, []string{id})
+}
+
+// PostPriceMultiplier posts a demand-response event, as the operator does with the unit
+// prices. Posting an event with the ID of another one replaces it. Only a market admin
+// can post it.
+// デマンドレスポンスによる価格倍率 (ゾーン、カテゴリごと、期限付き)
+func (s *SmartContract) PostPriceMultiplier(ctx contractapi.TransactionContextInterface,
+	id string, zone string, smallCategory string, multiplier float64, start time.Time, end time.Time, reason string) error {
+	err := ctx.GetClientIdentity().AssertAttributeValue(marketAdminAttribute, "true")
+	if err != nil {
+		return fmt.Errorf("client is not a market admin: %v", err)
+	}
+	if id == "" {
+		return fmt.Errorf("the ID of the price multiplier is required")
+	}
+	if multiplier <= 0 {
+		return fmt.Errorf("the multiplier must be positive")
+	}
+	if !end.After(start) {
+		return fmt.Errorf("the price multiplier must end after it starts")
+	}
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	if !end.After(timestamp) {
+		return fmt.Errorf("the price multiplier %s has already ended", id)
+	}
+
+	priceMultiplier := PriceMultiplier{
+		DocType:       "priceMultiplier",
+		ID:            id,
+		Zone:          zone,
+		SmallCategory: smallCategory,
+		Multiplier:    multiplier,
+		Start:         start,
+		End:           end,
+		Reason:        reason,
+	}
+	priceMultiplierJSON, err := json.Marshal(priceMultiplier)
+	if err != nil {
+		return err
+	}
+	key, err := priceMultiplierKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, priceMultiplierJSON)
+}
+
+// GetPriceMultipliers returns the price multipliers that have not been swept, ordered by
+// start.
+func (s *SmartContract) GetPriceMultipliers(ctx contractapi.TransactionContextInterface) ([]*PriceMultiplier, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(priceMultiplierObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	priceMultipliers := []*PriceMultiplier{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var priceMultiplier PriceMultiplier
+		err = json.Unmarshal(queryResponse.Value, &priceMultiplier)
+		if err != nil {
+			return nil, err
+		}
+		priceMultipliers = append(priceMultipliers, &priceMultiplier)
+	}
+
+	sort.Slice(priceMultipliers, func(i, j int) bool {
+		if !priceMultipliers[i].Start.Equal(priceMultipliers[j].Start) {
+			return priceMultipliers[i].Start.Before(priceMultipliers[j].Start)
+		}
+		return priceMultipliers[i].ID < priceMultipliers[j].ID
+	})
+	return priceMultipliers, nil
+}
+
+// GetPriceMultiplier returns the multiplier of the prices of a zone and small category
+// at timestamp: the product of the events in effect, 1 if there are none.
+func (s *SmartContract) GetPriceMultiplier(ctx contractapi.TransactionContextInterface,
+	zone string, smallCategory string, timestamp time.Time) (float64, error) {
+	priceMultipliers, err := s.GetPriceMultipliers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	multiplier := 1.0
+	for _, priceMultiplier := range priceMultipliers {
+		if priceMultiplier.appliesTo(zone, smallCategory, timestamp) {
+			multiplier *= priceMultiplier.Multiplier
+		}
+	}
+	return multiplier, nil
+}
+
+func (p *PriceMultiplier) appliesTo(zone string, smallCategory string, timestamp time.Time) bool {
+	return (p.Zone == "" || p.Zone == zone) &&
+		(p.SmallCategory == "" || p.SmallCategory == smallCategory) &&
+		!timestamp.Before(p.Start) && timestamp.Before(p.End)
+}
+
+// unmultipliedPrice is the unit price of a token without the multiplier it was issued with.
+func unmultipliedPrice(energy *Energy) float64 {
+	if energy.Multiplier == 0 {
+		return energy.UnitPrice
+	}
+	return energy.UnitPrice / energy.Multiplier
+}
+
+// sweepPriceMultipliers deletes at most maxCount price multipliers that have ended.
+func (s *SmartContract) sweepPriceMultipliers(ctx contractapi.TransactionContextInterface,
+	timestamp time.Time, maxCount int) ([]string, error) {
+	priceMultipliers, err := s.GetPriceMultipliers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	expired := []string{}
+	for _, priceMultiplier := range priceMultipliers {
+		if len(expired) >= maxCount {
+			break
+		}
+		if timestamp.Before(priceMultiplier.End) {
+			continue
+		}
+		key, err := priceMultiplierKey(ctx, priceMultiplier.ID)
+		if err != nil {
+			return nil, err
+		}
+		err = ctx.GetStub().DelState(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete price multiplier %s: %v", priceMultiplier.ID, err)
+		}
+		expired = append(expired, priceMultiplier.ID)
+	}
+	return expired, nil
+}
@@ -0,0 +1,76 @@
+package chaincode_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+// postPriceMultiplier posts a multiplier of the solar prices for an hour from l.now.
+func postPriceMultiplier(t *testing.T, l *testLedger, id string, multiplier float64) {
+	t.Helper()
+	ctx := l.tx(admin, nil)
+	err := (&chaincode.SmartContract{}).PostPriceMultiplier(ctx, id, "", "solar", multiplier, l.now, l.now.Add(time.Hour), "test")
+	require.NoError(t, err)
+	l.commit(ctx)
+}
+
+func TestOnlyAMarketAdminPostsAPriceMultiplier(t *testing.T) {
+	l := newTestLedger(t)
+	err := (&chaincode.SmartContract{}).PostPriceMultiplier(l.tx(producer, nil), "peak", "", "solar", 1.5,
+		start, start.Add(time.Hour), "evening peak")
+	require.EqualError(t, err, "client is not a market admin: attribute 'market.admin' was not found")
+
+	postPriceMultiplier(t, l, "peak", 1.5)
+	multiplier, err := (&chaincode.SmartContract{}).GetPriceMultiplier(l.tx(consumer, nil), "", "solar", start)
+	require.NoError(t, err)
+	require.Equal(t, 1.5, multiplier)
+}
+
+func TestADiscountAfterTheIssueLowersTheFirstBid(t *testing.T) {
+	l := newTestLedger(t)
+	createToken(t, l, "energy1")
+	l.now = start.Add(time.Minute)
+	postPriceMultiplier(t, l, "discount", 0.5)
+
+	// the unit price at issue is 0.02, the demand price 0.01
+	require.Equal(t, "your bid price is cheap", bid(t, l, "energy1", 0.01))
+	require.Equal(t, "your bid was successful", bid(t, l, "energy1", 0.015))
+	// a later bid must still outbid the highest one
+	require.Equal(t, "your bid price is cheap", bid(t, l, "energy1", 0.012))
+	require.Equal(t, 0.015, l.token("energy1").BidPrice)
+}
+
+func TestTheScheduledPriceFollowsTheDemandResponseEvents(t *testing.T) {
+	l := newTestLedger(t)
+	createToken(t, l, "energy1")
+	ctx := l.tx(producer, nil)
+	require.NoError(t, (&chaincode.SmartContract{}).SetPriceSchedule(ctx, "energy1", "linear", 0.01, 0))
+	l.commit(ctx)
+
+	// halfway through the lifetime of the token, the scheduled 0.015 surges to 0.0225
+	l.now = start.Add(15 * time.Minute)
+	postPriceMultiplier(t, l, "peak", 1.5)
+	price, err := (&chaincode.SmartContract{}).CurrentPrice(l.tx(consumer, nil), "energy1")
+	require.NoError(t, err)
+	require.InDelta(t, 0.0225, price, 1e-12)
+
+	require.Equal(t, "your bid price is cheap", bid(t, l, "energy1", 0.015))
+	require.Equal(t, "your bid was successful", bid(t, l, "energy1", 0.0225))
+	require.Equal(t, "sold", l.token("energy1").Status)
+}
+
+func TestABackdatedBidPaysTheCurrentSurge(t *testing.T) {
+	l := newTestLedger(t)
+	createToken(t, l, "energy1")
+	l.now = start.Add(time.Minute)
+	postPriceMultiplier(t, l, "peak", 1.5)
+
+	// the bid claims to be placed before the surge, at the unit price at issue
+	ctx := l.tx(consumer, nil)
+	message, err := (&chaincode.SmartContract{}).BidOnToken(ctx, "energy1", "User2", 0.025, start)
+	require.NoError(t, err)
+	require.Equal(t, "your bid price is below the demand-response price 0.03", message)
+}
@@ -0,0 +1,68 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// setTokenEndorsement requires the peers of the producer's org to endorse changes to a
+// token, and also those of the bidder's org while it holds the highest bid or has won
+// the token, so that the peers of one org cannot rewrite the tokens of the other.
+// Tokens issued before the producer's org was recorded keep the chaincode policy.
+func setTokenEndorsement(ctx contractapi.TransactionContextInterface, energy *Energy) error {
+	if energy.ProducerMSP == "" {
+		return nil
+	}
+	orgs := []string{energy.ProducerMSP}
+	if energy.Owner != energy.Producer && energy.BidderMSP != "" && energy.BidderMSP != energy.ProducerMSP {
+		orgs = append(orgs, energy.BidderMSP)
+	}
+	return setStateBasedEndorsement(ctx, energy.ID, orgs...)
+}
+
+// setForwardEndorsement is setTokenEndorsement for a forward.
+func setForwardEndorsement(ctx contractapi.TransactionContextInterface, forward *Forward) error {
+	orgs := []string{forward.ProducerMSP}
+	if forward.Owner != forward.Producer && forward.BidderMSP != "" && forward.BidderMSP != forward.ProducerMSP {
+		orgs = append(orgs, forward.BidderMSP)
+	}
+	return setStateBasedEndorsement(ctx, forward.ID, orgs...)
+}
+
+// clientMSPID returns the org of the client submitting the transaction.
+func clientMSPID(ctx contractapi.TransactionContextInterface) (string, error) {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get verified MSPID: %v", err)
+	}
+	return mspID, nil
+}
+
+// checkClientIs returns an error unless the client is the user name, i.e. the common
+// name of its certificate is name. Owners are named by the enrollment IDs of the users,
+// which Fabric CA puts in the common name.
+func checkClientIs(ctx contractapi.TransactionContextInterface, name string) error {
+	certificate, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return fmt.Errorf("failed to get client certificate: %v", err)
+	}
+	if certificate == nil || certificate.Subject.CommonName != name {
+		return fmt.Errorf("the client is not %s", name)
+	}
+	return nil
+}
+
+// checkProducerOrg returns an error unless the client is of the producer's org, so that
+// only the producer can change the auction of its token. Tokens issued before the
+// producer's org was recorded are not checked.
+func checkProducerOrg(ctx contractapi.TransactionContextInterface, energy *Energy, action string) error {
+	mspID, err := clientMSPID(ctx)
+	if err != nil {
+		return err
+	}
+	if energy.ProducerMSP != "" && energy.ProducerMSP != mspID {
+		return fmt.Errorf("client from org %s is not authorized to %s the energy %s", mspID, action, energy.ID)
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// forwardAuctionLead is how long before its delivery window the auction of a forward closes
+	forwardAuctionLead = 30 // minutes
+	// forwardCollateralRate is the share of the value of a forward that the producer
+	// puts up as collateral
+	forwardCollateralRate = 0.5
+)
+
+// Forward is a token for energy that will be generated in a future delivery window.
+// The promised quantity is backed by a generation forecast, and the producer's
+// collateral covers the penalty for a shortfall found at settlement.
+// Status: "open" until the auction closes, then "sold" or "unsold"; a sold forward is
+// "settled" by the meter reading of its delivery.
+type Forward struct {
+	DocType          string    `json:"DocType"`
+	ID               string    `json:"ID"`
+	Producer         string    `json:"Producer"`
+	ProducerMSP      string    `json:"Producer MSP"`
+	Owner            string    `json:"Owner"`
+	BidderMSP        string    `json:"Bidder MSP,omitempty" metadata:"Bidder MSP,optional"`
+	LargeCategory    string    `json:"LargeCategory"`
+	SmallCategory    string    `json:"SmallCategory"`
+	Latitude         float64   `json:"Latitude"`
+	Longitude        float64   `json:"Longitude"`
+	Geohash          string    `json:"Geohash"`
+	Quantity         float64   `json:"Quantity"` // kWh promised
+	Forecast         float64   `json:"Forecast"` // kWh forecast for the delivery window
+	DeliveryStart    time.Time `json:"Delivery Start"`
+	DeliveryEnd      time.Time `json:"Delivery End"`
+	UnitPrice        float64   `json:"Unit Price"` // per kWh
+	BidPrice         float64   `json:"Bid Price"`  // per kWh
+	AuctionStartTime time.Time `json:"Auction Start Time"`
+	AuctionEndTime   time.Time `json:"Auction End Time"`
+	BidTime          time.Time `json:"Bid Time"`
+	Collateral       float64   `json:"Collateral"`
+	Status           string    `json:"Status"`
+	Delivered        float64   `json:"Delivered"` // kWh
+	Penalty          float64   `json:"Penalty"`
+	SettledTime      time.Time `json:"Settled Time"`
+}
+
+// CreateForward issues a forward for quantity kWh delivered between deliveryStart and
+// deliveryEnd. The quantity cannot exceed the forecast, and the auction closes
+// forwardAuctionLead minutes before the delivery window opens.
+// 予測発電量に基づく先渡しトークンの発行
+// 発行数はトークンと合わせてクライアントごとの上限 (SetMarketQuota) まで
+func (s *SmartContract) CreateForward(ctx contractapi.TransactionContextInterface,
+	id string, latitude float64, longitude float64, producer string, largeCategory string, smallCategory string,
+	quantity float64, forecast float64, deliveryStart time.Time, deliveryEnd time.Time, timestamp time.Time) error {
+	quota, err := newClientQuota(ctx)
+	if err != nil {
+		return err
+	}
+	if quantity <= 0 {
+		return fmt.Errorf("the quantity must be positive")
+	}
+	if quantity > forecast {
+		return fmt.Errorf("the quantity %v kWh exceeds the forecast %v kWh", quantity, forecast)
+	}
+	if !deliveryEnd.After(deliveryStart) {
+		return fmt.Errorf("the delivery window must end after it starts")
+	}
+	auctionEndTime := deliveryStart.Add(time.Minute * -forwardAuctionLead)
+	if !auctionEndTime.After(timestamp) {
+		return fmt.Errorf("the delivery window must start more than %dmin from now", forwardAuctionLead)
+	}
+
+	cost, err := s.ReadToken(ctx, smallCategory+"-power-cost")
+	if err != nil {
+		return err
+	}
+	exists, err := s.EnergyExists(ctx, id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("the energy %s already exists", id)
+	}
+	err = quota.checkToken()
+	if err != nil {
+		return err
+	}
+
+	producerMSP, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get verified MSPID: %v", err)
+	}
+
+	forward := Forward{
+		DocType:          "forward",
+		ID:               id,
+		Producer:         producer,
+		ProducerMSP:      producerMSP,
+		Owner:            producer,
+		LargeCategory:    largeCategory,
+		SmallCategory:    smallCategory,
+		Latitude:         latitude,
+		Longitude:        longitude,
+		Geohash:          encodeGeohash(latitude, longitude, tokenGeohashPrecision),
+		Quantity:         quantity,
+		Forecast:         forecast,
+		DeliveryStart:    deliveryStart,
+		DeliveryEnd:      deliveryEnd,
+		UnitPrice:        cost.UnitPrice,
+		BidPrice:         cost.UnitPrice,
+		AuctionStartTime: timestamp,
+		AuctionEndTime:   auctionEndTime,
+		Collateral:       quantity * cost.UnitPrice * forwardCollateralRate,
+		Status:           "open",
+	}
+	err = s.putForward(ctx, &forward)
+	if err != nil {
+		return err
+	}
+	err = setForwardEndorsement(ctx, &forward)
+	if err != nil {
+		return err
+	}
+	return quota.useToken()
+}
+
+// BidOnForward bids a price per kWh on an open forward.
+// 最高額の入札中のフォワードはトークンと合わせてクライアントごとの上限 (SetMarketQuota) まで
+func (s *SmartContract) BidOnForward(ctx contractapi.TransactionContextInterface,
+	id string, newOwner string, newBidPrice float64, timestamp time.Time) (string, error) {
+	quota, err := newClientQuota(ctx)
+	if err != nil {
+		return "", err
+	}
+	return quota.bid(id, newOwner, func() (string, error) {
+		return s.bidOnForward(ctx, id, newOwner, newBidPrice, timestamp)
+	})
+}
+
+func (s *SmartContract) bidOnForward(ctx contractapi.TransactionContextInterface,
+	id string, newOwner string, newBidPrice float64, timestamp time.Time) (string, error) {
+	forward, err := s.ReadForward(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if forward.Status != "open" {
+		return "the forward " + id + " is not for sale", nil
+	}
+	if newOwner == forward.Producer {
+		return "you cannot bid on your own forward", nil
+	}
+	if !timestamp.Before(forward.AuctionEndTime) {
+		return "the auction of forward " + id + " is closed", nil
+	}
+	if forward.BidPrice >= newBidPrice {
+		return "your bid price is cheap", nil
+	}
+	bidderMSP, err := clientMSPID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	forward.BidTime = timestamp
+	forward.Owner = newOwner
+	forward.BidPrice = newBidPrice
+	forward.BidderMSP = bidderMSP
+	err = s.putForward(ctx, forward)
+	if err != nil {
+		return "", err
+	}
+	// the bidder's org now endorses the changes to the forward
+	err = setForwardEndorsement(ctx, forward)
+	if err != nil {
+		return "", err
+	}
+	return "your bid was successful", nil
+}
+
+// ForwardAuctionEnd closes the auction of a forward once its end time is reached.
+func (s *SmartContract) ForwardAuctionEnd(ctx contractapi.TransactionContextInterface,
+	id string, producer string, timestamp time.Time) (string, error) {
+	forward, err := s.ReadForward(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if forward.Producer != producer {
+		return "", fmt.Errorf("the forward %s was not issued by %s", id, producer)
+	}
+
+	returnMessage, changed := closeForwardAuction(forward, timestamp)
+	if !changed {
+		return returnMessage, nil
+	}
+	err = s.putForward(ctx, forward)
+	if err != nil {
+		return "", err
+	}
+	return returnMessage, nil
+}
+
+// SettleForward records the delivery reading of a sold forward after its delivery
+// window and settles it: the shortfall against the promised quantity is charged at the
+// bid price, up to the collateral. Only the metering oracle can settle.
+func (s *SmartContract) SettleForward(ctx contractapi.TransactionContextInterface,
+	id string, meterID string, delivered float64, timestamp time.Time) (*Forward, error) {
+	oracle, err := s.requireMeterOracle(ctx)
+	if err != nil {
+		return nil, err
+	}
+	forward, err := s.ReadForward(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if delivered < 0 {
+		return nil, fmt.Errorf("the metered amount must not be negative")
+	}
+	if forward.Status != "sold" {
+		return nil, fmt.Errorf("the forward %s is %s", id, forward.Status)
+	}
+	if timestamp.Before(forward.DeliveryEnd) {
+		return nil, fmt.Errorf("the delivery window of forward %s is not over", id)
+	}
+
+	err = s.putMeterReading(ctx, oracle, id, "delivery", meterID, delivered, timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	shortfall := math.Max(0, forward.Quantity-delivered)
+	forward.Delivered = delivered
+	forward.Penalty = math.Min(forward.Collateral, shortfall*forward.BidPrice)
+	forward.Status = "settled"
+	forward.SettledTime = timestamp
+	err = s.putForward(ctx, forward)
+	if err != nil {
+		return nil, err
+	}
+	return forward, nil
+}
+
+// ReadForward returns the forward stored in the world state with given id.
+func (s *SmartContract) ReadForward(ctx contractapi.TransactionContextInterface, id string) (*Forward, error) {
+	forwardJSON, err := ctx.GetStub().GetState(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if forwardJSON == nil {
+		return nil, fmt.Errorf("the forward %s does not exist", id)
+	}
+
+	var forward Forward
+	err = json.Unmarshal(forwardJSON, &forward)
+	if err != nil {
+		return nil, err
+	}
+	if forward.DocType != "forward" {
+		return nil, fmt.Errorf("%s is not a forward", id)
+	}
+	return &forward, nil
+}
+
+// QueryForwardsByStatus returns the forwards with the given status.
+func (s *SmartContract) QueryForwardsByStatus(ctx contractapi.TransactionContextInterface, status string) ([]*Forward, error) {
+	queryString := fmt.Sprintf(`{"selector":{"DocType":"forward","Status":"%s"},"use_index":["_design/indexStatusDoc","indexStatus"]}`, status)
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var forwards []*Forward
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var forward Forward
+		err = json.Unmarshal(queryResponse.Value, &forward)
+		if err != nil {
+			return nil, err
+		}
+		forwards = append(forwards, &forward)
+	}
+
+	return forwards, nil
+}
+
+// closeForwardAuction closes the auction of an open forward whose end time is reached
+// and reports whether the forward was changed. The collateral of a sold forward is
+// raised to cover its bid price.
+func closeForwardAuction(forward *Forward, timestamp time.Time) (string, bool) {
+	if forward.Status != "open" {
+		return "the forward " + forward.ID + " is " + forward.Status, false
+	}
+	if timestamp.Before(forward.AuctionEndTime) {
+		return "Why did you call this function?", false
+	}
+
+	if forward.Owner == forward.Producer {
+		forward.Status = "unsold"
+		return "the forward " + forward.ID + " was not sold", true
+	}
+	forward.Status = "sold"
+	forward.Collateral = math.Max(forward.Collateral, forward.Quantity*forward.BidPrice*forwardCollateralRate)
+	return "the forward " + forward.ID + " was sold", true
+}
+
+func (s *SmartContract) putForward(ctx contractapi.TransactionContextInterface, forward *Forward) error {
+	forwardJSON, err := json.Marshal(forward)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(forward.ID, forwardJSON)
+}
@@ -0,0 +1,103 @@
+package chaincode_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+// createForward creates a forward of the producer for 10kWh, delivered an hour after l.now.
+func createForward(t *testing.T, l *testLedger, id string) {
+	t.Helper()
+	ctx := l.tx(producer, nil)
+	err := (&chaincode.SmartContract{}).CreateForward(ctx, id, 35.5, 139.6, "User1", "green", "solar",
+		10, 12, l.now.Add(time.Hour), l.now.Add(2*time.Hour), l.now)
+	require.NoError(t, err)
+	l.commit(ctx)
+}
+
+// soldForward returns a ledger where the consumer won forward1 at bidPrice per kWh.
+func soldForward(t *testing.T, bidPrice float64) *testLedger {
+	l := newTestLedger(t)
+	createForward(t, l, "forward1")
+	ctx := l.tx(consumer, nil)
+	message, err := (&chaincode.SmartContract{}).BidOnForward(ctx, "forward1", "User2", bidPrice, l.now)
+	require.NoError(t, err)
+	require.Equal(t, "your bid was successful", message)
+	l.commit(ctx)
+	require.Equal(t, "Org1MSP Org2MSP", endorsingOrgs(ctx, "forward1"))
+
+	l.now = start.Add(30 * time.Minute)
+	ctx = l.tx(producer, nil)
+	message, err = (&chaincode.SmartContract{}).ForwardAuctionEnd(ctx, "forward1", "User1", l.now)
+	require.NoError(t, err)
+	require.Equal(t, "the forward forward1 was sold", message)
+	l.commit(ctx)
+	return l
+}
+
+func TestForwardAuctionEndClosesTheAuctionAtItsEnd(t *testing.T) {
+	l := newTestLedger(t)
+	auction := &chaincode.SmartContract{}
+	ctx := l.tx(producer, nil)
+	require.NoError(t, auction.CreateForward(ctx, "forward1", 35.5, 139.6, "User1", "green", "solar",
+		10, 12, l.now.Add(time.Hour), l.now.Add(2*time.Hour), l.now))
+	l.commit(ctx)
+	require.Equal(t, "Org1MSP", endorsingOrgs(ctx, "forward1"))
+	createForward(t, l, "forward2")
+
+	l.now = start.Add(29 * time.Minute)
+	message, err := auction.ForwardAuctionEnd(l.tx(producer, nil), "forward1", "User1", l.now)
+	require.NoError(t, err)
+	require.Equal(t, "Why did you call this function?", message)
+	_, err = auction.ForwardAuctionEnd(l.tx(consumer, nil), "forward1", "User2", l.now)
+	require.EqualError(t, err, "the forward forward1 was not issued by User2")
+
+	// a forward without a bid is unsold
+	l.now = start.Add(30 * time.Minute)
+	ctx = l.tx(producer, nil)
+	message, err = auction.ForwardAuctionEnd(ctx, "forward2", "User1", l.now)
+	require.NoError(t, err)
+	require.Equal(t, "the forward forward2 was not sold", message)
+	l.commit(ctx)
+	forward, err := auction.ReadForward(l.tx(producer, nil), "forward2")
+	require.NoError(t, err)
+	require.Equal(t, "unsold", forward.Status)
+
+	// the collateral of a sold forward covers half its bid price
+	l = soldForward(t, 0.04)
+	forward, err = auction.ReadForward(l.tx(producer, nil), "forward1")
+	require.NoError(t, err)
+	require.Equal(t, "sold", forward.Status)
+	require.InDelta(t, 10*0.04*0.5, forward.Collateral, 1e-12)
+}
+
+func TestSettleForwardChargesTheShortfallUpToTheCollateral(t *testing.T) {
+	auction := &chaincode.SmartContract{}
+	for _, c := range []struct {
+		delivered float64
+		penalty   float64
+	}{
+		{10, 0},
+		{8, 2 * 0.04},
+		// the shortfall of 10 * 0.04 is capped at the collateral of 5 * 0.04
+		{0, 5 * 0.04},
+	} {
+		l := soldForward(t, 0.04)
+		ctx := l.tx(admin, nil)
+		require.NoError(t, auction.SetMeterOracle(ctx, "Org3MSP"))
+		l.commit(ctx)
+
+		l.now = start.Add(90 * time.Minute)
+		_, err := auction.SettleForward(l.tx(meter, nil), "forward1", "meter-1", c.delivered, l.now)
+		require.EqualError(t, err, "the delivery window of forward forward1 is not over")
+
+		l.now = start.Add(2 * time.Hour)
+		forward, err := auction.SettleForward(l.tx(meter, nil), "forward1", "meter-1", c.delivered, l.now)
+		require.NoError(t, err)
+		require.Equal(t, "settled", forward.Status)
+		require.InDelta(t, c.penalty, forward.Penalty, 1e-12, "delivered %v", c.delivered)
+	}
+}
@@ -0,0 +1,35 @@
+package chaincode
+
+const geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// encodeGeohash returns the geohash of the coordinates with precision characters.
+func encodeGeohash(latitude float64, longitude float64, precision int) string {
+	latitudeRange := [2]float64{-90, 90}
+	longitudeRange := [2]float64{-180, 180}
+	geohash := make([]byte, 0, precision)
+	even := true
+	bit, ch := 0, 0
+	for len(geohash) < precision {
+		// even bits split the longitude, odd bits the latitude
+		value, valueRange := latitude, &latitudeRange
+		if even {
+			value, valueRange = longitude, &longitudeRange
+		}
+		mid := (valueRange[0] + valueRange[1]) / 2
+		ch <<= 1
+		if value >= mid {
+			ch |= 1
+			valueRange[0] = mid
+		} else {
+			valueRange[1] = mid
+		}
+		even = !even
+
+		bit++
+		if bit == 5 {
+			geohash = append(geohash, geohashBase32[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return string(geohash)
+}
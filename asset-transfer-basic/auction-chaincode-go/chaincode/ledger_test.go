@@ -0,0 +1,333 @@
+package chaincode_test
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode/mocks"
+	"github.com/stretchr/testify/require"
+)
+
+// testLedger is the world state and private data of a test. Like on a peer, the reads
+// of a transaction see the committed state, and its writes are applied by commit.
+type testLedger struct {
+	t       *testing.T
+	now     time.Time
+	state   map[string][]byte
+	private map[string]map[string][]byte
+}
+
+// transaction is the context of one transaction of a client.
+type transaction struct {
+	*mocks.TransactionContext
+	stub          *mocks.ChaincodeStub
+	writes        map[string][]byte // nil to delete
+	privateWrites map[string]map[string][]byte
+}
+
+// client is the identity of the client of a transaction. The attributes are those
+// Fabric CA puts in the certificate, e.g. "market.admin": "true".
+type client struct {
+	mspID      string
+	name       string
+	attributes map[string]string
+}
+
+func (c *client) GetID() (string, error) {
+	return base64.StdEncoding.EncodeToString([]byte("x509::CN=" + c.name + "::CN=ca." + c.mspID)), nil
+}
+
+func (c *client) GetMSPID() (string, error) {
+	return c.mspID, nil
+}
+
+func (c *client) GetAttributeValue(attrName string) (string, bool, error) {
+	value, found := c.attributes[attrName]
+	return value, found, nil
+}
+
+func (c *client) AssertAttributeValue(attrName string, attrValue string) error {
+	value, found := c.attributes[attrName]
+	if !found {
+		return fmt.Errorf("attribute '%s' was not found", attrName)
+	}
+	if value != attrValue {
+		return fmt.Errorf("attribute '%s' equals '%s', not '%s'", attrName, value, attrValue)
+	}
+	return nil
+}
+
+func (c *client) GetX509Certificate() (*x509.Certificate, error) {
+	return &x509.Certificate{Subject: pkix.Name{CommonName: c.name}}, nil
+}
+
+var (
+	producer = &client{mspID: "Org1MSP", name: "User1"}
+	consumer = &client{mspID: "Org2MSP", name: "User2"}
+	other    = &client{mspID: "Org2MSP", name: "User3"}
+	admin    = &client{mspID: "Org1MSP", name: "Admin", attributes: map[string]string{"market.admin": "true"}}
+)
+
+// compositeKeyNamespace starts the composite keys, as in the shim
+const compositeKeyNamespace = "\x00"
+
+var start = time.Date(2022, 11, 6, 16, 0, 0, 0, time.UTC)
+
+// newTestLedger returns a ledger initialized by InitLedger, at start.
+func newTestLedger(t *testing.T) *testLedger {
+	l := &testLedger{t: t, now: start, state: map[string][]byte{}, private: map[string]map[string][]byte{}}
+	ctx := l.tx(admin, nil)
+	require.NoError(t, (&chaincode.SmartContract{}).InitLedger(ctx))
+	l.commit(ctx)
+	return l
+}
+
+// tx starts a transaction of c, timestamped at l.now.
+func (l *testLedger) tx(c *client, transient map[string][]byte) *transaction {
+	tx := &transaction{
+		TransactionContext: &mocks.TransactionContext{},
+		stub:               &mocks.ChaincodeStub{},
+		writes:             map[string][]byte{},
+		privateWrites:      map[string]map[string][]byte{},
+	}
+	tx.GetStubReturns(tx.stub)
+	tx.GetClientIdentityReturns(c)
+
+	tx.stub.GetTxTimestampReturns(&timestamp.Timestamp{Seconds: l.now.Unix(), Nanos: int32(l.now.Nanosecond())}, nil)
+	tx.stub.GetTransientReturns(transient, nil)
+	tx.stub.GetStateStub = func(key string) ([]byte, error) {
+		return l.state[key], nil
+	}
+	tx.stub.PutStateStub = func(key string, value []byte) error {
+		tx.writes[key] = value
+		return nil
+	}
+	tx.stub.DelStateStub = func(key string) error {
+		tx.writes[key] = nil
+		return nil
+	}
+	tx.stub.GetPrivateDataStub = func(collection string, key string) ([]byte, error) {
+		return l.private[collection][key], nil
+	}
+	tx.stub.GetPrivateDataHashStub = func(collection string, key string) ([]byte, error) {
+		value, ok := l.private[collection][key]
+		if !ok {
+			return nil, nil
+		}
+		hash := sha256.Sum256(value)
+		return hash[:], nil
+	}
+	putPrivateData := func(collection string, key string, value []byte) error {
+		if tx.privateWrites[collection] == nil {
+			tx.privateWrites[collection] = map[string][]byte{}
+		}
+		tx.privateWrites[collection][key] = value
+		return nil
+	}
+	tx.stub.PutPrivateDataStub = putPrivateData
+	tx.stub.DelPrivateDataStub = func(collection string, key string) error {
+		return putPrivateData(collection, key, nil)
+	}
+	tx.stub.GetStateByRangeStub = func(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+		var kvs []*queryresult.KV
+		for _, key := range l.keys() {
+			// as on a peer, the range queries skip the composite keys
+			if strings.HasPrefix(key, compositeKeyNamespace) {
+				continue
+			}
+			if (startKey == "" || key >= startKey) && (endKey == "" || key < endKey) {
+				kvs = append(kvs, &queryresult.KV{Key: key, Value: l.state[key]})
+			}
+		}
+		return &stateIterator{kvs: kvs}, nil
+	}
+	tx.stub.CreateCompositeKeyStub = shim.CreateCompositeKey
+	tx.stub.GetStateByPartialCompositeKeyStub = func(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+		prefix, err := shim.CreateCompositeKey(objectType, attributes)
+		if err != nil {
+			return nil, err
+		}
+		var kvs []*queryresult.KV
+		for _, key := range l.keys() {
+			if strings.HasPrefix(key, prefix) {
+				kvs = append(kvs, &queryresult.KV{Key: key, Value: l.state[key]})
+			}
+		}
+		return &stateIterator{kvs: kvs}, nil
+	}
+	tx.stub.GetQueryResultStub = l.query
+	return tx
+}
+
+// commit applies the writes of tx.
+func (l *testLedger) commit(tx *transaction) {
+	for key, value := range tx.writes {
+		if value == nil {
+			delete(l.state, key)
+		} else {
+			l.state[key] = value
+		}
+	}
+	for collection, writes := range tx.privateWrites {
+		if l.private[collection] == nil {
+			l.private[collection] = map[string][]byte{}
+		}
+		for key, value := range writes {
+			if value == nil {
+				delete(l.private[collection], key)
+			} else {
+				l.private[collection][key] = value
+			}
+		}
+	}
+}
+
+// token returns the committed token with id.
+func (l *testLedger) token(id string) *chaincode.Energy {
+	l.t.Helper()
+	var energy chaincode.Energy
+	require.NoError(l.t, json.Unmarshal(l.state[id], &energy), "token %s", id)
+	return &energy
+}
+
+func (l *testLedger) keys() []string {
+	keys := make([]string, 0, len(l.state))
+	for key := range l.state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// query evaluates the selector of a CouchDB query with the equality and comparison
+// operators used by the chaincode.
+func (l *testLedger) query(query string) (shim.StateQueryIteratorInterface, error) {
+	var parsed struct {
+		Selector map[string]interface{} `json:"selector"`
+	}
+	if err := json.Unmarshal([]byte(query), &parsed); err != nil {
+		return nil, fmt.Errorf("invalid query %s: %v", query, err)
+	}
+
+	var kvs []*queryresult.KV
+	for _, key := range l.keys() {
+		var document map[string]interface{}
+		if json.Unmarshal(l.state[key], &document) != nil {
+			continue
+		}
+		if matches(document, parsed.Selector) {
+			kvs = append(kvs, &queryresult.KV{Key: key, Value: l.state[key]})
+		}
+	}
+	return &stateIterator{kvs: kvs}, nil
+}
+
+func matches(document map[string]interface{}, selector map[string]interface{}) bool {
+	for field, condition := range selector {
+		value, ok := document[field]
+		if !ok {
+			return false
+		}
+		operators, isOperators := condition.(map[string]interface{})
+		if !isOperators {
+			operators = map[string]interface{}{"$eq": condition}
+		}
+		for operator, operand := range operators {
+			if !compare(operator, value, operand) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func compare(operator string, value interface{}, operand interface{}) bool {
+	if operator == "$eq" {
+		return reflect.DeepEqual(value, operand)
+	}
+	var order int
+	switch v := value.(type) {
+	case float64:
+		o, _ := operand.(float64)
+		order = sign(v < o, v > o)
+	case string:
+		o, _ := operand.(string)
+		order = sign(v < o, v > o)
+	default:
+		return false
+	}
+	switch operator {
+	case "$gt":
+		return order > 0
+	case "$gte":
+		return order >= 0
+	case "$lt":
+		return order < 0
+	case "$lte":
+		return order <= 0
+	}
+	return false
+}
+
+func sign(less bool, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+type stateIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (it *stateIterator) HasNext() bool {
+	return len(it.kvs) > 0
+}
+
+func (it *stateIterator) Next() (*queryresult.KV, error) {
+	if len(it.kvs) == 0 {
+		return nil, fmt.Errorf("no more results")
+	}
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+	return kv, nil
+}
+
+func (it *stateIterator) Close() error {
+	return nil
+}
+
+// createToken creates a solar token of the producer at l.now.
+func createToken(t *testing.T, l *testLedger, id string) {
+	t.Helper()
+	ctx := l.tx(producer, nil)
+	err := (&chaincode.SmartContract{}).CreateToken(ctx, id, 35.5, 139.6, "User1", "green", "solar", l.now)
+	require.NoError(t, err)
+	l.commit(ctx)
+}
+
+// bid bids on a token as the consumer at l.now.
+func bid(t *testing.T, l *testLedger, id string, price float64) string {
+	t.Helper()
+	ctx := l.tx(consumer, nil)
+	message, err := (&chaincode.SmartContract{}).BidOnToken(ctx, id, "User2", price, l.now)
+	require.NoError(t, err)
+	l.commit(ctx)
+	return message
+}
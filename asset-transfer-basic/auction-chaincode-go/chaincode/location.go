@@ -0,0 +1,194 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// locationTransientKey is the transient field carrying the location of a bidder
+	locationTransientKey = "location"
+	// tokenGeohashPrecision is the precision of the public geohash of a token (about 150m)
+	tokenGeohashPrecision = 7
+	// bidderGeohashPrecision is the precision of the public geohash of a bidder (about 5km)
+	bidderGeohashPrecision = 5
+
+	earthRadius   = 6378137.0 // m
+	pricePerMeter = 0.000001
+	// priceTolerance absorbs the rounding of the bid price by the client
+	priceTolerance = 1e-9
+)
+
+// BidLocation is the location of a bidder. It is passed in the transient map and kept
+// in the implicit collection of the bidder's org; the salt prevents guessing the
+// location from the hash that every peer can read.
+type BidLocation struct {
+	Latitude  float64 `json:"Latitude"`
+	Longitude float64 `json:"Longitude"`
+	Salt      string  `json:"Salt"`
+}
+
+// bidder is what the token records about the location of its highest bidder
+type bidder struct {
+	Zone    string
+	Geohash string
+}
+
+// BidOnTokenPrivate bids on a token with the location of the bidder passed in the
+// transient map. The bid price must cover the unit price plus the distance-based price
+// computed from the location, which is verified during endorsement and stored as
+// private data of the bidder's organization. The peers of the producer's org endorse the
+// bid too (setTokenEndorsement), so they see the location but do not keep it.
+// 需要家の位置はtransient mapの"location"で渡し、公開するのは粗いgeohashのみ
+func (s *SmartContract) BidOnTokenPrivate(ctx contractapi.TransactionContextInterface,
+	id string, newOwner string, newBidPrice float64, timestamp time.Time) (string, error) {
+	location, err := getBidLocation(ctx)
+	if err != nil {
+		return "", err
+	}
+	quota, err := newClientQuota(ctx)
+	if err != nil {
+		return "", err
+	}
+	return quota.bid(id, newOwner, func() (string, error) {
+		return s.bidPrivate(ctx, location, id, newOwner, newBidPrice, timestamp)
+	})
+}
+
+// bidPrivate places a bid from a location that is only stored as private data.
+func (s *SmartContract) bidPrivate(ctx contractapi.TransactionContextInterface,
+	location *BidLocation, id string, newOwner string, newBidPrice float64, timestamp time.Time) (string, error) {
+	energy, err := s.ReadToken(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	basePrice := energy.UnitPrice
+	if energy.PriceSchedule != nil {
+		basePrice = energy.PriceSchedule.priceAt(energy.GeneratedTime, timestamp)
+	}
+	meters := distance(location.Latitude, location.Longitude, energy.Latitude, energy.Longitude)
+	if newBidPrice+priceTolerance < basePrice+meters*pricePerMeter {
+		return fmt.Sprintf("your bid price does not cover the distance of %.0fm", meters), nil
+	}
+
+	from := bidder{Geohash: encodeGeohash(location.Latitude, location.Longitude, bidderGeohashPrecision)}
+	returnMessage, err := s.bidFrom(ctx, energy, newOwner, from, newBidPrice, timestamp, location.Latitude, location.Longitude)
+	if err != nil {
+		return "", err
+	}
+	if returnMessage != "your bid was successful" {
+		return returnMessage, nil
+	}
+
+	collection, err := getCollectionName(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get implicit collection name: %v", err)
+	}
+	locationJSON, err := json.Marshal(location)
+	if err != nil {
+		return "", err
+	}
+	err = ctx.GetStub().PutPrivateData(collection, bidLocationKey(id, newOwner), locationJSON)
+	if err != nil {
+		return "", fmt.Errorf("failed to put bid location: %v", err)
+	}
+	return returnMessage, nil
+}
+
+// ReadBidLocation returns the location of a bid from the implicit collection of the
+// client's organization.
+func (s *SmartContract) ReadBidLocation(ctx contractapi.TransactionContextInterface, id string, owner string) (*BidLocation, error) {
+	collection, err := getCollectionName(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get implicit collection name: %v", err)
+	}
+	locationJSON, err := ctx.GetStub().GetPrivateData(collection, bidLocationKey(id, owner))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bid location: %v", err)
+	}
+	if locationJSON == nil {
+		return nil, fmt.Errorf("the bid of %s on energy %s has no location in %s", owner, id, collection)
+	}
+
+	var location BidLocation
+	err = json.Unmarshal(locationJSON, &location)
+	if err != nil {
+		return nil, err
+	}
+	return &location, nil
+}
+
+// QueryByGeohash returns the tokens with given status whose geohash starts with prefix.
+// A short prefix lets consumers search without revealing their exact location.
+func (s *SmartContract) QueryByGeohash(ctx contractapi.TransactionContextInterface, status string, prefix string) ([]*Energy, error) {
+	queryString := fmt.Sprintf(`{"selector":{"DocType":"token","Status":"%s","Geohash":{"$gte":"%s","$lt":"%s~"}},"use_index":["_design/indexGeohashDoc","indexGeohash"]}`,
+		status, prefix, prefix)
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var energies []*Energy
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var energy Energy
+		err = json.Unmarshal(queryResponse.Value, &energy)
+		if err != nil {
+			return nil, err
+		}
+		energies = append(energies, &energy)
+	}
+
+	return energies, nil
+}
+
+func getBidLocation(ctx contractapi.TransactionContextInterface) (*BidLocation, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("error getting transient: %v", err)
+	}
+	locationJSON, ok := transientMap[locationTransientKey]
+	if !ok {
+		return nil, fmt.Errorf("location key not found in the transient map")
+	}
+
+	var location BidLocation
+	err = json.Unmarshal(locationJSON, &location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal location JSON: %v", err)
+	}
+	if location.Latitude < -90 || location.Latitude > 90 || location.Longitude < -180 || location.Longitude > 180 {
+		return nil, fmt.Errorf("invalid location")
+	}
+	if location.Salt == "" {
+		return nil, fmt.Errorf("the location must have a salt")
+	}
+	return &location, nil
+}
+
+func bidLocationKey(id string, owner string) string {
+	return id + "-" + owner + "-location"
+}
+
+// distance returns the great-circle distance in meters, as computed by the consumer.
+func distance(lat1 float64, lng1 float64, lat2 float64, lng2 float64) float64 {
+	rlat1 := lat1 * math.Pi / 180
+	rlng1 := lng1 * math.Pi / 180
+	rlat2 := lat2 * math.Pi / 180
+	rlng2 := lng2 * math.Pi / 180
+
+	angle := math.Sin(rlat1)*math.Sin(rlat2) +
+		math.Cos(rlat1)*math.Cos(rlat2)*math.Cos(rlng1-rlng2)
+	return earthRadius * math.Acos(math.Min(angle, 1))
+}
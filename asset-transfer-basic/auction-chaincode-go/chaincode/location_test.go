@@ -0,0 +1,34 @@
+package chaincode_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBidOnTokenPrivateKeepsTheLocationOfTheBidderPrivate(t *testing.T) {
+	l := newTestLedger(t)
+	createToken(t, l, "energy1")
+	l.now = start.Add(time.Minute)
+
+	// about 18km away from the token
+	require.Equal(t, "your bid price does not cover the distance of 18125m", bidFrom(t, l, "energy1", 0.03, 35.5, 139.4))
+	require.Equal(t, "your bid was successful", bidFrom(t, l, "energy1", 0.04, 35.5, 139.4))
+
+	energy := l.token("energy1")
+	require.Equal(t, "User2", energy.Owner)
+	require.Len(t, energy.BidderGeohash, 5)
+	require.NotContains(t, string(l.state["energy1"]), "139.4")
+
+	location, err := (&chaincode.SmartContract{}).ReadBidLocation(l.tx(consumer, nil), "energy1", "User2")
+	require.NoError(t, err)
+	require.Equal(t, &chaincode.BidLocation{Latitude: 35.5, Longitude: 139.4, Salt: "salt"}, location)
+	_, err = (&chaincode.SmartContract{}).ReadBidLocation(l.tx(producer, nil), "energy1", "User2")
+	require.EqualError(t, err, "the bid of User2 on energy energy1 has no location in _implicit_org_Org1MSP")
+
+	ctx := l.tx(consumer, map[string][]byte{"location": []byte(`{"Latitude":35.5,"Longitude":139.4}`)})
+	_, err = (&chaincode.SmartContract{}).BidOnTokenPrivate(ctx, "energy1", "User2", 0.05, l.now)
+	require.EqualError(t, err, "the location must have a salt")
+}
@@ -0,0 +1,277 @@
+package chaincode
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// the oracle and the readings are kept under composite keys, apart from the token IDs
+// chosen by the producers. Until an oracle is set, tokens are biddable as soon as they
+// are created.
+const (
+	meterOracleObjectType  = "meterOracle"
+	meterReadingObjectType = "meterReading"
+)
+
+// meterOracleAttribute must be set to "true" in the certificate of the oracle clients
+const meterOracleAttribute = "meter.oracle"
+
+// MeterOracle is the organization whose meters confirm generation and delivery
+type MeterOracle struct {
+	DocType string `json:"DocType"`
+	MSPID   string `json:"MSPID"`
+}
+
+// MeterReading is a meter reading posted by the oracle for a token. It is stored under
+// its own key, which only the oracle's organization can endorse.
+type MeterReading struct {
+	DocType string    `json:"DocType"`
+	TokenID string    `json:"Token ID"`
+	Kind    string    `json:"Kind"` // "generation" or "delivery"
+	MeterID string    `json:"Meter ID"`
+	Amount  float64   `json:"Amount"` // kWh
+	Time    time.Time `json:"Time"`
+	Signer  string    `json:"Signer"`
+}
+
+// SetMeterOracle sets the organization of the metering oracle. The first oracle is set
+// by a market admin; it is then changed by a meter of the current oracle.
+func (s *SmartContract) SetMeterOracle(ctx contractapi.TransactionContextInterface, mspID string) error {
+	oracle, err := s.getMeterOracle(ctx)
+	if err != nil {
+		return err
+	}
+	if mspID == "" {
+		return fmt.Errorf("mspID must not be empty")
+	}
+	if oracle == nil {
+		err = ctx.GetClientIdentity().AssertAttributeValue(marketAdminAttribute, "true")
+		if err != nil {
+			return fmt.Errorf("client is not a market admin: %v", err)
+		}
+	} else if err = assertMeterOracle(ctx, oracle); err != nil {
+		return err
+	}
+
+	oracleJSON, err := json.Marshal(MeterOracle{DocType: "meterOracle", MSPID: mspID})
+	if err != nil {
+		return err
+	}
+	key, err := meterOracleKey(ctx)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(key, oracleJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return setStateBasedEndorsement(ctx, key, mspID)
+}
+
+// ConfirmGeneration records the generation reading of a pending token and makes it
+// biddable. Its first auction round starts at the confirmation.
+func (s *SmartContract) ConfirmGeneration(ctx contractapi.TransactionContextInterface,
+	id string, meterID string, amount float64, timestamp time.Time) error {
+	oracle, err := s.requireMeterOracle(ctx)
+	if err != nil {
+		return err
+	}
+	if amount <= 0 {
+		return fmt.Errorf("the metered amount must be positive")
+	}
+	energy, err := s.ReadToken(ctx, id)
+	if err != nil {
+		return err
+	}
+	if energy.DocType != "token" || energy.Status != "pending" {
+		return fmt.Errorf("the energy %s is not waiting for a generation reading", id)
+	}
+
+	err = s.putMeterReading(ctx, oracle, id, "generation", meterID, amount, timestamp)
+	if err != nil {
+		return err
+	}
+
+	energy.Status = "generated"
+	energy.AuctionStartTime = timestamp
+	return s.UpdateToken(ctx, energy)
+}
+
+// ConfirmDelivery records the delivery reading of a sold token. A sold token becomes
+// consumed, so that it can no longer be resold.
+func (s *SmartContract) ConfirmDelivery(ctx contractapi.TransactionContextInterface,
+	id string, meterID string, amount float64, timestamp time.Time) error {
+	oracle, err := s.requireMeterOracle(ctx)
+	if err != nil {
+		return err
+	}
+	if amount <= 0 {
+		return fmt.Errorf("the metered amount must be positive")
+	}
+	energy, err := s.ReadToken(ctx, id)
+	if err != nil {
+		return err
+	}
+	if energy.DocType != "token" || (energy.Status != "sold" && energy.Status != "consumed") {
+		return fmt.Errorf("the energy %s was not sold", id)
+	}
+
+	err = s.putMeterReading(ctx, oracle, id, "delivery", meterID, amount, timestamp)
+	if err != nil {
+		return err
+	}
+
+	if energy.Status == "sold" {
+		energy.Status = "consumed"
+		energy.ConsumedTime = timestamp
+	}
+	return s.UpdateToken(ctx, energy)
+}
+
+// ReadMeterReading returns the "generation" or "delivery" reading of a token.
+func (s *SmartContract) ReadMeterReading(ctx contractapi.TransactionContextInterface, id string, kind string) (*MeterReading, error) {
+	key, err := meterReadingKey(ctx, id, kind)
+	if err != nil {
+		return nil, err
+	}
+	readingJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if readingJSON == nil {
+		return nil, fmt.Errorf("the energy %s has no %s reading", id, kind)
+	}
+
+	var reading MeterReading
+	err = json.Unmarshal(readingJSON, &reading)
+	if err != nil {
+		return nil, err
+	}
+	return &reading, nil
+}
+
+func (s *SmartContract) putMeterReading(ctx contractapi.TransactionContextInterface, oracle *MeterOracle,
+	id string, kind string, meterID string, amount float64, timestamp time.Time) error {
+	key, err := meterReadingKey(ctx, id, kind)
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("the energy %s already has a %s reading", id, kind)
+	}
+
+	b64ID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to read clientID: %v", err)
+	}
+	signer, err := base64.StdEncoding.DecodeString(b64ID)
+	if err != nil {
+		return fmt.Errorf("failed to base64 decode clientID: %v", err)
+	}
+
+	reading := MeterReading{
+		DocType: "meterReading",
+		TokenID: id,
+		Kind:    kind,
+		MeterID: meterID,
+		Amount:  amount,
+		Time:    timestamp,
+		Signer:  string(signer),
+	}
+	readingJSON, err := json.Marshal(reading)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(key, readingJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return setStateBasedEndorsement(ctx, key, oracle.MSPID)
+}
+
+// getMeterOracle returns the metering oracle, or nil if none is set.
+func (s *SmartContract) getMeterOracle(ctx contractapi.TransactionContextInterface) (*MeterOracle, error) {
+	key, err := meterOracleKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	oracleJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if oracleJSON == nil {
+		return nil, nil
+	}
+
+	var oracle MeterOracle
+	err = json.Unmarshal(oracleJSON, &oracle)
+	if err != nil {
+		return nil, err
+	}
+	return &oracle, nil
+}
+
+// requireMeterOracle returns the metering oracle if the client is one of its meters.
+func (s *SmartContract) requireMeterOracle(ctx contractapi.TransactionContextInterface) (*MeterOracle, error) {
+	oracle, err := s.getMeterOracle(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if oracle == nil {
+		return nil, fmt.Errorf("no metering oracle is set")
+	}
+	return oracle, assertMeterOracle(ctx, oracle)
+}
+
+func assertMeterOracle(ctx contractapi.TransactionContextInterface, oracle *MeterOracle) error {
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get verified MSPID: %v", err)
+	}
+	if clientMSPID != oracle.MSPID {
+		return fmt.Errorf("client from org %s is not the metering oracle", clientMSPID)
+	}
+	err = ctx.GetClientIdentity().AssertAttributeValue(meterOracleAttribute, "true")
+	if err != nil {
+		return fmt.Errorf("client is not a meter: %v", err)
+	}
+	return nil
+}
+
+func meterOracleKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(meterOracleObjectType, []string{})
+}
+
+func meterReadingKey(ctx contractapi.TransactionContextInterface, id string, kind string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(meterReadingObjectType, []string{id, kind})
+}
+
+// setStateBasedEndorsement sets the endorsement policy of a key to the peers of the orgs
+func setStateBasedEndorsement(ctx contractapi.TransactionContextInterface, key string, orgsToEndorse ...string) error {
+	endorsementPolicy, err := statebased.NewStateEP(nil)
+	if err != nil {
+		return err
+	}
+	err = endorsementPolicy.AddOrgs(statebased.RoleTypePeer, orgsToEndorse...)
+	if err != nil {
+		return fmt.Errorf("failed to add org to endorsement policy: %v", err)
+	}
+	policy, err := endorsementPolicy.Policy()
+	if err != nil {
+		return fmt.Errorf("failed to create endorsement policy bytes from org: %v", err)
+	}
+	err = ctx.GetStub().SetStateValidationParameter(key, policy)
+	if err != nil {
+		return fmt.Errorf("failed to set validation parameter on %s: %v", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package chaincode_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+var meter = &client{mspID: "Org3MSP", name: "Meter1", attributes: map[string]string{"meter.oracle": "true"}}
+
+// meterOracleKey is the composite key of the metering oracle.
+var meterOracleKey, _ = shim.CreateCompositeKey("meterOracle", []string{})
+
+func TestTheFirstMeterOracleIsSetByAMarketAdmin(t *testing.T) {
+	l := newTestLedger(t)
+	auction := &chaincode.SmartContract{}
+
+	// a meter cannot make its own org the oracle
+	err := auction.SetMeterOracle(l.tx(meter, nil), "Org3MSP")
+	require.EqualError(t, err, "client is not a market admin: attribute 'market.admin' was not found")
+
+	ctx := l.tx(admin, nil)
+	require.NoError(t, auction.SetMeterOracle(ctx, "Org3MSP"))
+	l.commit(ctx)
+
+	// the oracle is then changed by its meters only
+	err = auction.SetMeterOracle(l.tx(admin, nil), "Org1MSP")
+	require.EqualError(t, err, "client from org Org1MSP is not the metering oracle")
+	ctx = l.tx(meter, nil)
+	require.NoError(t, auction.SetMeterOracle(ctx, "Org1MSP"))
+	l.commit(ctx)
+	require.JSONEq(t, `{"DocType":"meterOracle","MSPID":"Org1MSP"}`, string(l.state[meterOracleKey]))
+}
+
+func TestConfirmGenerationStartsTheAuctionOfAPendingToken(t *testing.T) {
+	l := newTestLedger(t)
+	auction := &chaincode.SmartContract{}
+	ctx := l.tx(admin, nil)
+	require.NoError(t, auction.SetMeterOracle(ctx, "Org3MSP"))
+	l.commit(ctx)
+	createToken(t, l, "energy1")
+	require.Equal(t, "pending", l.token("energy1").Status)
+
+	l.now = start.Add(2 * time.Minute)
+	err := auction.ConfirmGeneration(l.tx(producer, nil), "energy1", "meter-1", 1, l.now)
+	require.EqualError(t, err, "client from org Org1MSP is not the metering oracle")
+
+	ctx = l.tx(meter, nil)
+	require.NoError(t, auction.ConfirmGeneration(ctx, "energy1", "meter-1", 1, l.now))
+	l.commit(ctx)
+	energy := l.token("energy1")
+	require.Equal(t, "generated", energy.Status)
+	require.Equal(t, l.now, energy.AuctionStartTime.UTC())
+}
+
+func TestATokenNamedAfterTheOracleDoesNotHideIt(t *testing.T) {
+	l := newTestLedger(t)
+	auction := &chaincode.SmartContract{}
+	createToken(t, l, "meter-oracle")
+
+	ctx := l.tx(admin, nil)
+	require.NoError(t, auction.SetMeterOracle(ctx, "Org3MSP"))
+	l.commit(ctx)
+	createToken(t, l, "energy1")
+	require.Equal(t, "pending", l.token("energy1").Status)
+	require.Equal(t, "generated", l.token("meter-oracle").Status)
+}
+
+func TestConfirmDeliveryConsumesASoldToken(t *testing.T) {
+	l := newTestLedger(t)
+	auction := &chaincode.SmartContract{}
+	createToken(t, l, "energy1")
+	l.now = start.Add(time.Minute)
+	bid(t, l, "energy1", 0.03)
+	l.now = start.Add(5 * time.Minute)
+	sweep(t, l)
+	ctx := l.tx(admin, nil)
+	require.NoError(t, auction.SetMeterOracle(ctx, "Org3MSP"))
+	l.commit(ctx)
+
+	l.now = start.Add(time.Hour)
+	err := auction.ConfirmDelivery(l.tx(consumer, nil), "energy1", "meter-2", 1, l.now)
+	require.EqualError(t, err, "client from org Org2MSP is not the metering oracle")
+
+	ctx = l.tx(meter, nil)
+	require.NoError(t, auction.ConfirmDelivery(ctx, "energy1", "meter-2", 1, l.now))
+	l.commit(ctx)
+	energy := l.token("energy1")
+	require.Equal(t, "consumed", energy.Status)
+	require.Equal(t, l.now, energy.ConsumedTime.UTC())
+	reading, err := auction.ReadMeterReading(l.tx(consumer, nil), "energy1", "delivery")
+	require.NoError(t, err)
+	require.Equal(t, "meter-2", reading.MeterID)
+
+	// a delivery is metered once
+	err = auction.ConfirmDelivery(l.tx(meter, nil), "energy1", "meter-2", 1, l.now)
+	require.EqualError(t, err, "the energy energy1 already has a delivery reading")
+}
+
+func TestConfirmDeliveryRejectsAnUnsoldToken(t *testing.T) {
+	l := newTestLedger(t)
+	auction := &chaincode.SmartContract{}
+	createToken(t, l, "energy1")
+	ctx := l.tx(admin, nil)
+	require.NoError(t, auction.SetMeterOracle(ctx, "Org3MSP"))
+	l.commit(ctx)
+
+	err := auction.ConfirmDelivery(l.tx(meter, nil), "energy1", "meter-2", 1, l.now)
+	require.EqualError(t, err, "the energy energy1 was not sold")
+}
@@ -0,0 +1,252 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const marketQuotaKey = "market-quota"
+
+// marketAdminAttribute must be set to "true" in the certificate of the clients that
+// set the market quota
+const marketAdminAttribute = "market.admin"
+
+// MarketQuota limits what each client identity can do on the market. A limit of 0 is
+// no limit, and clients are not limited at all until a quota is set.
+type MarketQuota struct {
+	DocType       string `json:"DocType"`
+	TokensPerHour int    `json:"Tokens Per Hour"`
+	OpenBids      int    `json:"Open Bids"`
+}
+
+// QuotaUsage is what a client identity has used of the market quota. It is keyed by
+// the identity of the client rather than by the user name passed in the arguments.
+type QuotaUsage struct {
+	DocType  string    `json:"DocType"`
+	MSPID    string    `json:"MSPID"`
+	ClientID string    `json:"Client ID"`
+	Window   time.Time `json:"Window"` // the hour of Tokens
+	Tokens   int       `json:"Tokens"`
+	Bids     []OpenBid `json:"Bids"`
+}
+
+// OpenBid is a token or forward on which the client placed a bid that may still be the
+// highest.
+type OpenBid struct {
+	TokenID string `json:"Token ID"`
+	Owner   string `json:"Owner"`
+}
+
+// SetMarketQuota sets the number of tokens each client can create per hour and the
+// number of tokens it can be the highest bidder of at once. Only a market admin can
+// set it.
+// 発行数と入札数の上限 (クライアントのIDごと)
+func (s *SmartContract) SetMarketQuota(ctx contractapi.TransactionContextInterface, tokensPerHour int, openBids int) error {
+	err := ctx.GetClientIdentity().AssertAttributeValue(marketAdminAttribute, "true")
+	if err != nil {
+		return fmt.Errorf("client is not a market admin: %v", err)
+	}
+	if tokensPerHour < 0 || openBids < 0 {
+		return fmt.Errorf("the limits must not be negative")
+	}
+
+	quota := MarketQuota{DocType: "marketQuota", TokensPerHour: tokensPerHour, OpenBids: openBids}
+	quotaJSON, err := json.Marshal(quota)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(marketQuotaKey, quotaJSON)
+}
+
+// GetMarketQuota returns the market quota, with no limits if none is set.
+func (s *SmartContract) GetMarketQuota(ctx contractapi.TransactionContextInterface) (*MarketQuota, error) {
+	quota, err := getMarketQuota(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if quota == nil {
+		return &MarketQuota{DocType: "marketQuota"}, nil
+	}
+	return quota, nil
+}
+
+// GetQuotaUsage returns what the client has used of the market quota.
+func (s *SmartContract) GetQuotaUsage(ctx contractapi.TransactionContextInterface) (*QuotaUsage, error) {
+	return getQuotaUsage(ctx)
+}
+
+// clientQuota enforces the market quota on the client of a transaction. It keeps the
+// usage written in the transaction, since GetState does not return the writes of the
+// transaction itself.
+type clientQuota struct {
+	ctx    contractapi.TransactionContextInterface
+	limits *MarketQuota // nil without a quota
+	usage  *QuotaUsage
+	// bids placed in the transaction, which the world state does not show yet
+	placed map[OpenBid]bool
+}
+
+func newClientQuota(ctx contractapi.TransactionContextInterface) (*clientQuota, error) {
+	limits, err := getMarketQuota(ctx)
+	if err != nil {
+		return nil, err
+	}
+	quota := &clientQuota{ctx: ctx, limits: limits, placed: map[OpenBid]bool{}}
+	if limits == nil {
+		return quota, nil
+	}
+	quota.usage, err = getQuotaUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return quota, nil
+}
+
+// checkToken returns an error if the client has no token left in the current hour.
+func (q *clientQuota) checkToken() error {
+	if q.limits == nil || q.limits.TokensPerHour == 0 {
+		return nil
+	}
+	timestamp, err := txTime(q.ctx)
+	if err != nil {
+		return err
+	}
+	window := timestamp.UTC().Truncate(time.Hour)
+	if !q.usage.Window.Equal(window) {
+		q.usage.Window = window
+		q.usage.Tokens = 0
+	}
+	if q.usage.Tokens >= q.limits.TokensPerHour {
+		return fmt.Errorf("the quota of %d tokens per hour is used up", q.limits.TokensPerHour)
+	}
+	return nil
+}
+
+// useToken counts a token written after checkToken against the tokens of the current hour.
+func (q *clientQuota) useToken() error {
+	if q.limits == nil || q.limits.TokensPerHour == 0 {
+		return nil
+	}
+	q.usage.Tokens++
+	return q.save()
+}
+
+// bid places a bid with place if the client has an open bid left, and counts it if it
+// is successful. Raising a bid that is still the highest does not use another one.
+func (q *clientQuota) bid(id string, newOwner string, place func() (string, error)) (string, error) {
+	if q.limits == nil || q.limits.OpenBids == 0 {
+		return place()
+	}
+	openBid := OpenBid{TokenID: id, Owner: newOwner}
+	open, err := q.openBids()
+	if err != nil {
+		return "", err
+	}
+	counted := false
+	for _, bid := range open {
+		counted = counted || bid == openBid
+	}
+	if !counted && len(open) >= q.limits.OpenBids {
+		return "", fmt.Errorf("the quota of %d open bids is used up", q.limits.OpenBids)
+	}
+
+	returnMessage, err := place()
+	if err != nil || returnMessage != "your bid was successful" {
+		return returnMessage, err
+	}
+	if !counted {
+		open = append(open, openBid)
+	}
+	q.usage.Bids = open
+	q.placed[openBid] = true
+	return returnMessage, q.save()
+}
+
+// openBids returns the bids of the client that are still the highest bid of a token or
+// forward in auction.
+func (q *clientQuota) openBids() ([]OpenBid, error) {
+	open := []OpenBid{}
+	for _, bid := range q.usage.Bids {
+		if q.placed[bid] {
+			open = append(open, bid)
+			continue
+		}
+		energyJSON, err := q.ctx.GetStub().GetState(bid.TokenID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from world state: %v", err)
+		}
+		if energyJSON == nil {
+			continue
+		}
+		var energy Energy
+		err = json.Unmarshal(energyJSON, &energy)
+		if err != nil {
+			return nil, err
+		}
+		inAuction := energy.Status == "generated" || energy.DocType == "forward" && energy.Status == "open"
+		if inAuction && energy.Owner == bid.Owner {
+			open = append(open, bid)
+		}
+	}
+	return open, nil
+}
+
+func (q *clientQuota) save() error {
+	usageJSON, err := json.Marshal(q.usage)
+	if err != nil {
+		return err
+	}
+	return q.ctx.GetStub().PutState(quotaUsageKey(q.usage.MSPID, q.usage.ClientID), usageJSON)
+}
+
+// getMarketQuota returns the market quota, or nil if none is set.
+func getMarketQuota(ctx contractapi.TransactionContextInterface) (*MarketQuota, error) {
+	quotaJSON, err := ctx.GetStub().GetState(marketQuotaKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if quotaJSON == nil {
+		return nil, nil
+	}
+
+	var quota MarketQuota
+	err = json.Unmarshal(quotaJSON, &quota)
+	if err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+func getQuotaUsage(ctx contractapi.TransactionContextInterface) (*QuotaUsage, error) {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get verified MSPID: %v", err)
+	}
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	usage := QuotaUsage{DocType: "quotaUsage", MSPID: mspID, ClientID: clientID}
+	usageJSON, err := ctx.GetStub().GetState(quotaUsageKey(mspID, clientID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if usageJSON != nil {
+		err = json.Unmarshal(usageJSON, &usage)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if usage.Bids == nil {
+		usage.Bids = []OpenBid{}
+	}
+	return &usage, nil
+}
+
+func quotaUsageKey(mspID string, clientID string) string {
+	return "quota-" + mspID + "-" + clientID
+}
@@ -0,0 +1,74 @@
+package chaincode_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateTokensCountsOnlyTheTokensWritten(t *testing.T) {
+	l := newTestLedger(t)
+	auction := &chaincode.SmartContract{}
+	ctx := l.tx(admin, nil)
+	require.NoError(t, auction.SetMarketQuota(ctx, 2, 0))
+	l.commit(ctx)
+
+	// an invalid reserve price fails the transaction before any token is counted
+	tokens := []chaincode.TokenSpec{{ID: "energy1", Latitude: 35.5, Longitude: 139.6, LargeCategory: "green", SmallCategory: "solar"}}
+	ctx = l.tx(producer, reserveTransient(-1))
+	_, err := auction.CreateTokens(ctx, "User1", tokens, l.now)
+	require.EqualError(t, err, "the reserve price must be positive")
+
+	// an item that fails is not counted against the quota
+	tokens = []chaincode.TokenSpec{
+		{ID: "energy1", Latitude: 35.5, Longitude: 139.6, LargeCategory: "green", SmallCategory: "unknown"},
+		{ID: "energy2", Latitude: 35.5, Longitude: 139.6, LargeCategory: "green", SmallCategory: "solar"},
+		{ID: "energy3", Latitude: 35.5, Longitude: 139.6, LargeCategory: "green", SmallCategory: "solar"},
+		{ID: "energy4", Latitude: 35.5, Longitude: 139.6, LargeCategory: "green", SmallCategory: "solar"},
+	}
+	ctx = l.tx(producer, reserveTransient(0.03))
+	results, err := auction.CreateTokens(ctx, "User1", tokens, l.now)
+	require.NoError(t, err)
+	l.commit(ctx)
+	require.NotEmpty(t, results[0].Error)
+	require.Equal(t, "the energy energy2 was created", results[1].Message)
+	require.Equal(t, "the energy energy3 was created", results[2].Message)
+	require.Equal(t, "the quota of 2 tokens per hour is used up", results[3].Error)
+
+	usage, err := auction.GetQuotaUsage(l.tx(producer, nil))
+	require.NoError(t, err)
+	require.Equal(t, 2, usage.Tokens)
+}
+
+func TestTheForwardsCountAgainstTheQuota(t *testing.T) {
+	l := newTestLedger(t)
+	auction := &chaincode.SmartContract{}
+	ctx := l.tx(admin, nil)
+	require.NoError(t, auction.SetMarketQuota(ctx, 2, 1))
+	l.commit(ctx)
+
+	createToken(t, l, "energy1")
+	createForward(t, l, "forward1")
+	ctx = l.tx(producer, nil)
+	err := auction.CreateForward(ctx, "forward2", 35.5, 139.6, "User1", "green", "solar",
+		10, 12, l.now.Add(time.Hour), l.now.Add(2*time.Hour), l.now)
+	require.EqualError(t, err, "the quota of 2 tokens per hour is used up")
+
+	// the open bid on the forward leaves none for the token
+	ctx = l.tx(consumer, nil)
+	message, err := auction.BidOnForward(ctx, "forward1", "User2", 0.03, l.now)
+	require.NoError(t, err)
+	require.Equal(t, "your bid was successful", message)
+	l.commit(ctx)
+	ctx = l.tx(consumer, nil)
+	_, err = auction.BidOnToken(ctx, "energy1", "User2", 0.03, l.now)
+	require.EqualError(t, err, "the quota of 1 open bids is used up")
+
+	// raising the bid on the forward does not use another one
+	ctx = l.tx(consumer, nil)
+	message, err = auction.BidOnForward(ctx, "forward1", "User2", 0.04, l.now)
+	require.NoError(t, err)
+	require.Equal(t, "your bid was successful", message)
+}
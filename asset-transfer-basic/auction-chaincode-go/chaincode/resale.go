@@ -0,0 +1,194 @@
+package chaincode
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Transfer records one change of ownership of a token. Price is 0 for a TransferToken.
+type Transfer struct {
+	From  string    `json:"From"`
+	To    string    `json:"To"`
+	Price float64   `json:"Price"`
+	Time  time.Time `json:"Time"`
+}
+
+const resaleRound = 5 // minutes
+
+// ListForResale starts a resale auction of a sold token with the given minimum price.
+// While the token is listed, Owner is the highest bidder and Seller the current owner,
+// as Owner and Producer are in the first auction.
+func (s *SmartContract) ListForResale(ctx contractapi.TransactionContextInterface,
+	id string, seller string, price float64, timestamp time.Time) error {
+	if err := checkClientIs(ctx, seller); err != nil {
+		return err
+	}
+	energy, err := s.ReadToken(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err = checkHeldBy(energy, seller); err != nil {
+		return err
+	}
+	if price <= 0 {
+		return fmt.Errorf("the resale price must be positive")
+	}
+
+	energy.Status = "resale"
+	energy.Seller = seller
+	energy.ResalePrice = price
+	energy.AuctionStartTime = timestamp
+	return s.UpdateToken(ctx, energy)
+}
+
+// BidOnResale bids on a token listed for resale. The resale round lasts 5min.
+func (s *SmartContract) BidOnResale(ctx contractapi.TransactionContextInterface,
+	id string, newOwner string, newBidPrice float64, timestamp time.Time) (string, error) {
+	energy, err := s.ReadToken(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	bidderMSP, err := clientMSPID(ctx)
+	if err != nil {
+		return "", err
+	}
+	if energy.DocType != "token" || energy.Status != "resale" {
+		return "the energy " + id + " is not for resale", nil
+	}
+	if newOwner == energy.Seller {
+		return "you cannot bid on your own energy", nil
+	}
+	if timestamp.Add(time.Minute * -resaleRound).After(energy.AuctionStartTime) {
+		return "the resale of energy " + id + " was started more than 5min ago", nil
+	}
+	if energy.ResalePrice >= newBidPrice {
+		return "your bid price is cheap", nil
+	}
+
+	energy.BidTime = timestamp
+	energy.Owner = newOwner
+	energy.BidderMSP = bidderMSP
+	energy.ResalePrice = newBidPrice
+	err = s.UpdateToken(ctx, energy)
+	if err != nil {
+		return "", err
+	}
+	return "your bid was successful", nil
+}
+
+// ResaleEnd closes the resale round of a token. The token goes to the highest bidder,
+// or goes back to the seller if there was no bid.
+func (s *SmartContract) ResaleEnd(ctx contractapi.TransactionContextInterface,
+	id string, seller string, timestamp time.Time) (string, error) {
+	energy, err := s.ReadToken(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if energy.Status != "resale" || energy.Seller != seller {
+		return "", fmt.Errorf("the energy %s is not listed for resale by %s", id, seller)
+	}
+
+	returnMessage, changed := closeResaleRound(energy, timestamp)
+	if !changed {
+		return returnMessage, nil
+	}
+	err = s.UpdateToken(ctx, energy)
+	if err != nil {
+		return "", err
+	}
+	err = setTokenEndorsement(ctx, energy)
+	if err != nil {
+		return "", err
+	}
+	return returnMessage, nil
+}
+
+// TransferToken gives a sold token to another consumer of the org toMSP without an
+// auction. The org of the new owner then endorses the changes to the token.
+func (s *SmartContract) TransferToken(ctx contractapi.TransactionContextInterface,
+	id string, from string, to string, toMSP string, timestamp time.Time) error {
+	if err := checkClientIs(ctx, from); err != nil {
+		return err
+	}
+	energy, err := s.ReadToken(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err = checkHeldBy(energy, from); err != nil {
+		return err
+	}
+	if to == "" || to == from {
+		return fmt.Errorf("invalid new owner %q", to)
+	}
+	if toMSP == "" {
+		return fmt.Errorf("the org of the new owner is required")
+	}
+
+	energy.Owner = to
+	energy.BidderMSP = toMSP
+	recordTransfer(energy, from, to, 0, timestamp)
+	err = s.UpdateToken(ctx, energy)
+	if err != nil {
+		return err
+	}
+	return setTokenEndorsement(ctx, energy)
+}
+
+// Consume records the delivery of a sold token to its owner. A consumed token can no
+// longer be resold or transferred.
+func (s *SmartContract) Consume(ctx contractapi.TransactionContextInterface,
+	id string, owner string, timestamp time.Time) error {
+	if err := checkClientIs(ctx, owner); err != nil {
+		return err
+	}
+	energy, err := s.ReadToken(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err = checkHeldBy(energy, owner); err != nil {
+		return err
+	}
+
+	energy.Status = "consumed"
+	energy.ConsumedTime = timestamp
+	return s.UpdateToken(ctx, energy)
+}
+
+// closeResaleRound applies the end of the resale round to energy at timestamp and
+// reports whether the token was changed.
+func closeResaleRound(energy *Energy, timestamp time.Time) (string, bool) {
+	if energy.AuctionStartTime.After(timestamp.Add(time.Minute * -resaleRound)) {
+		return "Why did you call this function?", false
+	}
+
+	var returnMessage string
+	if energy.Owner == energy.Seller {
+		returnMessage = "the energy " + energy.ID + " was not resold"
+	} else {
+		energy.BidPrice = energy.ResalePrice
+		recordTransfer(energy, energy.Seller, energy.Owner, energy.ResalePrice, timestamp)
+		returnMessage = "the energy " + energy.ID + " was resold"
+	}
+	energy.Status = "sold"
+	energy.Seller = ""
+	energy.ResalePrice = 0
+	return returnMessage, true
+}
+
+// checkHeldBy returns an error unless energy is a sold token owned by owner and not
+// listed for resale.
+func checkHeldBy(energy *Energy, owner string) error {
+	if energy.DocType != "token" || energy.Status != "sold" {
+		return fmt.Errorf("the energy %s is %s", energy.ID, energy.Status)
+	}
+	if energy.Owner != owner {
+		return fmt.Errorf("the energy %s is not owned by %s", energy.ID, owner)
+	}
+	return nil
+}
+
+func recordTransfer(energy *Energy, from string, to string, price float64, timestamp time.Time) {
+	energy.Transfers = append(energy.Transfers, Transfer{From: from, To: to, Price: price, Time: timestamp})
+}
@@ -0,0 +1,146 @@
+package chaincode_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+// buyer is a consumer of another org than the first buyer.
+var buyer = &client{mspID: "Org3MSP", name: "User4"}
+
+// soldToken returns a ledger where the consumer bought the green token energy1.
+func soldToken(t *testing.T) *testLedger {
+	l := newTestLedger(t)
+	createToken(t, l, "energy1")
+	l.now = start.Add(time.Minute)
+	require.Equal(t, "your bid was successful", bid(t, l, "energy1", 0.03))
+	l.now = start.Add(5 * time.Minute)
+	result, _ := sweep(t, l)
+	require.Equal(t, []string{"energy1"}, result.Sold)
+	return l
+}
+
+func TestOnlyTheOwnerResellsTransfersOrConsumesTheToken(t *testing.T) {
+	l := soldToken(t)
+	auction := &chaincode.SmartContract{}
+
+	// another user cannot act for the owner by naming it
+	ctx := l.tx(other, nil)
+	err := auction.ListForResale(ctx, "energy1", "User2", 0.04, l.now)
+	require.EqualError(t, err, "the client is not User2")
+	err = auction.TransferToken(ctx, "energy1", "User2", "User3", "Org2MSP", l.now)
+	require.EqualError(t, err, "the client is not User2")
+	err = auction.Consume(ctx, "energy1", "User2", l.now)
+	require.EqualError(t, err, "the client is not User2")
+	err = auction.TransferToken(ctx, "energy1", "User3", "User3", "Org2MSP", l.now)
+	require.EqualError(t, err, "the energy energy1 is not owned by User3")
+	require.Empty(t, ctx.writes)
+
+	ctx = l.tx(consumer, nil)
+	require.NoError(t, auction.TransferToken(ctx, "energy1", "User2", "User3", "Org2MSP", l.now))
+	l.commit(ctx)
+	energy := l.token("energy1")
+	require.Equal(t, "User3", energy.Owner)
+	require.Equal(t, chaincode.Transfer{From: "User2", To: "User3", Time: l.now}, energy.Transfers[len(energy.Transfers)-1])
+
+	// the consumed time is left out until the token is consumed
+	require.NotContains(t, string(l.state["energy1"]), "Consumed Time")
+	ctx = l.tx(other, nil)
+	require.NoError(t, auction.Consume(ctx, "energy1", "User3", l.now))
+	l.commit(ctx)
+	require.Equal(t, "consumed", l.token("energy1").Status)
+	require.Equal(t, l.now, l.token("energy1").ConsumedTime.UTC())
+}
+
+func TestTheResaleGoesToTheHighestBidder(t *testing.T) {
+	l := soldToken(t)
+	auction := &chaincode.SmartContract{}
+
+	ctx := l.tx(consumer, nil)
+	require.NoError(t, auction.ListForResale(ctx, "energy1", "User2", 0.04, l.now))
+	l.commit(ctx)
+
+	l.now = start.Add(6 * time.Minute)
+	ctx = l.tx(other, nil)
+	message, err := auction.BidOnResale(ctx, "energy1", "User3", 0.045, l.now)
+	require.NoError(t, err)
+	require.Equal(t, "your bid was successful", message)
+	l.commit(ctx)
+
+	l.now = start.Add(10 * time.Minute)
+	ctx = l.tx(admin, nil)
+	message, err = auction.ResaleEnd(ctx, "energy1", "User2", l.now)
+	require.NoError(t, err)
+	require.Equal(t, "the energy energy1 was resold", message)
+	l.commit(ctx)
+	energy := l.token("energy1")
+	require.Equal(t, "sold", energy.Status)
+	require.Equal(t, "User3", energy.Owner)
+	require.Equal(t, 0.045, energy.BidPrice)
+}
+
+func TestTheTransferMovesTheEndorsementToTheOrgOfTheNewOwner(t *testing.T) {
+	l := soldToken(t)
+	auction := &chaincode.SmartContract{}
+
+	ctx := l.tx(consumer, nil)
+	err := auction.TransferToken(ctx, "energy1", "User2", "User4", "", l.now)
+	require.EqualError(t, err, "the org of the new owner is required")
+
+	ctx = l.tx(consumer, nil)
+	require.NoError(t, auction.TransferToken(ctx, "energy1", "User2", "User4", "Org3MSP", l.now))
+	l.commit(ctx)
+	require.Equal(t, "Org3MSP", l.token("energy1").BidderMSP)
+	require.Equal(t, "Org1MSP Org3MSP", endorsingOrgs(ctx, "energy1"))
+
+	// the new owner then acts on the token
+	ctx = l.tx(buyer, nil)
+	require.NoError(t, auction.Consume(ctx, "energy1", "User4", l.now))
+}
+
+func TestTheResaleMovesTheEndorsementToTheOrgOfTheBuyer(t *testing.T) {
+	l := soldToken(t)
+	auction := &chaincode.SmartContract{}
+	ctx := l.tx(consumer, nil)
+	require.NoError(t, auction.ListForResale(ctx, "energy1", "User2", 0.04, l.now))
+	l.commit(ctx)
+
+	l.now = start.Add(6 * time.Minute)
+	ctx = l.tx(buyer, nil)
+	message, err := auction.BidOnResale(ctx, "energy1", "User4", 0.045, l.now)
+	require.NoError(t, err)
+	require.Equal(t, "your bid was successful", message)
+	l.commit(ctx)
+
+	// the round is closed by the sweep as well as by ResaleEnd
+	l.now = start.Add(11 * time.Minute)
+	result, ctx := sweep(t, l)
+	require.Equal(t, []string{"energy1"}, result.Resale)
+	energy := l.token("energy1")
+	require.Equal(t, "User4", energy.Owner)
+	require.Equal(t, "Org3MSP", energy.BidderMSP)
+	require.Equal(t, "Org1MSP Org3MSP", endorsingOrgs(ctx, "energy1"))
+}
+
+func TestResaleEndSetsTheEndorsementOfTheBuyer(t *testing.T) {
+	l := soldToken(t)
+	auction := &chaincode.SmartContract{}
+	ctx := l.tx(consumer, nil)
+	require.NoError(t, auction.ListForResale(ctx, "energy1", "User2", 0.04, l.now))
+	l.commit(ctx)
+	l.now = start.Add(6 * time.Minute)
+	ctx = l.tx(buyer, nil)
+	_, err := auction.BidOnResale(ctx, "energy1", "User4", 0.045, l.now)
+	require.NoError(t, err)
+	l.commit(ctx)
+
+	l.now = start.Add(10 * time.Minute)
+	ctx = l.tx(admin, nil)
+	message, err := auction.ResaleEnd(ctx, "energy1", "User2", l.now)
+	require.NoError(t, err)
+	require.Equal(t, "the energy energy1 was resold", message)
+	require.Equal(t, "Org1MSP Org3MSP", endorsingOrgs(ctx, "energy1"))
+}
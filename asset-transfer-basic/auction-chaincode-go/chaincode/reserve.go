@@ -0,0 +1,177 @@
+package chaincode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// reserveTransientKey is the transient field carrying the producer's reserve price
+const reserveTransientKey = "reserve"
+
+// reserveGracePeriod is how long SweepExpired waits, after the lifetime of a token with
+// a reserve price and a bid, for the producer to close it (minutes)
+const reserveGracePeriod = 5
+
+// Reserve is the minimum price a producer accepts for a token. It is kept in the
+// implicit collection of the producer's org; the salt prevents guessing the price
+// from the hash that every peer can read.
+type Reserve struct {
+	ReservePrice float64 `json:"ReservePrice"`
+	Salt         string  `json:"Salt"`
+}
+
+// WithdrawToken cancels the auction of a token that has no bid yet.
+// Only a client of the producer's organization can withdraw the token.
+func (s *SmartContract) WithdrawToken(ctx contractapi.TransactionContextInterface, id string) error {
+	energy, err := s.ReadToken(ctx, id)
+	if err != nil {
+		return err
+	}
+	if energy.DocType != "token" || energy.Status != "generated" {
+		return fmt.Errorf("the energy %s is not for sale", id)
+	}
+	if energy.Owner != energy.Producer || !energy.BidTime.IsZero() {
+		return fmt.Errorf("the energy %s already has a bid", id)
+	}
+
+	err = checkProducerOrg(ctx, energy, "withdraw")
+	if err != nil {
+		return err
+	}
+
+	if energy.ReserveCollection != "" {
+		err = ctx.GetStub().DelPrivateData(energy.ReserveCollection, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete reserve price: %v", err)
+		}
+	}
+
+	energy.Status = "withdrawn"
+	err = s.UpdateToken(ctx, energy)
+	if err != nil {
+		return err
+	}
+
+	energyJSON, err := json.Marshal(energy)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().SetEvent("WithdrawToken", energyJSON)
+}
+
+// getReserve returns the reserve price passed in the transient map, or nil if there is none.
+func getReserve(ctx contractapi.TransactionContextInterface) (*Reserve, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("error getting transient: %v", err)
+	}
+	reserveJSON, ok := transientMap[reserveTransientKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var reserve Reserve
+	err = json.Unmarshal(reserveJSON, &reserve)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reserve JSON: %v", err)
+	}
+	if reserve.ReservePrice <= 0 {
+		return nil, fmt.Errorf("the reserve price must be positive")
+	}
+	if reserve.Salt == "" {
+		return nil, fmt.Errorf("the reserve price must have a salt")
+	}
+	return &reserve, nil
+}
+
+// putReserve stores the reserve price of a new token in the implicit collection of the
+// client's organization.
+func putReserve(ctx contractapi.TransactionContextInterface, energy *Energy, reserve *Reserve) error {
+	collection, err := getCollectionName(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get implicit collection name: %v", err)
+	}
+
+	// marshal the struct rather than storing the transient bytes, so that the hash
+	// can be recomputed from the same values at AuctionEnd
+	reserveJSON, err := json.Marshal(reserve)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData(collection, energy.ID, reserveJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put reserve price: %v", err)
+	}
+
+	energy.ReserveCollection = collection
+	return nil
+}
+
+// meetsReserve reports whether the bid price of a token reaches its reserve price. The
+// reserve price passed by the producer in the transient map is verified against the
+// hash of the private data, which can be read on any peer.
+func meetsReserve(ctx contractapi.TransactionContextInterface, energy *Energy) (bool, error) {
+	if energy.ReserveCollection == "" {
+		return true, nil
+	}
+
+	reserve, err := getReserve(ctx)
+	if err != nil {
+		return false, err
+	}
+	if reserve == nil {
+		return false, fmt.Errorf("the reserve price of energy %s must be passed in the transient map", energy.ID)
+	}
+
+	hash, err := ctx.GetStub().GetPrivateDataHash(energy.ReserveCollection, energy.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to read reserve price hash: %v", err)
+	}
+	reserveJSON, err := json.Marshal(reserve)
+	if err != nil {
+		return false, err
+	}
+	computedHash := sha256.Sum256(reserveJSON)
+	if !bytes.Equal(hash, computedHash[:]) {
+		return false, fmt.Errorf("the reserve price does not match the hash stored for energy %s", energy.ID)
+	}
+
+	return energy.BidPrice >= reserve.ReservePrice, nil
+}
+
+// reserveExpired reports whether the producer did not close a token with a reserve price
+// and a bid in time, e.g. because it restarted and lost the salt of the reserve price.
+// SweepExpired then closes the token as if the reserve price was not met.
+func reserveExpired(energy *Energy, timestamp time.Time) bool {
+	return !energy.GeneratedTime.After(timestamp.Add(time.Minute * -(tokenLifetime + reserveGracePeriod)))
+}
+
+// clearBid gives a token back to its producer at its unit price.
+func clearBid(energy *Energy) {
+	energy.Owner = energy.Producer
+	energy.BidPrice = energy.UnitPrice
+	energy.BidderZone = ""
+	energy.BidderGeohash = ""
+	energy.BidderMSP = ""
+	energy.BidTime = time.Time{}
+}
+
+// roundOver reports whether AuctionEnd at timestamp would decide the sale of a token.
+func roundOver(energy *Energy, timestamp time.Time) bool {
+	return !energy.GeneratedTime.After(timestamp.Add(time.Minute*-30)) ||
+		!energy.AuctionStartTime.After(timestamp.Add(time.Minute*-5))
+}
+
+// getCollectionName returns the implicit collection of the client's organization.
+func getCollectionName(ctx contractapi.TransactionContextInterface) (string, error) {
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get verified MSPID: %v", err)
+	}
+	return "_implicit_org_" + clientMSPID, nil
+}
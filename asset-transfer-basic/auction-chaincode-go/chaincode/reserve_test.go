@@ -0,0 +1,123 @@
+package chaincode_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+// reserveTransient is the transient data carrying a reserve price of the producer.
+func reserveTransient(price float64) map[string][]byte {
+	return map[string][]byte{"reserve": []byte(`{"ReservePrice":` + formatFloat(price) + `,"Salt":"salt"}`)}
+}
+
+func formatFloat(price float64) string {
+	return strconv.FormatFloat(price, 'f', -1, 64)
+}
+
+// createReservedToken creates a solar token of the producer at l.now with a reserve price.
+func createReservedToken(t *testing.T, l *testLedger, id string, price float64) {
+	t.Helper()
+	ctx := l.tx(producer, reserveTransient(price))
+	err := (&chaincode.SmartContract{}).CreateToken(ctx, id, 35.5, 139.6, "User1", "green", "solar", l.now)
+	require.NoError(t, err)
+	l.commit(ctx)
+}
+
+func auctionEnd(t *testing.T, l *testLedger, id string, price float64) string {
+	t.Helper()
+	ctx := l.tx(producer, reserveTransient(price))
+	message, err := (&chaincode.SmartContract{}).AuctionEnd(ctx, id, "User1", l.now)
+	require.NoError(t, err)
+	l.commit(ctx)
+	return message
+}
+
+func TestAuctionEndSellsOnlyAboveTheReservePrice(t *testing.T) {
+	l := newTestLedger(t)
+	createReservedToken(t, l, "energy1", 0.025)
+	createReservedToken(t, l, "energy2", 0.04)
+	l.now = start.Add(time.Minute)
+	require.Equal(t, "your bid was successful", bid(t, l, "energy1", 0.03))
+	require.Equal(t, "your bid was successful", bid(t, l, "energy2", 0.03))
+
+	l.now = start.Add(5 * time.Minute)
+	require.Equal(t, "the energy energy1 was sold", auctionEnd(t, l, "energy1", 0.025))
+	require.Equal(t, "User2", l.token("energy1").Owner)
+
+	auctionEnd(t, l, "energy2", 0.04)
+	unsold := l.token("energy2")
+	require.Equal(t, "generated", unsold.Status)
+	require.Equal(t, "User1", unsold.Owner)
+	require.Empty(t, unsold.BidderGeohash)
+
+	// a reserve price other than the one of CreateToken is rejected
+	l.now = start.Add(10 * time.Minute)
+	require.Equal(t, "your bid was successful", bid(t, l, "energy2", 0.035))
+	ctx := l.tx(producer, reserveTransient(0.01))
+	_, err := (&chaincode.SmartContract{}).AuctionEnd(ctx, "energy2", "User1", l.now)
+	require.EqualError(t, err, "the reserve price does not match the hash stored for energy energy2")
+}
+
+func TestSweepExpiredClosesTheReservedTokensTheProducerLeftOpen(t *testing.T) {
+	l := newTestLedger(t)
+	createReservedToken(t, l, "energy1", 0.025)
+	l.now = start.Add(time.Minute)
+	require.Equal(t, "your bid was successful", bid(t, l, "energy1", 0.03))
+
+	// the producer may still close the token with its reserve price
+	l.now = start.Add(30 * time.Minute)
+	result, ctx := sweep(t, l)
+	require.Empty(t, result.Old)
+	require.Empty(t, ctx.writes)
+
+	l.now = start.Add(35 * time.Minute)
+	result, _ = sweep(t, l)
+	require.Equal(t, []string{"energy1"}, result.Old)
+	energy := l.token("energy1")
+	require.Equal(t, "old", energy.Status)
+	require.Equal(t, "User1", energy.Owner)
+	require.Empty(t, energy.BidderMSP)
+}
+
+func TestOnlyTheProducerOrgWithdrawsATokenWithoutABid(t *testing.T) {
+	l := newTestLedger(t)
+	auction := &chaincode.SmartContract{}
+	createReservedToken(t, l, "energy1", 0.025)
+	createToken(t, l, "energy2")
+	l.now = start.Add(time.Minute)
+	require.Equal(t, "your bid was successful", bid(t, l, "energy2", 0.03))
+
+	err := auction.WithdrawToken(l.tx(consumer, nil), "energy1")
+	require.EqualError(t, err, "client from org Org2MSP is not authorized to withdraw the energy energy1")
+	err = auction.WithdrawToken(l.tx(producer, nil), "energy2")
+	require.EqualError(t, err, "the energy energy2 already has a bid")
+
+	ctx := l.tx(producer, nil)
+	require.NoError(t, auction.WithdrawToken(ctx, "energy1"))
+	l.commit(ctx)
+	energy := l.token("energy1")
+	require.Equal(t, "withdrawn", energy.Status)
+	require.NotContains(t, l.private[energy.ReserveCollection], "energy1")
+
+	err = auction.WithdrawToken(l.tx(producer, nil), "energy1")
+	require.EqualError(t, err, "the energy energy1 is not for sale")
+}
+
+func TestATokenWhoseBidMissedTheReserveCanBeWithdrawn(t *testing.T) {
+	l := newTestLedger(t)
+	createReservedToken(t, l, "energy1", 0.04)
+	l.now = start.Add(time.Minute)
+	require.Equal(t, "your bid was successful", bid(t, l, "energy1", 0.03))
+	l.now = start.Add(5 * time.Minute)
+	auctionEnd(t, l, "energy1", 0.04)
+	require.True(t, l.token("energy1").BidTime.IsZero())
+
+	ctx := l.tx(producer, nil)
+	require.NoError(t, (&chaincode.SmartContract{}).WithdrawToken(ctx, "energy1"))
+	l.commit(ctx)
+	require.Equal(t, "withdrawn", l.token("energy1").Status)
+}
@@ -0,0 +1,182 @@
+package chaincode
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const tokenLifetime = 30 // minutes
+
+// PriceSchedule describes a descending (Dutch) price for a token: the price falls from
+// StartPrice at the generated time to FloorPrice at the end of the token lifetime,
+// either continuously ("linear") or every StepMinutes ("step").
+type PriceSchedule struct {
+	Type        string  `json:"Type"`
+	StartPrice  float64 `json:"Start Price"`
+	FloorPrice  float64 `json:"Floor Price"`
+	StepMinutes int     `json:"Step Minutes"`
+}
+
+// SetPriceSchedule turns the auction of a token into a descending-price auction that
+// starts at its current unit price. It must be set before any bid, by a client of the
+// producer's organization.
+func (s *SmartContract) SetPriceSchedule(ctx contractapi.TransactionContextInterface,
+	id string, scheduleType string, floorPrice float64, stepMinutes int) error {
+	energy, err := s.ReadToken(ctx, id)
+	if err != nil {
+		return err
+	}
+	if energy.DocType != "token" || (energy.Status != "generated" && energy.Status != "pending") {
+		return fmt.Errorf("the energy %s is not for sale", id)
+	}
+	if energy.Owner != energy.Producer {
+		return fmt.Errorf("the energy %s already has a bid", id)
+	}
+	err = checkProducerOrg(ctx, energy, "schedule the price of")
+	if err != nil {
+		return err
+	}
+	// a scheduled token is sold by the bid itself, when the reserve price cannot be verified
+	if energy.ReserveCollection != "" {
+		return fmt.Errorf("the energy %s has a reserve price; use the floor price instead", id)
+	}
+
+	schedule := &PriceSchedule{
+		Type:        scheduleType,
+		StartPrice:  energy.UnitPrice,
+		FloorPrice:  floorPrice,
+		StepMinutes: stepMinutes,
+	}
+	if err = schedule.validate(); err != nil {
+		return err
+	}
+
+	energy.PriceSchedule = schedule
+	return s.UpdateToken(ctx, energy)
+}
+
+// CurrentPrice returns the price of a token at the transaction timestamp.
+func (s *SmartContract) CurrentPrice(ctx contractapi.TransactionContextInterface, id string) (float64, error) {
+	energy, err := s.ReadToken(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	if energy.PriceSchedule == nil {
+		return energy.UnitPrice, nil
+	}
+
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return s.scheduledPrice(ctx, energy, timestamp)
+}
+
+// scheduledPrice returns the scheduled price of a token at timestamp, with the
+// demand-response events in effect at timestamp rather than at its issue.
+func (s *SmartContract) scheduledPrice(ctx contractapi.TransactionContextInterface, energy *Energy, timestamp time.Time) (float64, error) {
+	multiplier, err := s.GetPriceMultiplier(ctx, energy.Zone, energy.SmallCategory, timestamp)
+	if err != nil {
+		return 0, err
+	}
+	price := energy.PriceSchedule.priceAt(energy.GeneratedTime, timestamp) * multiplier
+	if energy.Multiplier != 0 {
+		price /= energy.Multiplier
+	}
+	return price, nil
+}
+
+// bidOnScheduledToken sells a token with a price schedule to the first bid at or above
+// the scheduled price at the transaction timestamp, under the demand-response events
+// in effect then.
+func (s *SmartContract) bidOnScheduledToken(ctx contractapi.TransactionContextInterface,
+	energy *Energy, newOwner string, from bidder, newBidPrice float64) (string, error) {
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	if timestamp.Add(time.Minute * -tokenLifetime).After(energy.GeneratedTime) {
+		return "the energy " + energy.ID + " was generated more than 30min ago", nil
+	}
+
+	currentPrice, err := s.scheduledPrice(ctx, energy, timestamp)
+	if err != nil {
+		return "", err
+	}
+	if newBidPrice+priceTolerance < currentPrice {
+		return "your bid price is cheap", nil
+	}
+	bidderMSP, err := clientMSPID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	energy.BidTime = timestamp
+	energy.Owner = newOwner
+	energy.BidPrice = newBidPrice
+	energy.BidderZone = from.Zone
+	energy.BidderGeohash = from.Geohash
+	energy.BidderMSP = bidderMSP
+	energy.UnitPrice = currentPrice
+	energy.Status = "sold"
+	recordTransfer(energy, energy.Producer, newOwner, newBidPrice, timestamp)
+	err = s.UpdateToken(ctx, energy)
+	if err != nil {
+		return "", err
+	}
+	err = setTokenEndorsement(ctx, energy)
+	if err != nil {
+		return "", err
+	}
+	err = s.issueCertificate(ctx, energy, timestamp)
+	if err != nil {
+		return "", err
+	}
+	err = newGridUsage(ctx).use(energy)
+	if err != nil {
+		return "", err
+	}
+	return "your bid was successful", nil
+}
+
+func (p *PriceSchedule) validate() error {
+	if p.Type != "linear" && p.Type != "step" {
+		return fmt.Errorf("unknown price schedule type %s", p.Type)
+	}
+	if p.FloorPrice < 0 || p.FloorPrice > p.StartPrice {
+		return fmt.Errorf("the floor price must be between 0 and the start price %g", p.StartPrice)
+	}
+	if p.Type == "step" && (p.StepMinutes <= 0 || p.StepMinutes > tokenLifetime) {
+		return fmt.Errorf("the step must be between 1 and %d minutes", tokenLifetime)
+	}
+	return nil
+}
+
+func (p *PriceSchedule) priceAt(generatedTime time.Time, timestamp time.Time) float64 {
+	lifetime := time.Minute * tokenLifetime
+	elapsed := timestamp.Sub(generatedTime)
+	if elapsed <= 0 {
+		return p.StartPrice
+	}
+	if elapsed >= lifetime {
+		return p.FloorPrice
+	}
+	if p.Type == "step" {
+		step := time.Minute * time.Duration(p.StepMinutes)
+		elapsed = elapsed / step * step
+	}
+	price := p.StartPrice - (p.StartPrice-p.FloorPrice)*float64(elapsed)/float64(lifetime)
+	return math.Max(price, p.FloorPrice)
+}
+
+// txTime returns the transaction timestamp, which is the same on every endorsing peer.
+func txTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)), nil
+}
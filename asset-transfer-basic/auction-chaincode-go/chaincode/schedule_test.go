@@ -0,0 +1,50 @@
+package chaincode_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnlyTheProducerOrgSchedulesOrDiscountsTheToken(t *testing.T) {
+	l := newTestLedger(t)
+	createToken(t, l, "energy1")
+	createToken(t, l, "energy2")
+	auction := &chaincode.SmartContract{}
+
+	ctx := l.tx(consumer, nil)
+	err := auction.SetPriceSchedule(ctx, "energy1", "linear", 0.01, 0)
+	require.EqualError(t, err, "client from org Org2MSP is not authorized to schedule the price of the energy energy1")
+	err = auction.DiscountUnitPrice(ctx, "energy2")
+	require.EqualError(t, err, "client from org Org2MSP is not authorized to discount the energy energy2")
+
+	ctx = l.tx(producer, nil)
+	require.NoError(t, auction.SetPriceSchedule(ctx, "energy1", "linear", 0.01, 0))
+	require.NoError(t, auction.DiscountUnitPrice(ctx, "energy2"))
+	l.commit(ctx)
+	require.Equal(t, 0.02, l.token("energy1").PriceSchedule.StartPrice)
+	require.InDelta(t, 0.016, l.token("energy2").UnitPrice, 1e-12)
+}
+
+func TestTheScheduledPriceFallsToTheFloorPrice(t *testing.T) {
+	l := newTestLedger(t)
+	createToken(t, l, "energy1")
+	auction := &chaincode.SmartContract{}
+	ctx := l.tx(producer, nil)
+	require.NoError(t, auction.SetPriceSchedule(ctx, "energy1", "linear", 0.01, 0))
+	l.commit(ctx)
+
+	// halfway through the lifetime of the token
+	l.now = start.Add(15 * time.Minute)
+	price, err := auction.CurrentPrice(l.tx(consumer, nil), "energy1")
+	require.NoError(t, err)
+	require.InDelta(t, 0.015, price, 1e-12)
+
+	require.Equal(t, "your bid price is cheap", bid(t, l, "energy1", 0.014))
+	require.Equal(t, "your bid was successful", bid(t, l, "energy1", 0.015))
+	energy := l.token("energy1")
+	require.Equal(t, "sold", energy.Status)
+	require.Equal(t, "User2", energy.Owner)
+}
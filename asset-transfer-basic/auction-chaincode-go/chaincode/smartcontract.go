@@ -31,6 +31,34 @@ type Energy struct {
 	Producer         string    `json:"Producer"`
 	SmallCategory    string    `json:"SmallCategory"`
 	Status           string    `json:"Status"`
+	PriceSchedule    *PriceSchedule `json:"Price Schedule,omitempty" metadata:"Price Schedule,optional"`
+	Discounted       bool      `json:"Discounted,omitempty" metadata:"Discounted,optional"`
+	ProducerMSP      string    `json:"Producer MSP,omitempty" metadata:"Producer MSP,optional"`
+	ReserveCollection string   `json:"Reserve Collection,omitempty" metadata:"Reserve Collection,optional"`
+	Seller           string    `json:"Seller,omitempty" metadata:"Seller,optional"`
+	ResalePrice      float64   `json:"Resale Price,omitempty" metadata:"Resale Price,optional"`
+	Transfers        []Transfer `json:"Transfers,omitempty" metadata:"Transfers,optional"`
+	ConsumedTime     time.Time `json:"Consumed Time,omitempty" metadata:"Consumed Time,optional"`
+	Zone             string    `json:"Zone,omitempty" metadata:"Zone,optional"`
+	BidderZone       string    `json:"Bidder Zone,omitempty" metadata:"Bidder Zone,optional"`
+	Geohash          string    `json:"Geohash,omitempty" metadata:"Geohash,optional"`
+	BidderGeohash    string    `json:"Bidder Geohash,omitempty" metadata:"Bidder Geohash,optional"`
+	BidderMSP        string    `json:"Bidder MSP,omitempty" metadata:"Bidder MSP,optional"`
+	Multiplier       float64   `json:"Multiplier,omitempty" metadata:"Multiplier,optional"`
+}
+
+// MarshalJSON leaves out the consumed time of a token that was not consumed, which
+// omitempty does not do for a time, so that such tokens keep their JSON.
+func (e Energy) MarshalJSON() ([]byte, error) {
+	type energy Energy
+	var consumedTime *time.Time
+	if !e.ConsumedTime.IsZero() {
+		consumedTime = &e.ConsumedTime
+	}
+	return json.Marshal(struct {
+		energy
+		ConsumedTime *time.Time `json:"Consumed Time,omitempty"`
+	}{energy(e), consumedTime})
 }
 
 // InitLedger adds a base set of assets to the ledger
@@ -101,12 +129,29 @@ func (s *SmartContract) UpdateUnitPrice(ctx contractapi.TransactionContextInterf
 		return nil
 }
 
+// DiscountUnitPrice lowers the unit price of a token by 20% for its last round.
+// A token can be discounted only once, by a client of the producer's organization;
+// tokens with a price schedule are not discounted.
 func (s *SmartContract) DiscountUnitPrice(ctx contractapi.TransactionContextInterface, id string) (error) {
 		energy, err := s.ReadToken(ctx, id)
 		if err != nil {
 			return err
 		}
+		if energy.DocType != "token" || energy.Status != "generated" {
+			return fmt.Errorf("the energy %s is not for sale", id)
+		}
+		if energy.PriceSchedule != nil {
+			return fmt.Errorf("the energy %s has a price schedule", id)
+		}
+		if energy.Discounted {
+			return fmt.Errorf("the energy %s was already discounted", id)
+		}
+		err = checkProducerOrg(ctx, energy, "discount")
+		if err != nil {
+			return err
+		}
 		energy.UnitPrice = energy.UnitPrice * 0.8
+		energy.Discounted = true
 
 		energyJSON, err := json.Marshal(energy)
 			if err != nil {
@@ -121,8 +166,25 @@ func (s *SmartContract) DiscountUnitPrice(ctx contractapi.TransactionContextInte
 // errorは返り値の型
 // 引数は、ID、緯度、経度、エネルギーの種類、発電した時間、発電者、価格
 // トークンには、オーナー、ステータスも含める
+// 最低価格 (reserve price) は任意で、transient mapの"reserve"で渡す
+// 発行数はクライアントごとの上限 (SetMarketQuota) まで
 func (s *SmartContract) CreateToken(ctx contractapi.TransactionContextInterface,
 	id string, latitude float64, longitude float64, producer string, largeCategory string, smallCategory string, timestamp time.Time) error {
+	quota, err := newClientQuota(ctx)
+	if err != nil {
+		return err
+	}
+	reserve, err := getReserve(ctx)
+	if err != nil {
+		return err
+	}
+	return s.createToken(ctx, quota, reserve, id, latitude, longitude, producer, largeCategory, smallCategory, timestamp)
+}
+
+// createToken issues a token with the reserve price, nil for none. The token is counted
+// in the quota once it is written.
+func (s *SmartContract) createToken(ctx contractapi.TransactionContextInterface, quota *clientQuota, reserve *Reserve,
+	id string, latitude float64, longitude float64, producer string, largeCategory string, smallCategory string, timestamp time.Time) error {
 
 	var costId = smallCategory + "-power-cost"
 
@@ -141,6 +203,10 @@ func (s *SmartContract) CreateToken(ctx contractapi.TransactionContextInterface,
 	if exists {
 		return fmt.Errorf("the energy %s already exists", id)
 	}
+	err = quota.checkToken()
+	if err != nil {
+		return err
+	}
 
 	energy := Energy{
 		DocType:          "token",
@@ -157,38 +223,142 @@ func (s *SmartContract) CreateToken(ctx contractapi.TransactionContextInterface,
 		UnitPrice:        cost.UnitPrice,
 		BidPrice:         cost.UnitPrice,
 	}
+
+	energy.ProducerMSP, err = ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get verified MSPID: %v", err)
+	}
+
+	zone, err := s.zoneAt(ctx, latitude, longitude)
+	if err != nil {
+		return err
+	}
+	if zone != nil {
+		energy.Zone = zone.ID
+	}
+	energy.Geohash = encodeGeohash(latitude, longitude, tokenGeohashPrecision)
+
+	// a demand-response event in effect surges or discounts the unit price
+	multiplier, err := s.GetPriceMultiplier(ctx, energy.Zone, smallCategory, timestamp)
+	if err != nil {
+		return err
+	}
+	if multiplier != 1 {
+		energy.UnitPrice = cost.UnitPrice * multiplier
+		energy.BidPrice = energy.UnitPrice
+		energy.Multiplier = multiplier
+	}
+
+	// with a metering oracle, the token is biddable only after ConfirmGeneration
+	oracle, err := s.getMeterOracle(ctx)
+	if err != nil {
+		return err
+	}
+	if oracle != nil {
+		energy.Status = "pending"
+	}
+
+	if reserve != nil {
+		err = putReserve(ctx, &energy, reserve)
+		if err != nil {
+			return err
+		}
+	}
+
 	energyJSON, err := json.Marshal(energy)
 	if err != nil {
 		return err
 	}
 
-	return ctx.GetStub().PutState(id, energyJSON)
+	err = ctx.GetStub().PutState(id, energyJSON)
+	if err != nil {
+		return err
+	}
+	err = setTokenEndorsement(ctx, &energy)
+	if err != nil {
+		return err
+	}
+	return quota.useToken()
 }
 
 // TransferAsset updates the owner field of asset with given id in world state, and returns the old owner.
 // 購入する
+// 送電容量のあるゾーンのトークンには、BidOnTokenPrivateで需要家の位置を渡す
+// 最高額の入札中のトークン数はクライアントごとの上限 (SetMarketQuota) まで
 func (s *SmartContract) BidOnToken(ctx contractapi.TransactionContextInterface, id string, newOwner string, newBidPrice float64, timestamp time.Time) (string, error) {
+	quota, err := newClientQuota(ctx)
+	if err != nil {
+		return "", err
+	}
+	return quota.bid(id, newOwner, func() (string, error) {
+		return s.bidOnToken(ctx, id, newOwner, newBidPrice, timestamp)
+	})
+}
+
+func (s *SmartContract) bidOnToken(ctx contractapi.TransactionContextInterface, id string, newOwner string, newBidPrice float64, timestamp time.Time) (string, error) {
 	energy, err := s.ReadToken(ctx, id)
 	if err != nil {
 		return "", err
 	}
+	if energy.Zone != "" {
+		return "the location of the bidder is required for the energy " + id + " in zone " + energy.Zone, nil
+	}
+	return s.bid(ctx, energy, newOwner, bidder{}, newBidPrice, timestamp)
+}
+
+// bid places a bid; the zone and geohash of the bidder are empty when they are not known.
+func (s *SmartContract) bid(ctx contractapi.TransactionContextInterface,
+	energy *Energy, newOwner string, from bidder, newBidPrice float64, timestamp time.Time) (string, error) {
+	id := energy.ID
+	if energy.DocType != "token" || energy.Status != "generated" {
+		return "the energy " + id + " is not for sale", nil
+	}
+	if energy.PriceSchedule != nil {
+		return s.bidOnScheduledToken(ctx, energy, newOwner, from, newBidPrice)
+	}
 	var returnMessage string
 	//generatedTime := energy.GeneratedTime
 	var generatedTimeCompare = timestamp.Add(time.Minute * -30)
 	var auctionStartTimeCompare = timestamp.Add(time.Minute * -5)
 
+	// the bids follow the demand-response events in effect at the time of the
+	// transaction, which the bidder cannot backdate
+	txTimestamp, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	multiplier, err := s.GetPriceMultiplier(ctx, energy.Zone, energy.SmallCategory, txTimestamp)
+	if err != nil {
+		return "", err
+	}
+	demandPrice := unmultipliedPrice(energy) * multiplier
+
 	if generatedTimeCompare.After(energy.GeneratedTime) == true {
 		returnMessage = "the energy " + id + " was generated more than 30min ago"
 	}else if auctionStartTimeCompare.After(energy.AuctionStartTime) == true {
 		returnMessage = "the auction of energy " + id + " was started more than 5min ago"
+	} else if newBidPrice+priceTolerance < demandPrice {
+		returnMessage = fmt.Sprintf("your bid price is below the demand-response price %g", demandPrice)
 	} else {
-		if energy.BidPrice >= newBidPrice {
+		// without a bid, the floor is the demand price rather than the price at issue
+		floorPrice := demandPrice
+		if energy.Owner != energy.Producer {
+			floorPrice = energy.BidPrice
+		}
+		if floorPrice >= newBidPrice {
 			returnMessage = "your bid price is cheap"
 		}else{
 			// energy.Status = "sold"
+			bidderMSP, err := clientMSPID(ctx)
+			if err != nil {
+				return "", err
+			}
 			energy.BidTime = timestamp
 			energy.Owner = newOwner
 			energy.BidPrice = newBidPrice
+			energy.BidderZone = from.Zone
+			energy.BidderGeohash = from.Geohash
+			energy.BidderMSP = bidderMSP
 			energyJSON, err := json.Marshal(energy)
 			if err != nil {
 				return "", err
@@ -198,6 +368,11 @@ func (s *SmartContract) BidOnToken(ctx contractapi.TransactionContextInterface,
 			if err != nil {
 				return "", err
 			}
+			// the bidder's org now endorses the changes to the token
+			err = setTokenEndorsement(ctx, energy)
+			if err != nil {
+				return "", err
+			}
 			returnMessage = "your bid was successful"
 		}
 	}
@@ -217,14 +392,84 @@ func (s *SmartContract) BidOnToken(ctx contractapi.TransactionContextInterface,
 
 func (s *SmartContract) AuctionEnd(ctx contractapi.TransactionContextInterface, id string, producer string, timestamp time.Time) (string, error) {
 	energy, err := s.ReadToken(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if energy.Status == "withdrawn" {
+		return "the energy " + id + " was withdrawn", nil
+	}
+	// a closed auction is not closed again, so that the sale is recorded once
+	if energy.Status == "old" {
+		return "the energy " + id + " was generated more than 30min ago. This was not sold.", nil
+	}
+	if energy.Status != "generated" && energy.Status != "pending" {
+		return "the energy " + id + " was sold", nil
+	}
 
-	var returnMessage string
-	var generatedTimeCompare = timestamp.Add(time.Minute * -30)
-	var auctionStartTimeCompare = timestamp.Add(time.Minute * -5)
+	// a winning bid below the producer's reserve price does not sell the token
+	if energy.Owner != energy.Producer && roundOver(energy, timestamp) {
+		met, err := meetsReserve(ctx, energy)
+		if err != nil {
+			return "", err
+		}
+		if !met {
+			clearBid(energy)
+		}
+	}
 
+	returnMessage, _, err := s.endAuctionRound(ctx, newGridUsage(ctx), energy, timestamp)
 	if err != nil {
 		return "", err
 	}
+	return returnMessage, nil
+}
+
+// endAuctionRound closes the round of energy at timestamp, for AuctionEnd and
+// SweepExpired. When the round is over, a token whose winner's zone can no longer be
+// delivered to goes back to its producer first. A changed token is written with its
+// endorsement policy, its certificate and the grid capacity it uses.
+func (s *SmartContract) endAuctionRound(ctx contractapi.TransactionContextInterface, grid *gridUsage,
+	energy *Energy, timestamp time.Time) (string, bool, error) {
+	if roundOver(energy, timestamp) {
+		err := clearGridCapacity(grid, energy, timestamp)
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	returnMessage, changed := closeAuctionRound(energy, timestamp)
+	if !changed {
+		return returnMessage, false, nil
+	}
+
+	err := s.UpdateToken(ctx, energy)
+	if err != nil {
+		return "", false, err
+	}
+	// the sold token is endorsed by the orgs of the producer and the winner from now on
+	err = setTokenEndorsement(ctx, energy)
+	if err != nil {
+		return "", false, err
+	}
+	err = s.issueCertificate(ctx, energy, timestamp)
+	if err != nil {
+		return "", false, err
+	}
+	err = grid.use(energy)
+	if err != nil {
+		return "", false, err
+	}
+	return returnMessage, true, nil
+}
+
+// closeAuctionRound applies the end-of-round transition to energy at timestamp:
+// tokens older than 30min become sold or old, tokens whose 5min round is over become
+// sold or start a new round. It reports whether the token was changed.
+func closeAuctionRound(energy *Energy, timestamp time.Time) (string, bool) {
+	var returnMessage string
+	var generatedTimeCompare = timestamp.Add(time.Minute * -30)
+	var auctionStartTimeCompare = timestamp.Add(time.Minute * -5)
+	id := energy.ID
 
 	if energy.GeneratedTime.After(generatedTimeCompare) == false {
 		if energy.Owner == energy.Producer {
@@ -232,6 +477,7 @@ func (s *SmartContract) AuctionEnd(ctx contractapi.TransactionContextInterface,
 			returnMessage = "the energy " + id + " was generated more than 30min ago. This was not sold."
 		}else{
 			energy.Status = "sold"
+			recordTransfer(energy, energy.Producer, energy.Owner, energy.BidPrice, timestamp)
 			returnMessage = "the energy " + id + " was sold. It was generetad more than 30min ago."
 		}
 	}else{
@@ -241,18 +487,14 @@ func (s *SmartContract) AuctionEnd(ctx contractapi.TransactionContextInterface,
 				returnMessage = "the energy " + id + " was generated more than 5min ago. The Action Start Time was updated."
 			}else{
 				energy.Status = "sold"
+				recordTransfer(energy, energy.Producer, energy.Owner, energy.BidPrice, timestamp)
 				returnMessage = "the energy " + id + " was sold"
 			}
 		}else{
-			returnMessage = ("Why did you call this function?")
+			return "Why did you call this function?", false
 		}
 	}
-
-	err = s.UpdateToken(ctx, energy)
-	if err != nil {
-		return "", err
-	}
-	return returnMessage, nil
+	return returnMessage, true
 }
 
 // AssetExists returns true when asset with given ID exists in world state
@@ -387,7 +629,7 @@ func (s *SmartContract) QueryByLocationRange(ctx contractapi.TransactionContextI
 	return energies, nil
 }
 
-// GetAllAssets returns all assets found in world state
+// GetAllAssets returns all tokens found in world state
 func (s *SmartContract) GetAllTokens(ctx contractapi.TransactionContextInterface) ([]*Energy, error) {
 	// range query with empty string for startKey and endKey does an
 	// open-ended query of all assets in the chaincode namespace.
@@ -409,6 +651,10 @@ func (s *SmartContract) GetAllTokens(ctx contractapi.TransactionContextInterface
 		if err != nil {
 			return nil, err
 		}
+		// the namespace also holds zones, certificates, meter readings, quotas...
+		if energy.DocType != "token" {
+			continue
+		}
 		energies = append(energies, &energy)
 	}
 
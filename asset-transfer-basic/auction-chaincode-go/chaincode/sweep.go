@@ -0,0 +1,167 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// SweepResult lists the tokens changed by SweepExpired
+type SweepResult struct {
+	Sold     []string `json:"Sold"`
+	Old      []string `json:"Old"`
+	Extended []string `json:"Extended"`
+	Resale   []string `json:"Resale"`
+	Forward  []string `json:"Forward"`
+	// price multipliers deleted after their end
+	PriceMultipliers []string `json:"Price Multipliers"`
+}
+
+// SweepExpired closes the auctions that nobody closed in time, e.g. because the producer
+// process stopped. It applies the AuctionEnd transition, at the transaction timestamp,
+// to at most maxCount generated or pending tokens whose round or 30min lifetime is over, and
+// closes the resale rounds and the forward auctions that are over. The price multipliers
+// that have ended are deleted within the same count. A token with a reserve price and a
+// bid is left to its producer until reserveGracePeriod after its lifetime, and is then
+// closed as unsold. At most maxCount generated and maxCount pending tokens are read.
+func (s *SmartContract) SweepExpired(ctx contractapi.TransactionContextInterface, maxCount int) (*SweepResult, error) {
+	if maxCount <= 0 {
+		return nil, fmt.Errorf("maxCount must be positive")
+	}
+
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the tokens waiting for ConfirmGeneration expire like the generated ones
+	energies, err := s.queryByStatusUpTo(ctx, "generated", maxCount)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := s.queryByStatusUpTo(ctx, "pending", maxCount)
+	if err != nil {
+		return nil, err
+	}
+	energies = append(energies, pending...)
+
+	grid := newGridUsage(ctx)
+	result := &SweepResult{Sold: []string{}, Old: []string{}, Extended: []string{}, Resale: []string{}, Forward: []string{},
+		PriceMultipliers: []string{}}
+	swept := 0
+	for _, energy := range energies {
+		if swept >= maxCount {
+			break
+		}
+
+		// the reserve price can only be verified by the producer calling AuctionEnd, so the
+		// bid is dropped only when the producer left the token open past its lifetime
+		if energy.ReserveCollection != "" && energy.Owner != energy.Producer {
+			if !reserveExpired(energy, timestamp) {
+				continue
+			}
+			clearBid(energy)
+		}
+
+		_, changed, err := s.endAuctionRound(ctx, grid, energy, timestamp)
+		if err != nil {
+			return nil, err
+		}
+		if !changed {
+			continue
+		}
+		swept++
+
+		switch energy.Status {
+		case "sold":
+			result.Sold = append(result.Sold, energy.ID)
+		case "old":
+			result.Old = append(result.Old, energy.ID)
+		default:
+			result.Extended = append(result.Extended, energy.ID)
+		}
+	}
+
+	resales, err := s.QueryByStatus(ctx, "resale")
+	if err != nil {
+		return nil, err
+	}
+	for _, energy := range resales {
+		if swept >= maxCount {
+			break
+		}
+		_, changed := closeResaleRound(energy, timestamp)
+		if !changed {
+			continue
+		}
+		err = s.UpdateToken(ctx, energy)
+		if err != nil {
+			return nil, err
+		}
+		err = setTokenEndorsement(ctx, energy)
+		if err != nil {
+			return nil, err
+		}
+		swept++
+		result.Resale = append(result.Resale, energy.ID)
+	}
+
+	forwards, err := s.QueryForwardsByStatus(ctx, "open")
+	if err != nil {
+		return nil, err
+	}
+	for _, forward := range forwards {
+		if swept >= maxCount {
+			break
+		}
+		_, changed := closeForwardAuction(forward, timestamp)
+		if !changed {
+			continue
+		}
+		err = s.putForward(ctx, forward)
+		if err != nil {
+			return nil, err
+		}
+		swept++
+		result.Forward = append(result.Forward, forward.ID)
+	}
+
+	if swept < maxCount {
+		result.PriceMultipliers, err = s.sweepPriceMultipliers(ctx, timestamp, maxCount-swept)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// queryByStatusUpTo returns at most limit tokens of a status. The query is closed after
+// them, so that the read set of the sweep holds a page of the tokens on sale rather than
+// all of them and does not conflict with every concurrent bid. A paginated query would
+// not do, as the peer rejects the writes of a transaction that made one.
+func (s *SmartContract) queryByStatusUpTo(ctx contractapi.TransactionContextInterface, status string, limit int) ([]*Energy, error) {
+	queryString := fmt.Sprintf(`{"selector":{"DocType":"token","Status":"%s"},"limit":%d,"use_index":["_design/indexStatusDoc","indexStatus"]}`, status, limit)
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var energies []*Energy
+	for len(energies) < limit && resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var energy Energy
+		err = json.Unmarshal(queryResponse.Value, &energy)
+		if err != nil {
+			return nil, err
+		}
+		energies = append(energies, &energy)
+	}
+	return energies, nil
+}
@@ -0,0 +1,141 @@
+package chaincode_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+func sweep(t *testing.T, l *testLedger) (*chaincode.SweepResult, *transaction) {
+	t.Helper()
+	ctx := l.tx(admin, nil)
+	result, err := (&chaincode.SmartContract{}).SweepExpired(ctx, 100)
+	require.NoError(t, err)
+	l.commit(ctx)
+	return result, ctx
+}
+
+// endorsingOrgs returns the orgs of the state-based endorsement policy set on key in tx.
+func endorsingOrgs(tx *transaction, key string) string {
+	orgs := ""
+	for i := 0; i < tx.stub.SetStateValidationParameterCallCount(); i++ {
+		policyKey, policy := tx.stub.SetStateValidationParameterArgsForCall(i)
+		if policyKey == key {
+			orgs = ""
+			for _, org := range []string{"Org1MSP", "Org2MSP", "Org3MSP"} {
+				if strings.Contains(string(policy), org) {
+					orgs += org + " "
+				}
+			}
+		}
+	}
+	return strings.TrimSpace(orgs)
+}
+
+func TestSweepExpiredSellsTheTokenAndSetsTheEndorsementOfTheWinner(t *testing.T) {
+	l := newTestLedger(t)
+	createToken(t, l, "energy1")
+	createToken(t, l, "energy2")
+	l.now = start.Add(time.Minute)
+	require.Equal(t, "your bid was successful", bid(t, l, "energy1", 0.03))
+
+	// nothing is swept before the end of the round
+	l.now = start.Add(4 * time.Minute)
+	result, ctx := sweep(t, l)
+	require.Empty(t, result.Sold)
+	require.Empty(t, result.Extended)
+	require.Empty(t, ctx.writes)
+
+	l.now = start.Add(5 * time.Minute)
+	result, ctx = sweep(t, l)
+	require.Equal(t, []string{"energy1"}, result.Sold)
+	require.Equal(t, []string{"energy2"}, result.Extended)
+
+	sold := l.token("energy1")
+	require.Equal(t, "sold", sold.Status)
+	require.Equal(t, "User2", sold.Owner)
+	require.Equal(t, "Org1MSP Org2MSP", endorsingOrgs(ctx, "energy1"))
+	require.Equal(t, "Org1MSP", endorsingOrgs(ctx, "energy2"))
+	_, err := (&chaincode.SmartContract{}).ReadCertificate(ctx, "energy1-certificate")
+	require.NoError(t, err, "expected a certificate for the sold token")
+}
+
+func TestSweepExpiredClosesThePendingTokens(t *testing.T) {
+	l := newTestLedger(t)
+	// with a metering oracle, the tokens wait for ConfirmGeneration
+	l.state[meterOracleKey] = []byte(`{"DocType":"meterOracle","MSPID":"Org3MSP"}`)
+	createToken(t, l, "energy1")
+	require.Equal(t, "pending", l.token("energy1").Status)
+
+	l.now = start.Add(5 * time.Minute)
+	result, _ := sweep(t, l)
+	require.Equal(t, []string{"energy1"}, result.Extended)
+	require.Equal(t, l.now, l.token("energy1").AuctionStartTime.UTC())
+
+	l.now = start.Add(30 * time.Minute)
+	result, _ = sweep(t, l)
+	require.Equal(t, []string{"energy1"}, result.Old)
+	require.Equal(t, "old", l.token("energy1").Status)
+}
+
+func TestAuctionEndWritesNothingBeforeTheEndOfTheRound(t *testing.T) {
+	l := newTestLedger(t)
+	createToken(t, l, "energy1")
+	l.now = start.Add(time.Minute)
+	require.Equal(t, "your bid was successful", bid(t, l, "energy1", 0.03))
+
+	l.now = start.Add(3 * time.Minute)
+	ctx := l.tx(producer, nil)
+	message, err := (&chaincode.SmartContract{}).AuctionEnd(ctx, "energy1", "User1", l.now)
+	require.NoError(t, err)
+	require.Equal(t, "Why did you call this function?", message)
+	require.Empty(t, ctx.writes)
+	require.Zero(t, ctx.stub.SetStateValidationParameterCallCount())
+
+	l.now = start.Add(5 * time.Minute)
+	ctx = l.tx(producer, nil)
+	message, err = (&chaincode.SmartContract{}).AuctionEnd(ctx, "energy1", "User1", l.now)
+	require.NoError(t, err)
+	l.commit(ctx)
+	require.Equal(t, "the energy energy1 was sold", message)
+	require.Equal(t, "sold", l.token("energy1").Status)
+}
+
+// countingIterator counts the query results read by the chaincode.
+type countingIterator struct {
+	shim.StateQueryIteratorInterface
+	read *int
+}
+
+func (it countingIterator) Next() (*queryresult.KV, error) {
+	*it.read++
+	return it.StateQueryIteratorInterface.Next()
+}
+
+func TestSweepExpiredReadsAPageOfTheTokensOnSale(t *testing.T) {
+	l := newTestLedger(t)
+	for _, id := range []string{"energy1", "energy2", "energy3", "energy4"} {
+		createToken(t, l, id)
+	}
+
+	l.now = start.Add(5 * time.Minute)
+	ctx := l.tx(admin, nil)
+	read := 0
+	ctx.stub.GetQueryResultStub = func(query string) (shim.StateQueryIteratorInterface, error) {
+		it, err := l.query(query)
+		return countingIterator{it, &read}, err
+	}
+	result, err := (&chaincode.SmartContract{}).SweepExpired(ctx, 2)
+	require.NoError(t, err)
+	l.commit(ctx)
+	require.Equal(t, []string{"energy1", "energy2"}, result.Extended)
+	require.Equal(t, 2, read)
+
+	result, _ = sweep(t, l)
+	require.Equal(t, []string{"energy3", "energy4"}, result.Extended)
+}
@@ -0,0 +1,322 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// capacityWindow is the time window of the transfer capacity between zones. A token
+// uses the capacity of the window of its generated time.
+const capacityWindow = 30 // minutes
+
+// Zone is a rectangular area of the grid. Tokens are tagged with the zone of their
+// coordinates when they are created.
+type Zone struct {
+	DocType      string  `json:"DocType"`
+	ID           string  `json:"ID"`
+	LatitudeMin  float64 `json:"Latitude Min"`
+	LatitudeMax  float64 `json:"Latitude Max"`
+	LongitudeMin float64 `json:"Longitude Min"`
+	LongitudeMax float64 `json:"Longitude Max"`
+}
+
+// ZoneLink is the transfer capacity from one zone to another per capacity window.
+type ZoneLink struct {
+	DocType  string  `json:"DocType"`
+	From     string  `json:"From"`
+	To       string  `json:"To"`
+	Capacity float64 `json:"Capacity"` // kWh
+}
+
+// LinkUsage is the capacity of a link used by the tokens of one window.
+type LinkUsage struct {
+	DocType string    `json:"DocType"`
+	From    string    `json:"From"`
+	To      string    `json:"To"`
+	Window  time.Time `json:"Window"`
+	Used    float64   `json:"Used"` // kWh
+}
+
+// CreateZone adds or replaces a zone. Zones are matched in ID order, so the first of
+// overlapping zones wins. Only a market admin can define the zones.
+func (s *SmartContract) CreateZone(ctx contractapi.TransactionContextInterface,
+	id string, latitudeMin float64, latitudeMax float64, longitudeMin float64, longitudeMax float64) error {
+	err := ctx.GetClientIdentity().AssertAttributeValue(marketAdminAttribute, "true")
+	if err != nil {
+		return fmt.Errorf("client is not a market admin: %v", err)
+	}
+	if latitudeMin > latitudeMax || longitudeMin > longitudeMax {
+		return fmt.Errorf("invalid bounds of zone %s", id)
+	}
+	zone := Zone{
+		DocType:      "zone",
+		ID:           id,
+		LatitudeMin:  latitudeMin,
+		LatitudeMax:  latitudeMax,
+		LongitudeMin: longitudeMin,
+		LongitudeMax: longitudeMax,
+	}
+	zoneJSON, err := json.Marshal(zone)
+	if err != nil {
+		return err
+	}
+	key, err := zoneKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, zoneJSON)
+}
+
+// SetZoneCapacity sets the transfer capacity from one zone to another. Trades between
+// zones without a link are rejected. Only a market admin can set it.
+func (s *SmartContract) SetZoneCapacity(ctx contractapi.TransactionContextInterface, from string, to string, capacity float64) error {
+	err := ctx.GetClientIdentity().AssertAttributeValue(marketAdminAttribute, "true")
+	if err != nil {
+		return fmt.Errorf("client is not a market admin: %v", err)
+	}
+	if capacity < 0 {
+		return fmt.Errorf("the capacity must not be negative")
+	}
+	for _, id := range []string{from, to} {
+		key, err := zoneKey(ctx, id)
+		if err != nil {
+			return err
+		}
+		zoneJSON, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return fmt.Errorf("failed to read from world state: %v", err)
+		}
+		if zoneJSON == nil {
+			return fmt.Errorf("the zone %s does not exist", id)
+		}
+	}
+
+	linkJSON, err := json.Marshal(ZoneLink{DocType: "zoneLink", From: from, To: to, Capacity: capacity})
+	if err != nil {
+		return err
+	}
+	key, err := linkKey(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, linkJSON)
+}
+
+// GetAllZones returns the zones in ID order.
+func (s *SmartContract) GetAllZones(ctx contractapi.TransactionContextInterface) ([]*Zone, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(zoneObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var zones []*Zone
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var zone Zone
+		err = json.Unmarshal(queryResponse.Value, &zone)
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, &zone)
+	}
+
+	sort.Slice(zones, func(i, j int) bool { return zones[i].ID < zones[j].ID })
+	return zones, nil
+}
+
+// RemainingCapacity returns the capacity left from one zone to another in the window
+// of timestamp.
+func (s *SmartContract) RemainingCapacity(ctx contractapi.TransactionContextInterface,
+	from string, to string, timestamp time.Time) (float64, error) {
+	return newGridUsage(ctx).remaining(from, to, timestamp)
+}
+
+// bidFrom checks the grid capacity to the zone of the location and places the bid. A
+// bid from another zone is rejected when the transfer capacity left for the token's
+// window is too small.
+func (s *SmartContract) bidFrom(ctx contractapi.TransactionContextInterface, energy *Energy, newOwner string,
+	from bidder, newBidPrice float64, timestamp time.Time, latitude float64, longitude float64) (string, error) {
+	if energy.Zone != "" {
+		zone, err := s.zoneAt(ctx, latitude, longitude)
+		if err != nil {
+			return "", err
+		}
+		if zone == nil {
+			return "your location is not in any zone", nil
+		}
+		from.Zone = zone.ID
+
+		enough, err := newGridUsage(ctx).allows(energy, from.Zone)
+		if err != nil {
+			return "", err
+		}
+		if !enough {
+			return "the grid capacity from zone " + energy.Zone + " to zone " + from.Zone + " is exhausted", nil
+		}
+	}
+
+	return s.bid(ctx, energy, newOwner, from, newBidPrice, timestamp)
+}
+
+// zoneAt returns the zone of the coordinates, or nil if they are not in any zone.
+func (s *SmartContract) zoneAt(ctx contractapi.TransactionContextInterface, latitude float64, longitude float64) (*Zone, error) {
+	zones, err := s.GetAllZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, zone := range zones {
+		if zone.LatitudeMin <= latitude && latitude <= zone.LatitudeMax &&
+			zone.LongitudeMin <= longitude && longitude <= zone.LongitudeMax {
+			return zone, nil
+		}
+	}
+	return nil, nil
+}
+
+// clearGridCapacity gives a token back to its producer at the end of the round when
+// the capacity to the winning bidder's zone was used up by other trades meanwhile.
+func clearGridCapacity(grid *gridUsage, energy *Energy, timestamp time.Time) error {
+	if energy.Owner == energy.Producer || !roundOver(energy, timestamp) {
+		return nil
+	}
+	enough, err := grid.allows(energy, energy.BidderZone)
+	if err != nil {
+		return err
+	}
+	if !enough {
+		clearBid(energy)
+	}
+	return nil
+}
+
+// gridUsage keeps the link usage written in the transaction, since GetState does not
+// return the writes of the transaction itself.
+type gridUsage struct {
+	ctx   contractapi.TransactionContextInterface
+	usage map[string]*LinkUsage
+}
+
+func newGridUsage(ctx contractapi.TransactionContextInterface) *gridUsage {
+	return &gridUsage{ctx: ctx, usage: map[string]*LinkUsage{}}
+}
+
+// allows reports whether the token can be delivered to bidderZone.
+func (g *gridUsage) allows(energy *Energy, bidderZone string) (bool, error) {
+	if energy.Zone == "" || bidderZone == "" || bidderZone == energy.Zone {
+		return true, nil
+	}
+	remaining, err := g.remaining(energy.Zone, bidderZone, energy.GeneratedTime)
+	if err != nil {
+		return false, err
+	}
+	quantity, err := energyQuantity(g.ctx, energy)
+	if err != nil {
+		return false, err
+	}
+	return remaining >= quantity, nil
+}
+
+// use records the delivery of a sold token to the zone of its owner.
+func (g *gridUsage) use(energy *Energy) error {
+	if energy.Status != "sold" || energy.Zone == "" || energy.BidderZone == "" || energy.BidderZone == energy.Zone {
+		return nil
+	}
+	usage, err := g.get(energy.Zone, energy.BidderZone, energy.GeneratedTime)
+	if err != nil {
+		return err
+	}
+	quantity, err := energyQuantity(g.ctx, energy)
+	if err != nil {
+		return err
+	}
+	usage.Used += quantity
+
+	usageJSON, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	key, err := usageKey(g.ctx, usage.From, usage.To, usage.Window)
+	if err != nil {
+		return err
+	}
+	return g.ctx.GetStub().PutState(key, usageJSON)
+}
+
+func (g *gridUsage) remaining(from string, to string, timestamp time.Time) (float64, error) {
+	key, err := linkKey(g.ctx, from, to)
+	if err != nil {
+		return 0, err
+	}
+	linkJSON, err := g.ctx.GetStub().GetState(key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if linkJSON == nil {
+		return 0, nil
+	}
+	var link ZoneLink
+	err = json.Unmarshal(linkJSON, &link)
+	if err != nil {
+		return 0, err
+	}
+
+	usage, err := g.get(from, to, timestamp)
+	if err != nil {
+		return 0, err
+	}
+	return link.Capacity - usage.Used, nil
+}
+
+func (g *gridUsage) get(from string, to string, timestamp time.Time) (*LinkUsage, error) {
+	window := timestamp.UTC().Truncate(time.Minute * capacityWindow)
+	key, err := usageKey(g.ctx, from, to, window)
+	if err != nil {
+		return nil, err
+	}
+	if usage, ok := g.usage[key]; ok {
+		return usage, nil
+	}
+
+	usage := &LinkUsage{DocType: "linkUsage", From: from, To: to, Window: window}
+	usageJSON, err := g.ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if usageJSON != nil {
+		err = json.Unmarshal(usageJSON, usage)
+		if err != nil {
+			return nil, err
+		}
+	}
+	g.usage[key] = usage
+	return usage, nil
+}
+
+// the zone documents are kept under composite keys, apart from the token IDs chosen by
+// the producers
+const (
+	zoneObjectType      = "zone"
+	zoneLinkObjectType  = "zoneLink"
+	linkUsageObjectType = "linkUsage"
+)
+
+func zoneKey(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(zoneObjectType, []string{id})
+}
+
+func linkKey(ctx contractapi.TransactionContextInterface, from string, to string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(zoneLinkObjectType, []string{from, to})
+}
+
+func usageKey(ctx contractapi.TransactionContextInterface, from string, to string, window time.Time) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(linkUsageObjectType, []string{from, to, window.Format(time.RFC3339)})
+}
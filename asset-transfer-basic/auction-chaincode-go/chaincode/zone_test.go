@@ -0,0 +1,95 @@
+package chaincode_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/asset-transfer-basic/chaincode-go/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+// zonedLedger returns a ledger with the zones west and east, where 1kWh per window
+// can be delivered from east to west.
+func zonedLedger(t *testing.T) *testLedger {
+	l := newTestLedger(t)
+	auction := &chaincode.SmartContract{}
+	ctx := l.tx(admin, nil)
+	require.NoError(t, auction.CreateZone(ctx, "west", 35, 36, 139, 139.5))
+	require.NoError(t, auction.CreateZone(ctx, "east", 35, 36, 139.5, 140))
+	l.commit(ctx)
+	ctx = l.tx(admin, nil)
+	require.NoError(t, auction.SetZoneCapacity(ctx, "east", "west", 1))
+	l.commit(ctx)
+	return l
+}
+
+// bidFrom bids on a token as the consumer from a private location at l.now.
+func bidFrom(t *testing.T, l *testLedger, id string, price float64, latitude float64, longitude float64) string {
+	t.Helper()
+	location := `{"Latitude":` + formatFloat(latitude) + `,"Longitude":` + formatFloat(longitude) + `,"Salt":"salt"}`
+	ctx := l.tx(consumer, map[string][]byte{"location": []byte(location)})
+	message, err := (&chaincode.SmartContract{}).BidOnTokenPrivate(ctx, id, "User2", price, l.now)
+	require.NoError(t, err)
+	l.commit(ctx)
+	return message
+}
+
+func TestOnlyAMarketAdminDefinesTheZones(t *testing.T) {
+	l := zonedLedger(t)
+	auction := &chaincode.SmartContract{}
+	err := auction.CreateZone(l.tx(producer, nil), "north", 36, 37, 139, 140)
+	require.EqualError(t, err, "client is not a market admin: attribute 'market.admin' was not found")
+	err = auction.SetZoneCapacity(l.tx(producer, nil), "west", "east", 100)
+	require.EqualError(t, err, "client is not a market admin: attribute 'market.admin' was not found")
+
+	// a token cannot take the key of a zone
+	createToken(t, l, "west-zone")
+	zones, err := auction.GetAllZones(l.tx(consumer, nil))
+	require.NoError(t, err)
+	require.Len(t, zones, 2)
+	require.Equal(t, "east", zones[0].ID)
+}
+
+func TestTheTokensAreTaggedWithTheirZone(t *testing.T) {
+	l := zonedLedger(t)
+	createToken(t, l, "energy1")
+	require.Equal(t, "east", l.token("energy1").Zone)
+
+	zones, err := (&chaincode.SmartContract{}).GetAllZones(l.tx(consumer, nil))
+	require.NoError(t, err)
+	require.Len(t, zones, 2)
+	require.Equal(t, "east", zones[0].ID)
+
+	err = (&chaincode.SmartContract{}).SetZoneCapacity(l.tx(admin, nil), "east", "north", 1)
+	require.EqualError(t, err, "the zone north does not exist")
+}
+
+func TestBidsAcrossZonesAreLimitedByTheGridCapacity(t *testing.T) {
+	l := zonedLedger(t)
+	createToken(t, l, "energy1")
+	createToken(t, l, "energy2")
+	l.now = start.Add(time.Minute)
+
+	require.Equal(t, "your location is not in any zone", bidFrom(t, l, "energy1", 0.1, 35.5, 138.9))
+	require.Equal(t, "the location of the bidder is required for the energy energy1 in zone east", bid(t, l, "energy1", 0.1))
+
+	// both bids fit the capacity, but only the first token sold can be delivered
+	require.Equal(t, "your bid was successful", bidFrom(t, l, "energy1", 0.1, 35.5, 139.4))
+	require.Equal(t, "your bid was successful", bidFrom(t, l, "energy2", 0.1, 35.5, 139.4))
+	require.Equal(t, "west", l.token("energy1").BidderZone)
+
+	l.now = start.Add(5 * time.Minute)
+	result, _ := sweep(t, l)
+	require.Equal(t, []string{"energy1"}, result.Sold)
+	require.Equal(t, []string{"energy2"}, result.Extended)
+	require.Equal(t, "User1", l.token("energy2").Owner)
+
+	remaining, err := (&chaincode.SmartContract{}).RemainingCapacity(l.tx(admin, nil), "east", "west", start)
+	require.NoError(t, err)
+	require.Zero(t, remaining)
+
+	l.now = start.Add(6 * time.Minute)
+	require.Equal(t, "the grid capacity from zone east to zone west is exhausted", bidFrom(t, l, "energy2", 0.1, 35.5, 139.4))
+	// within the zone the capacity does not apply
+	require.Equal(t, "your bid was successful", bidFrom(t, l, "energy2", 0.1, 35.5, 139.7))
+}